@@ -0,0 +1,176 @@
+// Package audit implements the append-only audit log used by pcompose
+package audit
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+const (
+	// ActionAuthSuccess is logged when a client successfully authenticates.
+	ActionAuthSuccess = "auth-success"
+
+	// ActionAuthFailure is logged when a client fails an authentication attempt.
+	ActionAuthFailure = "auth-failure"
+
+	// ActionPush is logged for every ref updated by a push.
+	ActionPush = "push"
+
+	// ActionExec is logged for every exec request.
+	ActionExec = "exec"
+
+	// ActionShell is logged for every interactive shell session.
+	ActionShell = "shell"
+
+	// ActionLogs is logged for every container logs session.
+	ActionLogs = "logs"
+
+	// ActionAttach is logged for every container attach session.
+	ActionAttach = "attach"
+
+	// ActionAPI is logged for every management API call that changes a project.
+	ActionAPI = "api"
+
+	// ActionWebhook is logged for every inbound webhook from a git host.
+	ActionWebhook = "webhook"
+)
+
+// Entry is a single line in the audit log.
+type Entry struct {
+	Time        time.Time  `json:"time"`
+	Action      string     `json:"action"`
+	User        string     `json:"user,omitempty"`
+	Identity    string     `json:"identity,omitempty"`
+	Fingerprint string     `json:"fingerprint,omitempty"`
+	KeyID       string     `json:"key_id,omitempty"`
+	RemoteAddr  string     `json:"remote_addr,omitempty"`
+	Method      string     `json:"method,omitempty"`
+	Target      string     `json:"target,omitempty"`
+	Payload     string     `json:"payload,omitempty"`
+	Repo        string     `json:"repo,omitempty"`
+	Ref         string     `json:"ref,omitempty"`
+	OldRev      string     `json:"old_rev,omitempty"`
+	NewRev      string     `json:"new_rev,omitempty"`
+	Start       *time.Time `json:"start,omitempty"`
+	End         *time.Time `json:"end,omitempty"`
+	ExitStatus  *int       `json:"exit_status,omitempty"`
+	Error       string     `json:"error,omitempty"`
+}
+
+var (
+	// writer is where audit entries are written to. Nil disables the audit log.
+	writer io.Writer
+
+	// writerMu serializes writes so entries are never interleaved.
+	writerMu sync.Mutex
+)
+
+// Setup initializes the audit log writer from the loaded configuration.
+func Setup() {
+	writerMu.Lock()
+	defer writerMu.Unlock()
+
+	if !viper.GetBool("audit-log") {
+		writer = nil
+		return
+	}
+
+	writer = &lumberjack.Logger{
+		Filename:   viper.GetString("audit-log-path"),
+		MaxSize:    viper.GetInt("audit-log-max-size"),
+		MaxBackups: viper.GetInt("audit-log-max-backups"),
+		MaxAge:     viper.GetInt("audit-log-max-age"),
+		Compress:   viper.GetBool("audit-log-compress"),
+	}
+}
+
+// Log writes an entry to the audit log as a single JSON line.
+func Log(entry Entry) {
+	writerMu.Lock()
+	defer writerMu.Unlock()
+
+	if writer == nil {
+		return
+	}
+
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		log.Println("Error marshaling audit entry:", err)
+		return
+	}
+
+	_, err = writer.Write(append(data, '\n'))
+	if err != nil {
+		log.Println("Error writing audit entry:", err)
+	}
+}
+
+// Session returns a copy of the entry with the start, end and exit status set.
+func (e Entry) Session(start time.Time, exitStatus int, err error) Entry {
+	end := time.Now()
+
+	e.Start = &start
+	e.End = &end
+	e.ExitStatus = &exitStatus
+
+	if err != nil {
+		e.Error = err.Error()
+	}
+
+	return e
+}
+
+// PushLog creates an empty file the post-receive hook records the ref updates of a push in.
+// The file is passed to the hook using utils.PushLogEnv and removed by the caller.
+func PushLog() (string, error) {
+	pushLog, err := os.CreateTemp("", "pcompose-push-*")
+	if err != nil {
+		return "", err
+	}
+
+	return pushLog.Name(), pushLog.Close()
+}
+
+// Pushes logs a push entry based on entry for every "<old-rev> <new-rev> <ref>" line the
+// post-receive hook recorded in pushLog and returns the number of updated refs.
+func Pushes(entry Entry, repo string, pushLog string) int {
+	data, err := os.ReadFile(pushLog)
+	if err != nil {
+		log.Println("Error reading push log:", err)
+		return 0
+	}
+
+	updated := 0
+
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			continue
+		}
+
+		pushEntry := entry
+		pushEntry.Action = ActionPush
+		pushEntry.Repo = repo
+		pushEntry.Ref = fields[2]
+		pushEntry.OldRev = fields[0]
+		pushEntry.NewRev = fields[1]
+
+		Log(pushEntry)
+
+		updated++
+	}
+
+	return updated
+}
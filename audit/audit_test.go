@@ -0,0 +1,70 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestPushes(t *testing.T) {
+	var buf bytes.Buffer
+
+	writer = &buf
+	defer func() { writer = nil }()
+
+	pushLog, err := PushLog()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.Remove(pushLog)
+
+	zeroRev := strings.Repeat("0", 40)
+	oldRev := strings.Repeat("a", 40)
+	newRev := strings.Repeat("b", 40)
+
+	lines := zeroRev + " " + newRev + " refs/heads/main\n" + oldRev + " " + zeroRev + " refs/heads/old\n\n"
+
+	err = os.WriteFile(pushLog, []byte(lines), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	updated := Pushes(Entry{User: "alice/app", Identity: "alice"}, "alice/app", pushLog)
+	if updated != 2 {
+		t.Fatalf("Pushes() = %d, want 2", updated)
+	}
+
+	var entries []Entry
+
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var entry Entry
+
+		err := json.Unmarshal([]byte(line), &entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		entries = append(entries, entry)
+	}
+
+	want := []Entry{
+		{Action: ActionPush, User: "alice/app", Identity: "alice", Repo: "alice/app", Ref: "refs/heads/main", OldRev: zeroRev, NewRev: newRev},
+		{Action: ActionPush, User: "alice/app", Identity: "alice", Repo: "alice/app", Ref: "refs/heads/old", OldRev: oldRev, NewRev: zeroRev},
+	}
+
+	for i, entry := range entries {
+		entry.Time = want[i].Time
+		if entry != want[i] {
+			t.Errorf("entry %d = %+v, want %+v", i, entry, want[i])
+		}
+	}
+}
+
+func TestPushesMissingLog(t *testing.T) {
+	if updated := Pushes(Entry{}, "alice/app", "/nonexistent/push-log"); updated != 0 {
+		t.Errorf("Pushes() = %d, want 0", updated)
+	}
+}
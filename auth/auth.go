@@ -0,0 +1,237 @@
+// Package auth implements certificate authentication and project authorization used by pcompose
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/antoniomika/pcompose/project"
+	"github.com/spf13/viper"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// NamespacesExtension is the permissions extension holding the comma separated
+	// project patterns a connection may access. Connections without it are unrestricted.
+	NamespacesExtension = "pcompose-namespaces"
+
+	// KeyIDExtension is the permissions extension holding the key ID of the certificate used to authenticate.
+	KeyIDExtension = "pcompose-key-id"
+
+	// SerialExtension is the permissions extension holding the serial of the certificate used to authenticate.
+	SerialExtension = "pcompose-serial"
+)
+
+var (
+	// ErrKeyRevoked is returned when a key or certificate is listed in the key revocation list.
+	ErrKeyRevoked = errors.New("key is revoked")
+
+	// ErrInvalidCertificate is wrapped by the errors returned when a certificate is rejected.
+	ErrInvalidCertificate = errors.New("invalid certificate")
+)
+
+// WrapCallbacks wraps the public key callback of the ssh server in order to accept
+// certificates signed by the configured certificate authorities and to enforce the KRL.
+func WrapCallbacks(sshConfig *ssh.ServerConfig) {
+	publicKeyCallback := sshConfig.PublicKeyCallback
+
+	sshConfig.PublicKeyCallback = func(c ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+		krl, err := loadKRL()
+		if err != nil {
+			log.Println("Error loading KRL:", err)
+			return nil, errors.New("unable to load key revocation list")
+		}
+
+		if krl != nil && krl.IsRevoked(key) {
+			return nil, ErrKeyRevoked
+		}
+
+		if cert, ok := key.(*ssh.Certificate); ok {
+			perms, err := checkCertificate(cert)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %s", ErrInvalidCertificate, err)
+			}
+
+			return perms, nil
+		}
+
+		if publicKeyCallback == nil {
+			return nil, errors.New("public key authentication is not configured")
+		}
+
+		perms, err := publicKeyCallback(c, key)
+		if err != nil {
+			return perms, err
+		}
+
+		if perms == nil {
+			perms = &ssh.Permissions{}
+		}
+
+		if perms.Extensions == nil {
+			perms.Extensions = map[string]string{}
+		}
+
+		perms.Extensions[IdentityExtension] = keyIdentity(key)
+
+		return perms, nil
+	}
+}
+
+// checkCertificate verifies a user certificate and maps its principals to project namespaces.
+func checkCertificate(cert *ssh.Certificate) (*ssh.Permissions, error) {
+	if cert.CertType != ssh.UserCert {
+		return nil, errors.New("certificate is not a user certificate")
+	}
+
+	caKeys, err := loadCAKeys()
+	if err != nil {
+		log.Println("Error loading CA keys:", err)
+		return nil, errors.New("unable to load certificate authorities")
+	}
+
+	checker := &ssh.CertChecker{
+		SupportedCriticalOptions: []string{"source-address"},
+		IsUserAuthority: func(auth ssh.PublicKey) bool {
+			authBytes := auth.Marshal()
+
+			for _, caKey := range caKeys {
+				if string(caKey.Marshal()) == string(authBytes) {
+					return true
+				}
+			}
+
+			return false
+		},
+	}
+
+	if !checker.IsUserAuthority(cert.SignatureKey) {
+		return nil, errors.New("certificate is not signed by a trusted authority")
+	}
+
+	// CheckCert validates the principal against the certificate, so use one of the
+	// certificate's own principals. Access is granted using the namespace mapping instead.
+	principal := ""
+	if len(cert.ValidPrincipals) > 0 {
+		principal = cert.ValidPrincipals[0]
+	}
+
+	err = checker.CheckCert(principal, cert)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ssh.Permissions{
+		CriticalOptions: cert.CriticalOptions,
+		Extensions: map[string]string{
+			NamespacesExtension: strings.Join(PrincipalNamespaces(cert.ValidPrincipals), ","),
+			IdentityExtension:   cert.KeyId,
+			KeyIDExtension:      cert.KeyId,
+			SerialExtension:     strconv.FormatUint(cert.Serial, 10),
+		},
+	}, nil
+}
+
+// PrincipalNamespaces returns the project patterns the principals are mapped to.
+func PrincipalNamespaces(principals []string) []string {
+	// Viper lowercases map keys, so principals are matched case insensitively.
+	mapping := viper.GetStringMapStringSlice("authentication-ca-principals")
+
+	var namespaces []string
+	for _, principal := range principals {
+		namespaces = append(namespaces, mapping[strings.ToLower(principal)]...)
+	}
+
+	return namespaces
+}
+
+// Authorized returns whether a connection with the given permissions may access a project,
+// given as a path (user/httpbin). Names that don't resolve to a single project are never authorized.
+func Authorized(perms *ssh.Permissions, name string) bool {
+	name, err := project.CleanName(name)
+	if err != nil {
+		return false
+	}
+
+	// Deployments belong to the namespace of their repository.
+	name, _, _ = strings.Cut(name, project.DeploymentSeparator)
+
+	if viper.GetBool("namespace-per-user") {
+		identity := Identity(perms)
+		if !IsAdmin(identity) && !inNamespace(identity, name) {
+			return false
+		}
+	}
+
+	if perms == nil {
+		return true
+	}
+
+	namespaces, restricted := perms.Extensions[NamespacesExtension]
+	if !restricted {
+		return true
+	}
+
+	for _, pattern := range strings.Split(namespaces, ",") {
+		if pattern == "" {
+			continue
+		}
+
+		if match, err := path.Match(pattern, name); err == nil && match {
+			return true
+		}
+	}
+
+	return false
+}
+
+// loadCAKeys loads the certificate authority public keys from the configured file.
+func loadCAKeys() ([]ssh.PublicKey, error) {
+	caKeysFile := viper.GetString("authentication-ca-keys")
+	if caKeysFile == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(caKeysFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var caKeys []ssh.PublicKey
+
+	for len(data) > 0 {
+		caKey, _, _, rest, err := ssh.ParseAuthorizedKey(data)
+		if err != nil {
+			if len(caKeys) == 0 {
+				return nil, fmt.Errorf("unable to parse CA keys: %w", err)
+			}
+
+			break
+		}
+
+		caKeys = append(caKeys, caKey)
+		data = rest
+	}
+
+	return caKeys, nil
+}
+
+// loadKRL loads the configured key revocation list, if any.
+func loadKRL() (*KRL, error) {
+	krlFile := viper.GetString("authentication-krl")
+	if krlFile == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(krlFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseKRL(data)
+}
@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/viper"
+	"golang.org/x/crypto/ssh"
+)
+
+// IdentityExtension is the permissions extension holding the username of the authenticated identity.
+const IdentityExtension = "pcompose-identity"
+
+// Identity returns the username of the identity a connection authenticated as.
+func Identity(perms *ssh.Permissions) string {
+	if perms == nil {
+		return ""
+	}
+
+	return perms.Extensions[IdentityExtension]
+}
+
+// keyIdentity returns the username a public key belongs to. Keys are named
+// after the file in the authentication keys directory they are stored in,
+// so the keys in alice.pub belong to alice.
+func keyIdentity(key ssh.PublicKey) string {
+	keysDir := viper.GetString("authentication-keys-directory")
+
+	entries, err := os.ReadDir(keysDir)
+	if err != nil {
+		log.Println("Error reading authentication keys directory:", err)
+		return ""
+	}
+
+	keyBytes := string(key.Marshal())
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(keysDir, entry.Name()))
+		if err != nil {
+			continue
+		}
+
+		for len(data) > 0 {
+			authorizedKey, _, _, rest, err := ssh.ParseAuthorizedKey(data)
+			if err != nil {
+				break
+			}
+
+			if string(authorizedKey.Marshal()) == keyBytes {
+				return strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name()))
+			}
+
+			data = rest
+		}
+	}
+
+	return ""
+}
+
+// Namespaces returns the namespaces an identity may create and access projects in,
+// which is its own namespace and the namespace of every organization it is a member of.
+func Namespaces(identity string) []string {
+	if identity == "" {
+		return nil
+	}
+
+	namespaces := []string{identity}
+
+	for organization, members := range viper.GetStringMapStringSlice("namespace-organizations") {
+		for _, member := range members {
+			if member == identity {
+				namespaces = append(namespaces, organization)
+				break
+			}
+		}
+	}
+
+	return namespaces
+}
+
+// IsAdmin returns whether an identity is exempt from namespace restrictions.
+func IsAdmin(identity string) bool {
+	if identity == "" {
+		return false
+	}
+
+	for _, admin := range strings.Split(viper.GetString("namespace-admins"), ",") {
+		if strings.TrimSpace(admin) == identity {
+			return true
+		}
+	}
+
+	return false
+}
+
+// inNamespace returns whether a clean project path (user/httpbin) lives under one of the namespaces
+// of an identity. Namespaces are compared by whole path segments, so alice can't access alicebob/app.
+func inNamespace(identity string, project string) bool {
+	for _, namespace := range Namespaces(identity) {
+		if strings.HasPrefix(project, strings.Trim(namespace, "/")+"/") {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,73 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestAuthorizedNamespaces(t *testing.T) {
+	viper.Set("namespace-per-user", true)
+	viper.Set("namespace-admins", "root")
+	viper.Set("namespace-organizations", map[string][]string{"payments": {"alice"}})
+
+	defer viper.Reset()
+
+	perms := func(identity string) *ssh.Permissions {
+		return &ssh.Permissions{Extensions: map[string]string{IdentityExtension: identity}}
+	}
+
+	tests := []struct {
+		identity string
+		project  string
+		want     bool
+	}{
+		{identity: "alice", project: "alice/app", want: true},
+		{identity: "alice", project: "/alice/app.git", want: true},
+		{identity: "alice", project: "alice/app:api", want: true},
+		{identity: "alice", project: "payments/app", want: true},
+		{identity: "alice", project: "bob/app", want: false},
+		{identity: "alice", project: "alice/../bob/app", want: false},
+		{identity: "alice", project: "alice/app:../../bob", want: false},
+		{identity: "alice", project: "alicebob/app", want: false},
+		{identity: "alice", project: "alice_bob/app", want: false},
+		{identity: "alice", project: "alice_app", want: false},
+		{identity: "alice", project: "alice", want: false},
+		{identity: "bob", project: "payments/app", want: false},
+		{identity: "", project: "alice/app", want: false},
+		{identity: "root", project: "bob/app", want: true},
+		{identity: "root", project: "bob/../../etc", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.identity+" "+tt.project, func(t *testing.T) {
+			if got := Authorized(perms(tt.identity), tt.project); got != tt.want {
+				t.Errorf("Authorized(%q, %q) = %v, want %v", tt.identity, tt.project, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthorizedPrincipalNamespaces(t *testing.T) {
+	perms := &ssh.Permissions{Extensions: map[string]string{NamespacesExtension: "alice/*,shared/app"}}
+
+	tests := []struct {
+		project string
+		want    bool
+	}{
+		{project: "alice/app", want: true},
+		{project: "shared/app", want: true},
+		{project: "shared/other", want: false},
+		{project: "alice_app", want: false},
+		{project: "alice/../bob/app", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.project, func(t *testing.T) {
+			if got := Authorized(perms, tt.project); got != tt.want {
+				t.Errorf("Authorized(%q) = %v, want %v", tt.project, got, tt.want)
+			}
+		})
+	}
+}
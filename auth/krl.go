@@ -0,0 +1,331 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// krlMagic is the magic number every OpenSSH KRL starts with.
+const krlMagic = "SSHKRL\n\x00"
+
+// KRL section types as described in OpenSSH's PROTOCOL.krl.
+const (
+	krlSectionCertificates      = 1
+	krlSectionExplicitKey       = 2
+	krlSectionFingerprintSHA1   = 3
+	krlSectionSignature         = 4
+	krlSectionFingerprintSHA256 = 5
+
+	krlSectionCertSerialList   = 0x20
+	krlSectionCertSerialRange  = 0x21
+	krlSectionCertSerialBitmap = 0x22
+	krlSectionCertKeyID        = 0x23
+)
+
+// serialRange is an inclusive range of revoked certificate serials.
+type serialRange struct {
+	min uint64
+	max uint64
+}
+
+// krlCertificates holds the certificates revoked for a single CA.
+type krlCertificates struct {
+	caKey   []byte
+	serials map[uint64]bool
+	ranges  []serialRange
+	keyIDs  map[string]bool
+}
+
+// KRL is a parsed OpenSSH key revocation list.
+type KRL struct {
+	certificates []*krlCertificates
+	keys         map[string]bool
+	sha1         map[string]bool
+	sha256       map[string]bool
+}
+
+// krlReader reads the SSH wire encoding used by KRLs.
+type krlReader struct {
+	data []byte
+}
+
+func (r *krlReader) empty() bool {
+	return len(r.data) == 0
+}
+
+func (r *krlReader) byte() (byte, error) {
+	if len(r.data) < 1 {
+		return 0, errors.New("krl: unexpected end of data")
+	}
+
+	b := r.data[0]
+	r.data = r.data[1:]
+
+	return b, nil
+}
+
+func (r *krlReader) uint32() (uint32, error) {
+	if len(r.data) < 4 {
+		return 0, errors.New("krl: unexpected end of data")
+	}
+
+	v := binary.BigEndian.Uint32(r.data)
+	r.data = r.data[4:]
+
+	return v, nil
+}
+
+func (r *krlReader) uint64() (uint64, error) {
+	if len(r.data) < 8 {
+		return 0, errors.New("krl: unexpected end of data")
+	}
+
+	v := binary.BigEndian.Uint64(r.data)
+	r.data = r.data[8:]
+
+	return v, nil
+}
+
+func (r *krlReader) string() ([]byte, error) {
+	length, err := r.uint32()
+	if err != nil {
+		return nil, err
+	}
+
+	if uint32(len(r.data)) < length {
+		return nil, errors.New("krl: unexpected end of data")
+	}
+
+	v := r.data[:length]
+	r.data = r.data[length:]
+
+	return v, nil
+}
+
+// ParseKRL parses a binary OpenSSH key revocation list as created by ssh-keygen -k.
+func ParseKRL(data []byte) (*KRL, error) {
+	if !bytes.HasPrefix(data, []byte(krlMagic)) {
+		return nil, errors.New("krl: invalid magic")
+	}
+
+	r := &krlReader{data: data[len(krlMagic):]}
+
+	version, err := r.uint32()
+	if err != nil {
+		return nil, err
+	}
+
+	if version != 1 {
+		return nil, fmt.Errorf("krl: unsupported format version %d", version)
+	}
+
+	// krl_version, generated_date and flags.
+	for i := 0; i < 3; i++ {
+		if _, err := r.uint64(); err != nil {
+			return nil, err
+		}
+	}
+
+	// reserved and comment.
+	for i := 0; i < 2; i++ {
+		if _, err := r.string(); err != nil {
+			return nil, err
+		}
+	}
+
+	krl := &KRL{
+		keys:   map[string]bool{},
+		sha1:   map[string]bool{},
+		sha256: map[string]bool{},
+	}
+
+	for !r.empty() {
+		sectionType, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+
+		sectionData, err := r.string()
+		if err != nil {
+			return nil, err
+		}
+
+		section := &krlReader{data: sectionData}
+
+		switch sectionType {
+		case krlSectionCertificates:
+			certs, err := parseKRLCertificates(section)
+			if err != nil {
+				return nil, err
+			}
+
+			krl.certificates = append(krl.certificates, certs)
+		case krlSectionExplicitKey, krlSectionFingerprintSHA1, krlSectionFingerprintSHA256:
+			set := krl.keys
+			if sectionType == krlSectionFingerprintSHA1 {
+				set = krl.sha1
+			} else if sectionType == krlSectionFingerprintSHA256 {
+				set = krl.sha256
+			}
+
+			for !section.empty() {
+				blob, err := section.string()
+				if err != nil {
+					return nil, err
+				}
+
+				set[string(blob)] = true
+			}
+		case krlSectionSignature:
+			// Signatures over the KRL are not verified, the file is trusted as configured.
+		default:
+			return nil, fmt.Errorf("krl: unsupported section type %d", sectionType)
+		}
+	}
+
+	return krl, nil
+}
+
+// parseKRLCertificates parses a certificates section of a KRL.
+func parseKRLCertificates(r *krlReader) (*krlCertificates, error) {
+	caKey, err := r.string()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := r.string(); err != nil {
+		return nil, err
+	}
+
+	certs := &krlCertificates{
+		caKey:   caKey,
+		serials: map[uint64]bool{},
+		keyIDs:  map[string]bool{},
+	}
+
+	for !r.empty() {
+		sectionType, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+
+		sectionData, err := r.string()
+		if err != nil {
+			return nil, err
+		}
+
+		section := &krlReader{data: sectionData}
+
+		switch sectionType {
+		case krlSectionCertSerialList:
+			for !section.empty() {
+				serial, err := section.uint64()
+				if err != nil {
+					return nil, err
+				}
+
+				certs.serials[serial] = true
+			}
+		case krlSectionCertSerialRange:
+			min, err := section.uint64()
+			if err != nil {
+				return nil, err
+			}
+
+			max, err := section.uint64()
+			if err != nil {
+				return nil, err
+			}
+
+			certs.ranges = append(certs.ranges, serialRange{min: min, max: max})
+		case krlSectionCertSerialBitmap:
+			offset, err := section.uint64()
+			if err != nil {
+				return nil, err
+			}
+
+			bitmap, err := section.string()
+			if err != nil {
+				return nil, err
+			}
+
+			bits := new(big.Int).SetBytes(bitmap)
+			for i := 0; i < bits.BitLen(); i++ {
+				if bits.Bit(i) == 1 {
+					certs.serials[offset+uint64(i)] = true
+				}
+			}
+		case krlSectionCertKeyID:
+			for !section.empty() {
+				keyID, err := section.string()
+				if err != nil {
+					return nil, err
+				}
+
+				certs.keyIDs[string(keyID)] = true
+			}
+		default:
+			return nil, fmt.Errorf("krl: unsupported certificate section type %d", sectionType)
+		}
+	}
+
+	return certs, nil
+}
+
+// keyRevoked returns whether a plain key is revoked explicitly or by fingerprint.
+func (k *KRL) keyRevoked(key ssh.PublicKey) bool {
+	blob := key.Marshal()
+
+	sha1Sum := sha1.Sum(blob)
+	sha256Sum := sha256.Sum256(blob)
+
+	return k.keys[string(blob)] || k.sha1[string(sha1Sum[:])] || k.sha256[string(sha256Sum[:])]
+}
+
+// IsRevoked returns whether a key or certificate is revoked by the KRL.
+func (k *KRL) IsRevoked(key ssh.PublicKey) bool {
+	cert, ok := key.(*ssh.Certificate)
+	if !ok {
+		return k.keyRevoked(key)
+	}
+
+	if k.keyRevoked(cert.Key) || k.keyRevoked(cert.SignatureKey) {
+		return true
+	}
+
+	caKey := cert.SignatureKey.Marshal()
+
+	for _, certs := range k.certificates {
+		if len(certs.caKey) > 0 && !bytes.Equal(certs.caKey, caKey) {
+			continue
+		}
+
+		if certs.keyIDs[cert.KeyId] {
+			return true
+		}
+
+		// Serials are only meaningful for a specific CA.
+		if len(certs.caKey) == 0 {
+			continue
+		}
+
+		if certs.serials[cert.Serial] {
+			return true
+		}
+
+		for _, r := range certs.ranges {
+			if cert.Serial >= r.min && cert.Serial <= r.max {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,222 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// newTestSigner returns a new ed25519 key.
+func newTestSigner(t *testing.T) ssh.Signer {
+	t.Helper()
+
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := ssh.NewSignerFromKey(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return signer
+}
+
+// newTestCertificate returns a user certificate for a new key signed by ca.
+func newTestCertificate(t *testing.T, ca ssh.Signer, serial uint64, keyID string) *ssh.Certificate {
+	t.Helper()
+
+	cert := &ssh.Certificate{
+		Key:             newTestSigner(t).PublicKey(),
+		Serial:          serial,
+		CertType:        ssh.UserCert,
+		KeyId:           keyID,
+		ValidPrincipals: []string{"alice"},
+		ValidBefore:     ssh.CertTimeInfinity,
+	}
+
+	err := cert.SignCert(rand.Reader, ca)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cert
+}
+
+// generateKRL creates a KRL from a ssh-keygen -k revocation spec, optionally for the certificates of ca.
+func generateKRL(t *testing.T, ca ssh.PublicKey, spec []string) *KRL {
+	t.Helper()
+
+	sshKeygen, err := exec.LookPath("ssh-keygen")
+	if err != nil {
+		t.Skip("ssh-keygen is not available")
+	}
+
+	dir := t.TempDir()
+	specFile := filepath.Join(dir, "spec")
+	krlFile := filepath.Join(dir, "krl")
+
+	err = os.WriteFile(specFile, []byte(strings.Join(spec, "\n")+"\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	args := []string{"-k", "-f", krlFile}
+
+	if ca != nil {
+		caFile := filepath.Join(dir, "ca.pub")
+
+		err := os.WriteFile(caFile, ssh.MarshalAuthorizedKey(ca), 0o600)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		args = append(args, "-s", caFile)
+	}
+
+	output, err := exec.Command(sshKeygen, append(args, specFile)...).CombinedOutput()
+	if err != nil {
+		t.Fatalf("ssh-keygen -k failed: %v: %s", err, output)
+	}
+
+	data, err := os.ReadFile(krlFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	krl, err := ParseKRL(data)
+	if err != nil {
+		t.Fatalf("ParseKRL() error = %v", err)
+	}
+
+	return krl
+}
+
+// authorizedKey returns a key in the format of an authorized_keys line without the newline.
+func authorizedKey(key ssh.PublicKey) string {
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+}
+
+func TestKRLKeys(t *testing.T) {
+	explicit := newTestSigner(t).PublicKey()
+	sha1Key := newTestSigner(t).PublicKey()
+	sha256Key := newTestSigner(t).PublicKey()
+	valid := newTestSigner(t).PublicKey()
+
+	krl := generateKRL(t, nil, []string{
+		"key: " + authorizedKey(explicit),
+		"sha1: " + authorizedKey(sha1Key),
+		"hash: " + ssh.FingerprintSHA256(sha256Key),
+	})
+
+	tests := []struct {
+		name string
+		key  ssh.PublicKey
+		want bool
+	}{
+		{name: "explicit key", key: explicit, want: true},
+		{name: "sha1 fingerprint", key: sha1Key, want: true},
+		{name: "sha256 fingerprint", key: sha256Key, want: true},
+		{name: "not revoked", key: valid, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := krl.IsRevoked(tt.key); got != tt.want {
+				t.Errorf("IsRevoked() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKRLCertificates(t *testing.T) {
+	ca := newTestSigner(t)
+	otherCA := newTestSigner(t)
+	revokedKey := newTestSigner(t).PublicKey()
+
+	spec := []string{
+		"serial: 5",
+		"serial: 10-20",
+		"id: bob@example.com",
+		"key: " + authorizedKey(revokedKey),
+	}
+
+	// Many scattered serials are written as a bitmap.
+	for serial := 101; serial < 200; serial += 2 {
+		spec = append(spec, fmt.Sprintf("serial: %d", serial))
+	}
+
+	krl := generateKRL(t, ca.PublicKey(), spec)
+
+	revokedKeyCert := newTestCertificate(t, ca, 1, "carol@example.com")
+	revokedKeyCert.Key = revokedKey
+
+	err := revokedKeyCert.SignCert(rand.Reader, ca)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		cert *ssh.Certificate
+		want bool
+	}{
+		{name: "serial", cert: newTestCertificate(t, ca, 5, "alice@example.com"), want: true},
+		{name: "serial range start", cert: newTestCertificate(t, ca, 10, "alice@example.com"), want: true},
+		{name: "serial range end", cert: newTestCertificate(t, ca, 20, "alice@example.com"), want: true},
+		{name: "serial after range", cert: newTestCertificate(t, ca, 21, "alice@example.com"), want: false},
+		{name: "serial bitmap", cert: newTestCertificate(t, ca, 151, "alice@example.com"), want: true},
+		{name: "serial between bitmap bits", cert: newTestCertificate(t, ca, 150, "alice@example.com"), want: false},
+		{name: "key id", cert: newTestCertificate(t, ca, 7, "bob@example.com"), want: true},
+		{name: "revoked key", cert: revokedKeyCert, want: true},
+		{name: "not revoked", cert: newTestCertificate(t, ca, 7, "alice@example.com"), want: false},
+		{name: "serial of other ca", cert: newTestCertificate(t, otherCA, 5, "alice@example.com"), want: false},
+		{name: "key id of other ca", cert: newTestCertificate(t, otherCA, 7, "bob@example.com"), want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := krl.IsRevoked(tt.cert); got != tt.want {
+				t.Errorf("IsRevoked() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKRLRevokedCA(t *testing.T) {
+	ca := newTestSigner(t)
+
+	krl := generateKRL(t, nil, []string{"key: " + authorizedKey(ca.PublicKey())})
+
+	if !krl.IsRevoked(newTestCertificate(t, ca, 1, "alice@example.com")) {
+		t.Error("IsRevoked() = false for a certificate signed by a revoked CA")
+	}
+}
+
+func TestParseKRLInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{name: "empty", data: nil},
+		{name: "invalid magic", data: []byte("SSHKRL\n\x01\x00\x00\x00\x01")},
+		{name: "truncated header", data: []byte(krlMagic + "\x00\x00\x00\x01\x00")},
+		{name: "unsupported version", data: []byte(krlMagic + "\x00\x00\x00\x02")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseKRL(tt.data); err == nil {
+				t.Error("ParseKRL() error = nil, want an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// LFSTokenDuration is how long a token created by git-lfs-authenticate is valid for.
+const LFSTokenDuration = 10 * time.Minute
+
+// lfsToken grants access to the Git LFS objects of a single repository over HTTP.
+type lfsToken struct {
+	perms     *ssh.Permissions
+	repo      string
+	operation string
+	expires   time.Time
+}
+
+// lfsTokens holds the tokens created by git-lfs-authenticate, protected by sessionsMu.
+var lfsTokens = map[string]lfsToken{}
+
+// NewLFSToken creates a token that grants the permissions of an SSH connection to the Git LFS
+// objects of a repository over HTTP, which git-lfs-authenticate hands to the client.
+func NewLFSToken(perms *ssh.Permissions, repo string, operation string) string {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+
+	expireLFSTokens()
+
+	token := randomToken()
+	lfsTokens[token] = lfsToken{
+		perms:     perms,
+		repo:      repo,
+		operation: operation,
+		expires:   time.Now().Add(LFSTokenDuration),
+	}
+
+	return token
+}
+
+// LFSTokenPermissions returns the permissions a token created by git-lfs-authenticate grants for a
+// repository. Tokens for uploads also allow downloads, since git-lfs checks which objects exist.
+func LFSTokenPermissions(token string, repo string, operation string) (*ssh.Permissions, bool) {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+
+	expireLFSTokens()
+
+	lfs, ok := lfsTokens[token]
+	if !ok || lfs.repo != repo || (operation == "upload" && lfs.operation != "upload") {
+		return nil, false
+	}
+
+	return lfs.perms, true
+}
+
+// expireLFSTokens removes expired LFS tokens. sessionsMu must be held.
+func expireLFSTokens() {
+	now := time.Now()
+
+	for token, lfs := range lfsTokens {
+		if now.After(lfs.expires) {
+			delete(lfsTokens, token)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+	"golang.org/x/crypto/ssh"
+)
+
+// loginCodeDuration is how long a login code created over SSH can be redeemed for.
+const loginCodeDuration = 5 * time.Minute
+
+// Session is a web session of an identity that logged in using SSH.
+type Session struct {
+	ID          string
+	CSRFToken   string
+	Permissions *ssh.Permissions
+	Expires     time.Time
+}
+
+// pendingLogin is a login code waiting to be redeemed.
+type pendingLogin struct {
+	perms   *ssh.Permissions
+	expires time.Time
+}
+
+var (
+	// loginCodes holds the login codes that haven't been redeemed yet.
+	loginCodes = map[string]pendingLogin{}
+
+	// sessions holds the active web sessions.
+	sessions = map[string]*Session{}
+
+	// sessionsMu protects loginCodes and sessions.
+	sessionsMu sync.Mutex
+)
+
+// randomToken returns a random hex encoded token.
+func randomToken() string {
+	token := make([]byte, 32)
+
+	_, err := rand.Read(token)
+	if err != nil {
+		log.Println("Error generating token:", err)
+	}
+
+	return hex.EncodeToString(token)
+}
+
+// NewLoginCode creates a single use code that logs into the web dashboard with the permissions of an SSH connection.
+func NewLoginCode(perms *ssh.Permissions) string {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+
+	expireSessions()
+
+	code := randomToken()
+	loginCodes[code] = pendingLogin{
+		perms:   perms,
+		expires: time.Now().Add(loginCodeDuration),
+	}
+
+	return code
+}
+
+// RedeemLoginCode exchanges a login code for a new session.
+func RedeemLoginCode(code string) (*Session, bool) {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+
+	expireSessions()
+
+	login, ok := loginCodes[code]
+	if !ok {
+		return nil, false
+	}
+
+	delete(loginCodes, code)
+
+	session := &Session{
+		ID:          randomToken(),
+		CSRFToken:   randomToken(),
+		Permissions: login.perms,
+		Expires:     time.Now().Add(viper.GetDuration("http-session-duration")),
+	}
+
+	sessions[session.ID] = session
+
+	return session, true
+}
+
+// GetSession returns an active session.
+func GetSession(id string) (*Session, bool) {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+
+	expireSessions()
+
+	session, ok := sessions[id]
+	return session, ok
+}
+
+// EndSession removes a session.
+func EndSession(id string) {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+
+	delete(sessions, id)
+}
+
+// expireSessions removes expired login codes and sessions. sessionsMu must be held.
+func expireSessions() {
+	now := time.Now()
+
+	for code, login := range loginCodes {
+		if now.After(login.expires) {
+			delete(loginCodes, code)
+		}
+	}
+
+	for id, session := range sessions {
+		if now.After(session.Expires) {
+			delete(sessions, id)
+		}
+	}
+}
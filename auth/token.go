@@ -0,0 +1,58 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/spf13/viper"
+	"golang.org/x/crypto/ssh"
+)
+
+// TokenIdentity returns the identity an HTTP access token belongs to. Tokens are
+// read from the tokens file on every call, one "<identity> <token>" pair per line.
+func TokenIdentity(token string) (string, bool) {
+	tokensFile := viper.GetString("http-tokens-file")
+	if tokensFile == "" || token == "" {
+		return "", false
+	}
+
+	file, err := os.Open(tokensFile)
+	if err != nil {
+		log.Println("Error opening tokens file:", err)
+		return "", false
+	}
+
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+
+		if subtle.ConstantTimeCompare([]byte(fields[1]), []byte(token)) == 1 {
+			return fields[0], true
+		}
+	}
+
+	return "", false
+}
+
+// IdentityPermissions returns permissions for an identity authenticated outside of SSH,
+// so the same authorization rules apply to it.
+func IdentityPermissions(identity string) *ssh.Permissions {
+	return &ssh.Permissions{
+		Extensions: map[string]string{
+			IdentityExtension: identity,
+		},
+	}
+}
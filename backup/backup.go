@@ -0,0 +1,404 @@
+// Package backup implements backing up and restoring the repositories hosted by pcompose
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/antoniomika/pcompose/project"
+)
+
+const (
+	// manifestName is the name of the archive entry that lists the repositories of a backup.
+	manifestName = "manifest.json"
+
+	// manifestVersion is the version of the archive layout.
+	manifestVersion = 1
+
+	// repositoriesDir is the archive directory that holds a directory per repository.
+	repositoriesDir = "repositories"
+
+	// bundleName is the name of the git bundle of a repository.
+	bundleName = "repo.bundle"
+
+	// metadataDir is the archive directory of a repository that holds its pcompose metadata.
+	metadataDir = "metadata"
+
+	// lfsDir is the archive directory of a repository that holds its Git LFS objects.
+	lfsDir = "lfs"
+)
+
+// Manifest is the first entry of a backup archive and lists the repositories it holds.
+type Manifest struct {
+	Version      int          `json:"version"`
+	Created      time.Time    `json:"created"`
+	Repositories []Repository `json:"repositories"`
+}
+
+// Repository is a repository in a backup archive.
+type Repository struct {
+	// Name is the name of the repository, e.g. user/httpbin.
+	Name string `json:"name"`
+
+	// Head is the default branch of the repository, e.g. refs/heads/main.
+	Head string `json:"head,omitempty"`
+
+	// Empty is set for repositories without refs, which have no bundle.
+	Empty bool `json:"empty,omitempty"`
+}
+
+// Create writes a gzipped tar archive of every repository to w. Each repository is stored as a
+// git bundle of all of its refs, together with its metadata such as the deploy history and its
+// Git LFS objects.
+func Create(w io.Writer) error {
+	projects, err := project.List()
+	if err != nil {
+		return fmt.Errorf("error listing projects: %w", err)
+	}
+
+	manifest := Manifest{
+		Version: manifestVersion,
+		Created: time.Now(),
+	}
+
+	for _, p := range projects {
+		// Deployments of a repository share it.
+		if len(manifest.Repositories) > 0 && manifest.Repositories[len(manifest.Repositories)-1].Name == p.Name {
+			continue
+		}
+
+		manifest.Repositories = append(manifest.Repositories, Repository{
+			Name:  p.Name,
+			Head:  project.DefaultBranch(p.RepoDir),
+			Empty: len(project.Refs(p.RepoDir)) == 0,
+		})
+	}
+
+	gzipWriter := gzip.NewWriter(w)
+	tarWriter := tar.NewWriter(gzipWriter)
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	err = writeEntry(tarWriter, manifestName, int64(len(data)), strings.NewReader(string(data)))
+	if err != nil {
+		return err
+	}
+
+	tmpDir, err := os.MkdirTemp("", "pcompose-backup")
+	if err != nil {
+		return err
+	}
+
+	defer os.RemoveAll(tmpDir)
+
+	for _, repository := range manifest.Repositories {
+		err := addRepository(tarWriter, tmpDir, repository)
+		if err != nil {
+			return fmt.Errorf("error backing up %s: %w", repository.Name, err)
+		}
+
+		log.Printf("Backed up %s", repository.Name)
+	}
+
+	err = tarWriter.Close()
+	if err != nil {
+		return err
+	}
+
+	return gzipWriter.Close()
+}
+
+// addRepository adds the bundle, metadata and Git LFS objects of a repository to the archive.
+func addRepository(tarWriter *tar.Writer, tmpDir string, repository Repository) error {
+	repoDir := project.RepoDir(repository.Name)
+	archiveDir := path.Join(repositoriesDir, repository.Name)
+
+	if !repository.Empty {
+		bundle := path.Join(tmpDir, bundleName)
+
+		output, err := gitCommand(repoDir, "bundle", "create", "--quiet", bundle, "--all").CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("error creating bundle: %w: %s", err, strings.TrimSpace(string(output)))
+		}
+
+		err = addFile(tarWriter, path.Join(archiveDir, bundleName), bundle)
+		if err != nil {
+			return err
+		}
+
+		err = os.Remove(bundle)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := addDir(tarWriter, path.Join(archiveDir, metadataDir), path.Join(repoDir, project.MetadataDirName))
+	if err != nil {
+		return err
+	}
+
+	return addDir(tarWriter, path.Join(archiveDir, lfsDir), path.Join(repoDir, "lfs", "objects"))
+}
+
+// addDir adds the regular files of a directory to the archive, skipping lock and temporary files.
+func addDir(tarWriter *tar.Writer, archiveDir string, dir string) error {
+	err := filepath.WalkDir(dir, func(walkPath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !d.Type().IsRegular() || strings.HasSuffix(walkPath, ".lock") || strings.HasSuffix(walkPath, ".tmp") {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(dir, walkPath)
+		if err != nil {
+			return err
+		}
+
+		return addFile(tarWriter, path.Join(archiveDir, filepath.ToSlash(relPath)), walkPath)
+	})
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	return err
+}
+
+// addFile adds a file to the archive.
+func addFile(tarWriter *tar.Writer, name string, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	return writeEntry(tarWriter, name, info.Size(), f)
+}
+
+// writeEntry writes a regular file to the archive.
+func writeEntry(tarWriter *tar.Writer, name string, size int64, r io.Reader) error {
+	err := tarWriter.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     size,
+		Mode:     0644,
+		ModTime:  time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(tarWriter, r)
+	return err
+}
+
+// Restore recreates the repositories of an archive created by Create. Repositories that already
+// exist are skipped, unless force is set, which overwrites their refs, metadata and Git LFS
+// objects. Restored repositories aren't deployed.
+func Restore(r io.Reader, force bool) error {
+	gzipReader, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("error reading archive: %w", err)
+	}
+
+	tarReader := tar.NewReader(gzipReader)
+
+	header, err := tarReader.Next()
+	if err != nil || header.Name != manifestName {
+		return errors.New("error reading archive: the archive has no manifest")
+	}
+
+	var manifest Manifest
+
+	err = json.NewDecoder(tarReader).Decode(&manifest)
+	if err != nil {
+		return fmt.Errorf("error reading manifest: %w", err)
+	}
+
+	if manifest.Version != manifestVersion {
+		return fmt.Errorf("unsupported archive version %d", manifest.Version)
+	}
+
+	restoring := map[string]Repository{}
+
+	for _, repository := range manifest.Repositories {
+		if repository.Name == "" || strings.Contains(repository.Name, "..") || path.IsAbs(repository.Name) {
+			return fmt.Errorf("invalid repository name %q", repository.Name)
+		}
+
+		repoDir := project.RepoDir(repository.Name)
+
+		if _, err := os.Stat(repoDir); err == nil && !force {
+			log.Printf("Skipping %s, the repository already exists", repository.Name)
+			continue
+		}
+
+		err := project.InitRepository(repoDir)
+		if err != nil {
+			return fmt.Errorf("error initializing %s: %w", repository.Name, err)
+		}
+
+		restoring[repository.Name] = repository
+	}
+
+	tmpDir, err := os.MkdirTemp("", "pcompose-restore")
+	if err != nil {
+		return err
+	}
+
+	defer os.RemoveAll(tmpDir)
+
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("error reading archive: %w", err)
+		}
+
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		repository, entry, ok := repositoryEntry(manifest, header.Name)
+		if !ok {
+			return fmt.Errorf("unexpected archive entry %s", header.Name)
+		}
+
+		if _, restore := restoring[repository.Name]; !restore {
+			continue
+		}
+
+		repoDir := project.RepoDir(repository.Name)
+
+		switch {
+		case entry == bundleName:
+			err = restoreBundle(repoDir, tmpDir, tarReader)
+		case strings.HasPrefix(entry, metadataDir+"/"):
+			err = restoreFile(path.Join(repoDir, project.MetadataDirName, strings.TrimPrefix(entry, metadataDir+"/")), tarReader)
+		case strings.HasPrefix(entry, lfsDir+"/"):
+			err = restoreFile(path.Join(repoDir, "lfs", "objects", strings.TrimPrefix(entry, lfsDir+"/")), tarReader)
+		default:
+			err = fmt.Errorf("unexpected archive entry %s", header.Name)
+		}
+
+		if err != nil {
+			return fmt.Errorf("error restoring %s: %w", repository.Name, err)
+		}
+	}
+
+	for _, repository := range manifest.Repositories {
+		if _, restore := restoring[repository.Name]; !restore {
+			continue
+		}
+
+		if repository.Head != "" {
+			err := project.SetDefaultBranch(project.RepoDir(repository.Name), repository.Head)
+			if err != nil {
+				return fmt.Errorf("error setting default branch of %s: %w", repository.Name, err)
+			}
+		}
+
+		log.Printf("Restored %s", repository.Name)
+	}
+
+	return nil
+}
+
+// repositoryEntry returns the repository an archive entry belongs to and the path of the entry
+// within the directory of the repository. Repository names can contain slashes, so the longest
+// matching name wins.
+func repositoryEntry(manifest Manifest, name string) (Repository, string, bool) {
+	name = path.Clean(name)
+
+	var (
+		match Repository
+		entry string
+		found bool
+	)
+
+	for _, repository := range manifest.Repositories {
+		prefix := path.Join(repositoriesDir, repository.Name) + "/"
+		if !strings.HasPrefix(name, prefix) || (found && len(repository.Name) < len(match.Name)) {
+			continue
+		}
+
+		match, entry, found = repository, strings.TrimPrefix(name, prefix), true
+	}
+
+	if !found || entry == "" || strings.Contains(entry, "..") {
+		return Repository{}, "", false
+	}
+
+	return match, entry, true
+}
+
+// restoreBundle fetches every ref of a bundle into a repository, removing refs the bundle doesn't have.
+func restoreBundle(repoDir string, tmpDir string, r io.Reader) error {
+	bundle := path.Join(tmpDir, bundleName)
+
+	err := restoreFile(bundle, r)
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(bundle)
+
+	output, err := gitCommand(repoDir, "fetch", "--quiet", "--prune", "--force", bundle, "+refs/*:refs/*").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("error fetching bundle: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	return nil
+}
+
+// restoreFile writes an archive entry to a file, creating its directory.
+func restoreFile(file string, r io.Reader) error {
+	err := os.MkdirAll(path.Dir(file), os.FileMode(0755))
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(f, r)
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// gitCommand returns a git command that runs in a bare repository.
+func gitCommand(repoDir string, args ...string) *exec.Cmd {
+	cmd := exec.Command("git", args...)
+	cmd.Env = append(os.Environ(), fmt.Sprintf("GIT_DIR=%s", repoDir))
+
+	return cmd
+}
@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"io"
+	"log"
+	"os"
+
+	"github.com/antoniomika/pcompose/backup"
+	"github.com/spf13/cobra"
+)
+
+var (
+	// backupCmd backs up every repository into a single archive.
+	backupCmd = &cobra.Command{
+		Use:   "backup <archive>",
+		Short: "Back up every repository, its deploy history and Git LFS objects into an archive. Use - to write to stdout",
+		Args:  cobra.ExactArgs(1),
+		RunE:  runBackup,
+	}
+
+	// restoreCmd restores the repositories of an archive created by backup.
+	restoreCmd = &cobra.Command{
+		Use:   "restore <archive>",
+		Short: "Restore the repositories of an archive created by backup. Use - to read from stdin",
+		Args:  cobra.ExactArgs(1),
+		RunE:  runRestore,
+	}
+)
+
+// init registers the backup and restore commands.
+func init() {
+	restoreCmd.Flags().BoolP("force", "f", false, "Overwrite the refs, deploy history and Git LFS objects of repositories that already exist")
+
+	rootCmd.AddCommand(backupCmd, restoreCmd)
+}
+
+// runBackup writes a backup archive to a file or stdout.
+func runBackup(cmd *cobra.Command, args []string) error {
+	if args[0] == "-" {
+		// The archive is written to stdout, so keep log output out of it.
+		log.SetOutput(os.Stderr)
+
+		return backup.Create(os.Stdout)
+	}
+
+	archive, err := os.OpenFile(args[0], os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(0600))
+	if err != nil {
+		return err
+	}
+
+	err = backup.Create(archive)
+	if err != nil {
+		archive.Close()
+		return err
+	}
+
+	return archive.Close()
+}
+
+// runRestore restores a backup archive from a file or stdin.
+func runRestore(cmd *cobra.Command, args []string) error {
+	force, err := cmd.Flags().GetBool("force")
+	if err != nil {
+		return err
+	}
+
+	var archive io.Reader = os.Stdin
+
+	if args[0] != "-" {
+		f, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+
+		defer f.Close()
+
+		archive = f
+	}
+
+	return backup.Restore(archive, force)
+}
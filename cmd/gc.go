@@ -0,0 +1,33 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/antoniomika/pcompose/gc"
+	"github.com/spf13/cobra"
+)
+
+// gcCmd runs a single garbage collection.
+var gcCmd = &cobra.Command{
+	Use:   "gc",
+	Short: "Remove images no recent deploy uses, prune the build cache and report or remove stale projects",
+	Args:  cobra.NoArgs,
+	RunE:  runGC,
+}
+
+// init registers the gc command.
+func init() {
+	gcCmd.Flags().BoolP("dry-run", "n", false, "Only print what would be removed")
+
+	rootCmd.AddCommand(gcCmd)
+}
+
+// runGC runs a garbage collection and prints what it removes.
+func runGC(cmd *cobra.Command, args []string) error {
+	dryRun, err := cmd.Flags().GetBool("dry-run")
+	if err != nil {
+		return err
+	}
+
+	return gc.Run(os.Stdout, dryRun)
+}
@@ -10,9 +10,17 @@ import (
 	"strings"
 	"time"
 
+	"github.com/antoniomika/pcompose/audit"
+	"github.com/antoniomika/pcompose/deploy"
+	"github.com/antoniomika/pcompose/gc"
 	"github.com/antoniomika/pcompose/hook"
+	"github.com/antoniomika/pcompose/httpserver"
+	"github.com/antoniomika/pcompose/jobs"
+	"github.com/antoniomika/pcompose/mirror"
+	"github.com/antoniomika/pcompose/proxy"
 	"github.com/antoniomika/pcompose/sshserver"
 	pUtils "github.com/antoniomika/pcompose/utils"
+	"github.com/antoniomika/pcompose/volumes"
 	"github.com/antoniomika/sish/utils"
 	"github.com/fsnotify/fsnotify"
 	"github.com/sirupsen/logrus"
@@ -34,6 +42,9 @@ var (
 	// configFile holds the location of the config file from CLI flags.
 	configFile string
 
+	// serving is whether the root command runs the server, which passes its configuration to the hooks.
+	serving bool
+
 	// rootCmd is the root cobra command.
 	rootCmd = &cobra.Command{
 		Use:     "pcompose",
@@ -41,6 +52,9 @@ var (
 		Long:    "pcompose is a command line utility that runs a simple PaaS ontop of docker using docker-compose and git",
 		Run:     runCommand,
 		Version: Version,
+		// Git hooks are called with positional arguments, such as the update hook. Without this,
+		// cobra rejects positional arguments on the root command once it has subcommands.
+		Args: cobra.ArbitraryArgs,
 	}
 )
 
@@ -53,6 +67,11 @@ func init() {
 	rootCmd.PersistentFlags().StringVarP(&configFile, "config", "c", "config.yml", "Config file")
 
 	rootCmd.PersistentFlags().StringP("ssh-address", "a", "localhost:2222", "The address to listen for SSH connections")
+	rootCmd.PersistentFlags().StringP("http-address", "", "", "The address to listen for HTTP(S) connections. The HTTP service is disabled if empty")
+	rootCmd.PersistentFlags().StringP("https-certificate", "", "", "The TLS certificate to use for the HTTP service. HTTPS is enabled if both https-certificate and https-private-key are set")
+	rootCmd.PersistentFlags().StringP("https-private-key", "", "", "The TLS private key to use for the HTTP service")
+	rootCmd.PersistentFlags().StringP("http-public-url", "", "http://localhost", "The public URL of the HTTP service, used for links such as dashboard logins")
+	rootCmd.PersistentFlags().StringP("http-tokens-file", "", "", "File containing access tokens for the HTTP service, one \"<identity> <token>\" pair per line")
 	rootCmd.PersistentFlags().StringP("banned-ips", "x", "", "A comma separated list of banned ips that are unable to access the service. Applies to SSH connections")
 	rootCmd.PersistentFlags().StringP("banned-countries", "o", "", "A comma separated list of banned countries. Applies to SSH connections")
 	rootCmd.PersistentFlags().StringP("whitelisted-ips", "w", "", "A comma separated list of whitelisted ips. Applies to SSH connections")
@@ -61,35 +80,84 @@ func init() {
 	rootCmd.PersistentFlags().StringP("private-keys-directory", "l", "deploy/keys", "The location of other SSH server private keys. sish will add these as valid auth methods for SSH. Note, these need to be unencrypted OR use the private-key-passphrase")
 	rootCmd.PersistentFlags().StringP("authentication-password", "u", "", "Password to use for ssh server password authentication")
 	rootCmd.PersistentFlags().StringP("authentication-keys-directory", "k", "deploy/pubkeys/", "Directory where public keys for public key authentication are stored.\npcompose will watch this directory and automatically load new keys and remove keys\nfrom the authentication list")
+	rootCmd.PersistentFlags().StringP("authentication-ca-keys", "", "", "File containing the public keys of certificate authorities trusted to sign user certificates.\nCertificate principals are mapped to projects using authentication-ca-principals in the config file")
+	rootCmd.PersistentFlags().StringP("authentication-krl", "", "", "OpenSSH key revocation list (KRL) used to reject revoked keys and certificates")
+	rootCmd.PersistentFlags().StringP("namespace-admins", "", "", "A comma separated list of identities that can access every namespace when namespace-per-user is enabled")
 	rootCmd.PersistentFlags().StringP("time-format", "", "2006/01/02 - 15:04:05", "The time format to use for general log messages")
 	rootCmd.PersistentFlags().StringP("log-to-file-path", "", "/tmp/pcompose.log", "The file to write log output to")
 	rootCmd.PersistentFlags().StringP("data-directory", "", "deploy/data/", "Directory that holds pcompose data")
-	rootCmd.PersistentFlags().StringP("frontend-container-name", "", "nginx-proxy", "The name of the frontend container in order to connect it to the default docker-compose network.")
+	rootCmd.PersistentFlags().StringP("frontend-container-name", "", "nginx-proxy", "A comma separated list of frontend containers connected to the public networks of projects, the default docker-compose network unless configured otherwise")
+	rootCmd.PersistentFlags().StringP("frontend", "", "nginx-proxy", "The reverse proxy routes from .pcompose.yml are configured for, one of nginx-proxy, traefik, caddy or pcompose for the built-in proxy")
+	rootCmd.PersistentFlags().StringP("frontend-traefik-entrypoints", "", "", "A comma separated list of Traefik entrypoints routes are added to. Traefik uses its default entrypoints if empty")
+	rootCmd.PersistentFlags().StringP("frontend-traefik-certresolver", "", "", "The Traefik certificate resolver used for routes with tls enabled")
+	rootCmd.PersistentFlags().StringP("frontend-caddy-admin-url", "", "http://caddy:2019", "The URL of the Caddy admin API routes are configured with")
+	rootCmd.PersistentFlags().StringP("frontend-caddy-server", "", "srv0", "The name of the Caddy HTTP server routes are added to")
+	rootCmd.PersistentFlags().StringP("proxy-http-address", "", ":80", "The address the built-in proxy serves HTTP and ACME HTTP-01 challenges on")
+	rootCmd.PersistentFlags().StringP("proxy-https-address", "", ":443", "The address the built-in proxy serves HTTPS on")
+	rootCmd.PersistentFlags().StringP("proxy-acme-directory-url", "", "https://acme-v02.api.letsencrypt.org/directory", "The directory URL of the ACME server the built-in proxy requests certificates from")
+	rootCmd.PersistentFlags().StringP("proxy-acme-email", "", "", "The email address of the ACME account, which the ACME server may send expiry notices to")
+	rootCmd.PersistentFlags().StringP("proxy-acme-ca-certificates", "", "", "File containing additional CA certificates trusted for the ACME server, e.g. the certificate of a Pebble test server")
+	rootCmd.PersistentFlags().StringP("proxy-certificates-directory", "", "deploy/certificates/", "Directory where the built-in proxy stores its ACME account key and certificates")
 	rootCmd.PersistentFlags().StringP("pcompose-container-name", "", "pcompose", "The name of the pcompose container in order to exec into a context.")
+	rootCmd.PersistentFlags().StringP("audit-log-path", "", "/tmp/pcompose-audit.log", "The file to write the audit log to, specified by audit-log")
+	rootCmd.PersistentFlags().StringP("volume-backups-directory", "", "deploy/backups/", "Directory where volume backups are stored if volume-backups-s3-endpoint is empty")
+	rootCmd.PersistentFlags().StringP("volume-backups-image", "", "alpine:3", "The image of the helper containers that read and write the contents of volumes")
+	rootCmd.PersistentFlags().StringP("volume-backups-s3-endpoint", "", "", "The endpoint of an S3 compatible service to store volume backups in, e.g. https://s3.us-east-1.amazonaws.com or http://minio:9000")
+	rootCmd.PersistentFlags().StringP("volume-backups-s3-bucket", "", "", "The bucket to store volume backups in")
+	rootCmd.PersistentFlags().StringP("volume-backups-s3-region", "", "us-east-1", "The region of the bucket to store volume backups in")
+	rootCmd.PersistentFlags().StringP("volume-backups-s3-access-key", "", "", "The access key ID used to store volume backups")
+	rootCmd.PersistentFlags().StringP("volume-backups-s3-secret-key", "", "", "The secret access key used to store volume backups")
+	rootCmd.PersistentFlags().StringP("gc-build-cache-size", "", "", "The size the build cache is pruned down to by garbage collection, e.g. 10GB. The build cache isn't pruned if empty")
+	rootCmd.PersistentFlags().StringP("session-recordings-directory", "", "deploy/recordings/", "Directory where interactive session recordings are stored, one subdirectory per project")
 
 	rootCmd.PersistentFlags().BoolP("cleanup-unbound", "", true, "Cleanup unbound (unforwarded) SSH connections after a set timeout")
 	rootCmd.PersistentFlags().BoolP("debug", "", false, "Enable debugging information")
 	rootCmd.PersistentFlags().BoolP("geodb", "", false, "Use a geodb to verify country IP address association for IP filtering")
-	rootCmd.PersistentFlags().BoolP("authentication", "", false, "Require authentication for the SSH service")
+	rootCmd.PersistentFlags().BoolP("authentication", "", false, "Require authentication for the SSH and HTTP services")
+	rootCmd.PersistentFlags().BoolP("http-git", "", false, "Enable serving repositories using the git smart HTTP protocol under /git/ on the HTTP service")
+	rootCmd.PersistentFlags().BoolP("http-api", "", false, "Enable the management API under /api/ on the HTTP service")
+	rootCmd.PersistentFlags().BoolP("http-dashboard", "", false, "Enable the web dashboard under /dashboard/ on the HTTP service. Log in using: ssh <host> pcompose login")
+	rootCmd.PersistentFlags().BoolP("http-webhooks", "", false, "Enable receiving push webhooks from GitHub, Gitea and GitLab for mirrored projects under /webhooks/ on the HTTP service")
+	rootCmd.PersistentFlags().BoolP("http-metrics", "", false, "Enable Prometheus metrics under /metrics on the HTTP service")
+	rootCmd.PersistentFlags().BoolP("namespace-per-user", "", false, "Require repositories and projects to live under the namespace of the authenticated identity\nor an organization it is a member of, configured using namespace-organizations in the config file")
 	rootCmd.PersistentFlags().BoolP("log-to-stdout", "", true, "Enable writing log output to stdout")
 	rootCmd.PersistentFlags().BoolP("log-to-file", "", false, "Enable writing log output to file, specified by log-to-file-path")
 	rootCmd.PersistentFlags().BoolP("log-to-file-compress", "", false, "Enable compressing log output files")
+	rootCmd.PersistentFlags().BoolP("audit-log", "", false, "Enable writing a JSON lines audit log of SSH actions to audit-log-path")
+	rootCmd.PersistentFlags().BoolP("audit-log-compress", "", false, "Enable compressing audit log files")
+	rootCmd.PersistentFlags().BoolP("session-recordings", "", false, "Enable recording interactive shell and attach sessions in asciicast v2 format")
+	rootCmd.PersistentFlags().BoolP("session-recordings-input", "", false, "Enable recording the input of interactive sessions in addition to the output")
+	rootCmd.PersistentFlags().BoolP("gc-remove-stale-projects", "", false, "Enable removing the containers and networks of stale projects during garbage collection instead of only reporting them")
 
 	rootCmd.PersistentFlags().IntP("log-to-file-max-size", "", 500, "The maximum size of outputed log files in megabytes")
 	rootCmd.PersistentFlags().IntP("log-to-file-max-backups", "", 3, "The maxium number of rotated logs files to keep")
 	rootCmd.PersistentFlags().IntP("log-to-file-max-age", "", 28, "The maxium number of days to store log output in a file")
+	rootCmd.PersistentFlags().IntP("audit-log-max-size", "", 500, "The maximum size of audit log files in megabytes")
+	rootCmd.PersistentFlags().IntP("audit-log-max-backups", "", 0, "The maximum number of rotated audit log files to keep. 0 keeps all files")
+	rootCmd.PersistentFlags().IntP("audit-log-max-age", "", 0, "The maximum number of days to store audit log files. 0 keeps all files")
+	rootCmd.PersistentFlags().IntP("webhook-retries", "", 3, "The number of times to retry a deploy webhook delivery that failed with a network or server error")
+	rootCmd.PersistentFlags().IntP("deploy-keep-releases", "", 5, "The number of releases to keep per project for rollbacks, including the current one. 0 keeps all releases")
+	rootCmd.PersistentFlags().IntP("push-mirror-retries", "", 3, "The number of times to retry a push to a push mirror that failed before giving up until the next retry interval")
+	rootCmd.PersistentFlags().IntP("gc-keep-deploys", "", 3, "The number of successful deploys per project whose images are kept by garbage collection")
+	rootCmd.PersistentFlags().IntP("job-keep-runs", "", 20, "The number of runs to keep in the history of each scheduled job, including their logs. 0 keeps all runs")
+	rootCmd.PersistentFlags().IntP("session-recordings-max-count", "", 0, "The maximum number of session recordings to keep per project. 0 keeps all recordings")
 
 	rootCmd.PersistentFlags().DurationP("authentication-keys-directory-watch-interval", "", 200*time.Millisecond, "The interval to poll for filesystem changes for SSH keys")
+	rootCmd.PersistentFlags().DurationP("http-session-duration", "", 12*time.Hour, "How long a web dashboard session stays valid after logging in")
+	rootCmd.PersistentFlags().DurationP("deploy-health-timeout", "", 2*time.Minute, "How long a deploy waits for the health checks of its containers to pass. 0 only checks once")
+	rootCmd.PersistentFlags().DurationP("webhook-timeout", "", 10*time.Second, "The timeout of a single deploy webhook delivery attempt")
+	rootCmd.PersistentFlags().DurationP("push-mirror-timeout", "", 10*time.Minute, "How long a push to a push mirror may take before it is aborted. 0 disables the timeout")
+	rootCmd.PersistentFlags().DurationP("push-mirror-retry-interval", "", 5*time.Minute, "How often the server retries pushes to push mirrors that failed. 0 disables retrying in the background")
+	rootCmd.PersistentFlags().DurationP("gc-interval", "", 24*time.Hour, "How often unused images and build cache are removed and stale projects are looked for. 0 disables garbage collection in the background")
+	rootCmd.PersistentFlags().DurationP("session-recordings-max-age", "", 0, "The maximum age of session recordings before they are removed. 0 keeps all recordings")
 }
 
 // initConfig initializes the configuration and loads needed
 // values. It initializes logging and other vars.
 func initConfig() {
-	writeConfigChanges := true
-
-	if strings.HasPrefix(os.Args[0], pUtils.HooksDirName) {
-		writeConfigChanges = false
+	isHook := strings.HasPrefix(os.Args[0], pUtils.HooksDirName)
 
+	if isHook {
 		workingDir, err := os.Getwd()
 		if err != nil {
 			log.Println("Error getting working directory:", err)
@@ -107,7 +175,7 @@ func initConfig() {
 
 	viper.AutomaticEnv()
 
-	if err := viper.ReadInConfig(); err == nil && writeConfigChanges {
+	if err := viper.ReadInConfig(); err == nil && !isHook {
 		log.Println("Using config file:", viper.ConfigFileUsed())
 	}
 
@@ -131,8 +199,6 @@ func initConfig() {
 
 	multiWriter := io.MultiWriter(writers...)
 
-	writeConfigFile := path.Join(viper.GetString("data-directory"), pUtils.HooksConfigFile)
-
 	viper.OnConfigChange(func(e fsnotify.Event) {
 		log.Println("Reloaded configuration file.")
 
@@ -146,11 +212,8 @@ func initConfig() {
 			logrus.SetLevel(logrus.DebugLevel)
 		}
 
-		if writeConfigChanges {
-			err := viper.WriteConfigAs(writeConfigFile)
-			if err != nil {
-				log.Println("Error writing config for hooks")
-			}
+		if serving {
+			writeHooksConfig()
 		}
 	})
 
@@ -167,12 +230,13 @@ func initConfig() {
 	logrus.SetOutput(multiWriter)
 
 	utils.Setup(multiWriter)
+}
 
-	if writeConfigChanges {
-		err := viper.WriteConfigAs(writeConfigFile)
-		if err != nil {
-			log.Println("Error writing config for hooks")
-		}
+// writeHooksConfig writes the loaded configuration to the data directory for the hooks to read.
+func writeHooksConfig() {
+	err := viper.WriteConfigAs(path.Join(viper.GetString("data-directory"), pUtils.HooksConfigFile))
+	if err != nil {
+		log.Println("Error writing config for hooks")
 	}
 }
 
@@ -188,5 +252,31 @@ func runCommand(cmd *cobra.Command, args []string) {
 		os.Exit(0)
 	}
 
+	// Only the server writes the configuration of the hooks, so commands such as backup don't replace it.
+	serving = true
+
+	audit.Setup()
+
+	// Hooks resolve relative paths from the config against the working directory of the server.
+	workingDir, err := os.Getwd()
+	if err != nil {
+		log.Println("Error getting working directory:", err)
+	} else {
+		err = os.Setenv(pUtils.WorkingDirEnv, workingDir)
+		if err != nil {
+			log.Println("Error setting working directory for hooks:", err)
+		}
+	}
+
+	writeHooksConfig()
+
+	go deploy.ReconcileNetworks()
+	go httpserver.Start()
+	go mirror.Start()
+	go volumes.Start()
+	go jobs.Start()
+	go gc.Start()
+	go proxy.Start()
+
 	sshserver.Start()
 }
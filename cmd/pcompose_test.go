@@ -0,0 +1,25 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRootCommandAcceptsHookArgs(t *testing.T) {
+	// The update hook runs the root command with "<ref> <old-rev> <new-rev>".
+	args := []string{"refs/heads/main", strings.Repeat("0", 40), strings.Repeat("a", 40)}
+
+	command, _, err := rootCmd.Find(args)
+	if err != nil {
+		t.Fatalf("Find() error = %v", err)
+	}
+
+	if command != rootCmd {
+		t.Fatalf("Find() = %s, want the root command", command.Name())
+	}
+
+	err = command.ValidateArgs(args)
+	if err != nil {
+		t.Errorf("ValidateArgs() error = %v", err)
+	}
+}
@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"github.com/antoniomika/pcompose/recording"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	// sessionsCmd is the parent command for reviewing session recordings.
+	sessionsCmd = &cobra.Command{
+		Use:   "sessions",
+		Short: "Review recorded interactive sessions",
+	}
+
+	// sessionsListCmd lists stored session recordings.
+	sessionsListCmd = &cobra.Command{
+		Use:   "list [project]",
+		Short: "List recorded sessions, optionally only for a single project",
+		Args:  cobra.MaximumNArgs(1),
+		RunE:  runSessionsList,
+	}
+
+	// sessionsPlayCmd replays a session recording to stdout.
+	sessionsPlayCmd = &cobra.Command{
+		Use:   "play <recording>",
+		Short: "Replay a recorded session in the terminal",
+		Args:  cobra.ExactArgs(1),
+		RunE:  runSessionsPlay,
+	}
+)
+
+// init registers the sessions commands.
+func init() {
+	sessionsPlayCmd.Flags().Float64P("speed", "s", 1, "Playback speed multiplier")
+	sessionsPlayCmd.Flags().DurationP("max-idle", "i", 2*time.Second, "Limit pauses between output to this duration. 0 disables the limit")
+
+	sessionsCmd.AddCommand(sessionsListCmd, sessionsPlayCmd)
+	rootCmd.AddCommand(sessionsCmd)
+}
+
+// runSessionsList prints a table of the stored session recordings.
+func runSessionsList(cmd *cobra.Command, args []string) error {
+	project := ""
+	if len(args) > 0 {
+		project = args[0]
+	}
+
+	infos, err := recording.List(project)
+	if err != nil {
+		return err
+	}
+
+	recordingsDir := viper.GetString("session-recordings-directory")
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PROJECT\tRECORDING\tSTARTED\tDURATION\tSIZE\tTITLE")
+
+	for _, info := range infos {
+		name, err := filepath.Rel(recordingsDir, info.Path)
+		if err != nil {
+			name = info.Path
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\n", info.Project, name, info.Start.Format(viper.GetString("time-format")), info.Duration.Round(time.Second), info.Size, info.Title)
+	}
+
+	return w.Flush()
+}
+
+// runSessionsPlay replays a recording given either as a path or relative to the recordings directory.
+func runSessionsPlay(cmd *cobra.Command, args []string) error {
+	recordingPath := args[0]
+
+	if _, err := os.Stat(recordingPath); os.IsNotExist(err) {
+		recordingPath = filepath.Join(viper.GetString("session-recordings-directory"), recordingPath)
+	}
+
+	speed, err := cmd.Flags().GetFloat64("speed")
+	if err != nil {
+		return err
+	}
+
+	maxIdle, err := cmd.Flags().GetDuration("max-idle")
+	if err != nil {
+		return err
+	}
+
+	return recording.Play(recordingPath, os.Stdout, speed, maxIdle)
+}
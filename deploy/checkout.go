@@ -0,0 +1,93 @@
+package deploy
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+
+	"github.com/antoniomika/pcompose/project"
+)
+
+// updateSubmodules checks out the submodules of a release at the commits its revision records.
+func updateSubmodules(p project.Project, releaseDir string, output io.Writer) error {
+	if _, err := os.Stat(path.Join(releaseDir, ".gitmodules")); os.IsNotExist(err) {
+		return nil
+	}
+
+	err := checkoutCommand(p, releaseDir, output, "submodule", "update", "--init", "--recursive").Run()
+	if err != nil {
+		return fmt.Errorf("error updating submodules: %w", err)
+	}
+
+	return nil
+}
+
+// pullLFS replaces the Git LFS pointers of a release and its submodules with the objects they point to.
+func pullLFS(p project.Project, releaseDir string, output io.Writer) error {
+	if _, err := exec.LookPath("git-lfs"); err != nil {
+		attributes, err := os.ReadFile(path.Join(releaseDir, ".gitattributes"))
+		if err == nil && strings.Contains(string(attributes), "filter=lfs") {
+			return errors.New("the repository uses Git LFS, but git-lfs is not installed")
+		}
+
+		return nil
+	}
+
+	err := checkoutCommand(p, releaseDir, output, "lfs", "pull").Run()
+	if err != nil {
+		return fmt.Errorf("error pulling Git LFS objects: %w", err)
+	}
+
+	if _, err := os.Stat(path.Join(releaseDir, ".gitmodules")); err == nil {
+		err := checkoutCommand(p, releaseDir, output, "submodule", "foreach", "--quiet", "--recursive", "git lfs pull").Run()
+		if err != nil {
+			return fmt.Errorf("error pulling Git LFS objects of submodules: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// checkoutCommand returns a git command that runs in a release and can fetch from
+// remotes using the credentials configured for the project. The environment is kept so git can
+// find ssh and git-lfs.
+func checkoutCommand(p project.Project, releaseDir string, output io.Writer, args ...string) *exec.Cmd {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = releaseDir
+	cmd.Stdout = output
+	cmd.Stderr = output
+	cmd.Env = append(os.Environ(), "GIT_DIR=.git", "GIT_TERMINAL_PROMPT=0")
+
+	config := p.Config()
+
+	if config.Checkout.SSHKey != "" {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("GIT_SSH_COMMAND=ssh -i '%s' -o IdentitiesOnly=yes -o StrictHostKeyChecking=accept-new", config.Checkout.SSHKey))
+	}
+
+	var gitConfig [][2]string
+
+	for _, credential := range config.Checkout.Credentials {
+		remote, err := url.Parse(credential.URL)
+		if err != nil || remote.Host == "" {
+			log.Printf("Ignoring credentials of %s for %s, the URL is invalid", p.Name, credential.URL)
+			continue
+		}
+
+		remote.User = url.UserPassword(credential.Username, credential.Password)
+		gitConfig = append(gitConfig, [2]string{fmt.Sprintf("url.%s.insteadOf", remote), credential.URL})
+	}
+
+	// Unlike -c, config passed in the environment doesn't show up in the process list and is passed on to submodules.
+	cmd.Env = append(cmd.Env, fmt.Sprintf("GIT_CONFIG_COUNT=%d", len(gitConfig)))
+	for i, entry := range gitConfig {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("GIT_CONFIG_KEY_%d=%s", i, entry[0]), fmt.Sprintf("GIT_CONFIG_VALUE_%d=%s", i, entry[1]))
+	}
+
+	return cmd
+}
@@ -0,0 +1,132 @@
+package deploy
+
+import (
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/antoniomika/pcompose/project"
+)
+
+// Container is a container that belongs to a deployed project.
+type Container struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Service string `json:"service"`
+	Image   string `json:"image"`
+	State   string `json:"state"`
+	Status  string `json:"status"`
+	Health  string `json:"health,omitempty"`
+}
+
+// dockerContainer is the JSON format of docker ps.
+type dockerContainer struct {
+	ID     string `json:"ID"`
+	Names  string `json:"Names"`
+	Image  string `json:"Image"`
+	State  string `json:"State"`
+	Status string `json:"Status"`
+	Labels string `json:"Labels"`
+}
+
+// Containers returns the containers of a project, including stopped ones.
+func Containers(p project.Project) ([]Container, error) {
+	psCmd := exec.Command("docker", "ps", "-a", "--filter", fmt.Sprintf("label=com.docker.compose.project=%s", p.ComposeProject()), "--format", "{{json .}}")
+
+	output, err := psCmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("error listing containers: %w", err)
+	}
+
+	containers := []Container{}
+
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		if line == "" {
+			continue
+		}
+
+		var c dockerContainer
+
+		err := json.Unmarshal([]byte(line), &c)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing containers: %w", err)
+		}
+
+		containers = append(containers, Container{
+			ID:      c.ID,
+			Name:    c.Names,
+			Service: label(c.Labels, "com.docker.compose.service"),
+			Image:   c.Image,
+			State:   c.State,
+			Status:  c.Status,
+			Health:  health(c.Status),
+		})
+	}
+
+	return containers, nil
+}
+
+// Images returns the IDs of the images the containers of a project were created from.
+func Images(p project.Project) ([]string, error) {
+	psCmd := exec.Command("docker", "ps", "-a", "--quiet", "--no-trunc", "--filter", fmt.Sprintf("label=com.docker.compose.project=%s", p.ComposeProject()))
+
+	output, err := psCmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("error listing containers: %w", err)
+	}
+
+	return ContainerImages(strings.Fields(string(output)))
+}
+
+// ContainerImages returns the IDs of the images containers were created from, without duplicates.
+func ContainerImages(containerIDs []string) ([]string, error) {
+	if len(containerIDs) == 0 {
+		return nil, nil
+	}
+
+	inspectCmd := exec.Command("docker", append([]string{"inspect", "--format", "{{.Image}}"}, containerIDs...)...)
+
+	output, err := inspectCmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("error inspecting containers: %w", err)
+	}
+
+	var images []string
+
+	seen := map[string]bool{}
+
+	for _, image := range strings.Fields(string(output)) {
+		if !seen[image] {
+			seen[image] = true
+			images = append(images, image)
+		}
+	}
+
+	return images, nil
+}
+
+// label returns the value of a label from the comma separated labels docker ps prints.
+func label(labels string, name string) string {
+	for _, l := range strings.Split(labels, ",") {
+		if strings.HasPrefix(l, name+"=") {
+			return strings.TrimPrefix(l, name+"=")
+		}
+	}
+
+	return ""
+}
+
+// health returns the health check status from the status docker ps prints, e.g. "Up 2 hours (healthy)".
+func health(status string) string {
+	switch {
+	case strings.Contains(status, "(healthy)"):
+		return "healthy"
+	case strings.Contains(status, "(unhealthy)"):
+		return "unhealthy"
+	case strings.Contains(status, "(health: starting)"):
+		return "starting"
+	default:
+		return ""
+	}
+}
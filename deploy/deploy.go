@@ -0,0 +1,396 @@
+// Package deploy implements deploying projects with docker-compose used by pcompose
+package deploy
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/antoniomika/pcompose/notify"
+	"github.com/antoniomika/pcompose/project"
+	"github.com/antoniomika/pcompose/utils"
+	"github.com/spf13/viper"
+)
+
+const (
+	// TriggerPush is used for deploys triggered by a git push.
+	TriggerPush = "push"
+
+	// TriggerRedeploy is used for deploys triggered manually.
+	TriggerRedeploy = "redeploy"
+
+	// TriggerRollback is used for deploys of a previously deployed revision.
+	TriggerRollback = "rollback"
+
+	// TriggerMirror is used for deploys triggered by fetching a mirrored repository.
+	TriggerMirror = "mirror"
+
+	// StatusQueued is the status of a deploy waiting for another deploy of the project to finish.
+	StatusQueued = "queued"
+
+	// StatusRunning is the status of a deploy that is in progress.
+	StatusRunning = "running"
+
+	// StatusSucceeded is the status of a deploy that finished successfully.
+	StatusSucceeded = "succeeded"
+
+	// StatusFailed is the status of a deploy that failed.
+	StatusFailed = "failed"
+)
+
+// SharedDirEnv is the environment variable docker-compose commands get the shared directory of the project in.
+const SharedDirEnv = "PCOMPOSE_SHARED_DIR"
+
+// healthCheckInterval is how often containers are checked while waiting for their health checks to pass.
+const healthCheckInterval = 2 * time.Second
+
+// Options describe what to deploy and why.
+type Options struct {
+	// ID is the ID of the deploy record. A new ID is generated if empty.
+	ID string
+
+	// Trigger is what caused the deploy, one of the Trigger constants.
+	Trigger string
+
+	// Ref, OldRev and NewRev describe the pushed ref for push deploys.
+	Ref    string
+	OldRev string
+	NewRev string
+
+	// Revision is the commit to deploy. The default branch is deployed if empty.
+	Revision string
+
+	// Pusher is the identity that caused the deploy.
+	Pusher string
+
+	// Output receives the output of the deploy commands.
+	Output io.Writer
+}
+
+// Run deploys a project and records the deploy in its history.
+func Run(p project.Project, opts Options) (*Record, error) {
+	if opts.Output == nil {
+		opts.Output = io.Discard
+	}
+
+	if opts.ID == "" {
+		opts.ID = NewID()
+	}
+
+	record := &Record{
+		ID:       opts.ID,
+		Project:  p.FullName(),
+		Trigger:  opts.Trigger,
+		Ref:      opts.Ref,
+		OldRev:   opts.OldRev,
+		NewRev:   opts.NewRev,
+		Revision: opts.Revision,
+		Pusher:   opts.Pusher,
+		Status:   StatusQueued,
+		Start:    time.Now(),
+	}
+
+	err := saveRecord(p, record)
+	if err != nil {
+		log.Println("Error saving deploy record:", err)
+	}
+
+	output, closeLog, err := openLog(p, record.ID, opts.Output)
+	if err != nil {
+		log.Println("Error creating deploy log:", err)
+	}
+
+	defer closeLog()
+
+	opts.Output = output
+
+	fmt.Fprintf(output, "-----> Deploy %s of %s\n", record.ID, p.FullName())
+
+	unlock, err := Lock(p)
+	if err != nil {
+		end := time.Now()
+		record.End = &end
+		record.Status = StatusFailed
+		record.Error = err.Error()
+
+		saveErr := saveRecord(p, record)
+		if saveErr != nil {
+			log.Println("Error saving deploy record:", saveErr)
+		}
+
+		fmt.Fprintf(output, "-----> Deploy failed: %s\n", err)
+
+		notify.Send(event(record, notify.EventFailed))
+
+		return record, err
+	}
+
+	defer unlock()
+
+	record.Status = StatusRunning
+	record.Start = time.Now()
+
+	err = saveRecord(p, record)
+	if err != nil {
+		log.Println("Error saving deploy record:", err)
+	}
+
+	notify.Send(event(record, notify.EventStarted))
+
+	err = run(p, opts, record)
+
+	end := time.Now()
+	record.End = &end
+	record.Status = StatusSucceeded
+
+	if err != nil {
+		record.Status = StatusFailed
+		record.Error = err.Error()
+	}
+
+	saveErr := saveRecord(p, record)
+	if saveErr != nil {
+		log.Println("Error saving deploy record:", saveErr)
+	}
+
+	if err != nil {
+		fmt.Fprintf(output, "-----> Deploy failed after %s: %s\n", record.Duration().Round(time.Second), err)
+	} else {
+		fmt.Fprintf(output, "-----> Deployed %s in %s\n", utils.ShortRev(record.Revision), record.Duration().Round(time.Second))
+	}
+
+	switch {
+	case err != nil:
+		notify.Send(event(record, notify.EventFailed))
+	case record.Trigger == TriggerRollback:
+		notify.Send(event(record, notify.EventRolledBack))
+	default:
+		notify.Send(event(record, notify.EventSucceeded))
+	}
+
+	return record, err
+}
+
+// event returns the notification of a deploy lifecycle event.
+func event(record *Record, name string) notify.Event {
+	e := notify.Event{
+		Event:    name,
+		DeployID: record.ID,
+		Project:  record.Project,
+		Trigger:  record.Trigger,
+		Ref:      record.Ref,
+		OldRev:   record.OldRev,
+		NewRev:   record.NewRev,
+		Revision: record.Revision,
+		Pusher:   record.Pusher,
+		Error:    record.Error,
+	}
+
+	if name != notify.EventStarted {
+		e.Duration = record.Duration()
+	}
+
+	return e
+}
+
+// run checks out the revision to deploy into a release and brings the compose project up from it.
+func run(p project.Project, opts Options, record *Record) error {
+	revision := opts.Revision
+
+	// Only the default-branch policy follows a branch, other policies redeploy the deployed revision.
+	if revision == "" && Policy(p) != PolicyDefaultBranch {
+		if current, err := CurrentRevision(p); err == nil {
+			revision = current
+		}
+	}
+
+	if revision == "" {
+		stage(opts.Output, StageFetch, "Checking out the default branch")
+	} else {
+		stage(opts.Output, StageFetch, "Checking out %s", utils.ShortRev(revision))
+	}
+
+	revision, releaseDir, err := checkout(p, revision, opts.Output)
+	if err != nil {
+		return err
+	}
+
+	record.Revision = revision
+	composeDir := path.Join(releaseDir, p.Config().Deploy.Directory)
+
+	routes, err := writeRoutes(p, composeDir, opts.Output)
+	if err != nil {
+		return err
+	}
+
+	stage(opts.Output, StageNetwork, "Connecting the frontends to %s", networkNames(PublicNetworks(p, composeDir)))
+
+	ConnectFrontend(p, composeDir)
+
+	stage(opts.Output, StageBuild, "Building images of %s", utils.ShortRev(revision))
+
+	buildCmd := compose(p, composeDir, "build")
+	buildCmd.Stdout = opts.Output
+	buildCmd.Stderr = opts.Output
+
+	err = buildCmd.Run()
+	if err != nil {
+		return fmt.Errorf("error running docker-compose build: %w", err)
+	}
+
+	notify.Send(event(record, notify.EventBuilt))
+
+	stage(opts.Output, StageUp, "Starting containers")
+
+	upCmd := compose(p, composeDir, "up", "-d")
+	upCmd.Stdout = opts.Output
+	upCmd.Stderr = opts.Output
+
+	err = upCmd.Run()
+	if err != nil {
+		return fmt.Errorf("error running docker-compose up: %w", err)
+	}
+
+	err = applyRoutes(p, routes)
+	if err != nil {
+		return err
+	}
+
+	// The containers run from the new release now, so it becomes the current one.
+	err = activate(p, revision)
+	if err != nil {
+		return err
+	}
+
+	// The images are recorded so garbage collection keeps the images of recent deploys.
+	record.Images, err = Images(p)
+	if err != nil {
+		log.Println("Error listing images of deploy:", err)
+	}
+
+	pruneReleases(p)
+
+	stage(opts.Output, StageHealth, "Waiting for health checks")
+
+	return waitHealthy(p, opts.Output)
+}
+
+// waitHealthy waits up to the deploy-health-timeout for the health checks of the
+// containers of a project to pass and prints their status.
+func waitHealthy(p project.Project, output io.Writer) error {
+	deadline := time.Now().Add(viper.GetDuration("deploy-health-timeout"))
+
+	for {
+		containers, err := Containers(p)
+		if err != nil {
+			return err
+		}
+
+		starting := 0
+
+		for _, c := range containers {
+			switch c.Health {
+			case "unhealthy":
+				return fmt.Errorf("container %s is unhealthy", c.Name)
+			case "starting":
+				starting++
+			}
+		}
+
+		if starting == 0 {
+			for _, c := range containers {
+				fmt.Fprintf(output, "%s: %s\n", c.Name, c.Status)
+			}
+
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for %d containers to become healthy", starting)
+		}
+
+		time.Sleep(healthCheckInterval)
+	}
+}
+
+// Compose returns a docker-compose command that runs in the context of the current release of the project.
+func Compose(p project.Project, args ...string) *exec.Cmd {
+	return compose(p, p.ComposeDir(), args...)
+}
+
+// compose returns a docker-compose command that runs in a compose directory of the project.
+// PCOMPOSE_SHARED_DIR lets compose files refer to the shared directory of the project.
+func compose(p project.Project, composeDir string, args ...string) *exec.Cmd {
+	composeArgs := []string{"-p", p.ComposeProject()}
+	for _, composeFile := range composeFiles(p, composeDir) {
+		composeArgs = append(composeArgs, "-f", composeFile)
+	}
+
+	cmd := exec.Command("docker-compose", append(composeArgs, args...)...)
+	cmd.Dir = composeDir
+	cmd.Env = append(os.Environ(), fmt.Sprintf("%s=%s", SharedDirEnv, p.SharedDir()))
+
+	return cmd
+}
+
+// Lock takes an exclusive lock on the project so only a single deploy, or another operation that
+// changes its containers such as restoring a volume backup, runs at a time.
+func Lock(p project.Project) (func(), error) {
+	return lock(p, syscall.LOCK_EX)
+}
+
+// RLock takes a shared lock on the project for operations that use its current release without
+// changing it, such as job runs. They run alongside each other but not during a deploy.
+func RLock(p project.Project) (func(), error) {
+	return lock(p, syscall.LOCK_SH)
+}
+
+// lock takes a lock on the lock file of the project, which is shared between the server and hooks.
+func lock(p project.Project, how int) (func(), error) {
+	err := os.MkdirAll(p.MetadataDir(), os.FileMode(0755))
+	if err != nil {
+		return nil, err
+	}
+
+	lockFile, err := os.OpenFile(path.Join(p.MetadataDir(), "deploy.lock"), os.O_CREATE|os.O_RDWR, os.FileMode(0644))
+	if err != nil {
+		return nil, err
+	}
+
+	err = syscall.Flock(int(lockFile.Fd()), how)
+	if err != nil {
+		lockFile.Close()
+		return nil, err
+	}
+
+	return func() {
+		err := syscall.Flock(int(lockFile.Fd()), syscall.LOCK_UN)
+		if err != nil {
+			log.Println("Error unlocking project:", err)
+		}
+
+		lockFile.Close()
+	}, nil
+}
+
+// NewID returns a new deploy ID that sorts by creation time.
+func NewID() string {
+	suffix := make([]byte, 3)
+
+	_, err := rand.Read(suffix)
+	if err != nil {
+		log.Println("Error generating deploy ID:", err)
+	}
+
+	timestamp := strings.Replace(time.Now().UTC().Format("20060102T150405.000000Z"), ".", "", 1)
+
+	return fmt.Sprintf("%s-%s", timestamp, hex.EncodeToString(suffix))
+}
@@ -0,0 +1,81 @@
+package deploy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/antoniomika/pcompose/project"
+)
+
+// locked takes a lock in the background and returns a channel receiving its unlock function once it is taken.
+func locked(t *testing.T, lock func(project.Project) (func(), error), p project.Project) chan func() {
+	t.Helper()
+
+	unlocked := make(chan func(), 1)
+
+	go func() {
+		unlock, err := lock(p)
+		if err != nil {
+			t.Errorf("taking lock: %v", err)
+			return
+		}
+
+		unlocked <- unlock
+	}()
+
+	return unlocked
+}
+
+// waitLocked returns the unlock function of a lock that is taken within a second, or nil if it isn't.
+func waitLocked(unlocked chan func()) func() {
+	select {
+	case unlock := <-unlocked:
+		return unlock
+	case <-time.After(time.Second):
+		return nil
+	}
+}
+
+func TestLock(t *testing.T) {
+	p := project.Project{Name: "alice/app", RepoDir: t.TempDir()}
+
+	// Job runs share the lock.
+	unlockFirst := waitLocked(locked(t, RLock, p))
+	unlockSecond := waitLocked(locked(t, RLock, p))
+
+	if unlockFirst == nil || unlockSecond == nil {
+		t.Fatal("RLock() blocked while another shared lock was held")
+	}
+
+	// Deploys wait for every job run.
+	deploy := locked(t, Lock, p)
+
+	unlockFirst()
+
+	if waitLocked(deploy) != nil {
+		t.Fatal("Lock() was taken while a shared lock was held")
+	}
+
+	unlockSecond()
+
+	unlockDeploy := waitLocked(deploy)
+	if unlockDeploy == nil {
+		t.Fatal("Lock() blocked after the shared locks were released")
+	}
+
+	// Job runs wait for deploys.
+	run := locked(t, RLock, p)
+
+	if waitLocked(run) != nil {
+		t.Fatal("RLock() was taken during a deploy")
+	}
+
+	unlockDeploy()
+
+	unlockRun := waitLocked(run)
+	if unlockRun == nil {
+		t.Fatal("RLock() blocked after the deploy finished")
+	}
+
+	unlockRun()
+}
@@ -0,0 +1,129 @@
+package deploy
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/antoniomika/pcompose/project"
+	"github.com/spf13/viper"
+)
+
+const (
+	// StageFetch checks out the revision to deploy.
+	StageFetch = "fetch"
+
+	// StageNetwork connects the frontends to the public networks of the project.
+	StageNetwork = "network"
+
+	// StageBuild builds the images of the project.
+	StageBuild = "build"
+
+	// StageUp starts the containers of the project.
+	StageUp = "up"
+
+	// StageHealth waits for the health checks of the containers to pass.
+	StageHealth = "health"
+)
+
+// logFile returns the file the output of a deploy is stored in.
+func logFile(p project.Project, id string) string {
+	return path.Join(recordsDir(p), id+".log")
+}
+
+// ReadLog returns the timestamped output of a deploy.
+func ReadLog(p project.Project, id string) ([]byte, error) {
+	if id == "" || strings.ContainsAny(id, "/.") {
+		return nil, ErrRecordNotFound
+	}
+
+	data, err := os.ReadFile(logFile(p, id))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrRecordNotFound
+		}
+
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// openLog creates the log file of a deploy and returns a writer that streams to the output
+// of the deploy and appends timestamped lines to the log file, and a function closing it.
+func openLog(p project.Project, id string, output io.Writer) (io.Writer, func(), error) {
+	err := os.MkdirAll(recordsDir(p), os.FileMode(0755))
+	if err != nil {
+		return output, func() {}, err
+	}
+
+	file, err := os.OpenFile(logFile(p, id), os.O_CREATE|os.O_WRONLY|os.O_APPEND, os.FileMode(0644))
+	if err != nil {
+		return output, func() {}, err
+	}
+
+	return NewLogWriter(output, file), func() {
+		file.Close()
+	}, nil
+}
+
+// NewLogWriter returns a writer that streams to output and writes the same data to file
+// with every line prefixed by a timestamp, the way deploy logs are stored.
+func NewLogWriter(output io.Writer, file io.Writer) io.Writer {
+	return &logWriter{
+		output: output,
+		file:   file,
+	}
+}
+
+// logWriter writes the output of a deploy to the pusher and prefixes each line of the log file with a timestamp.
+type logWriter struct {
+	mu        sync.Mutex
+	output    io.Writer
+	file      io.Writer
+	midLine   bool
+	outputErr error
+}
+
+// Write implements io.Writer. Errors writing to the output, e.g. because the pusher
+// disconnected, are ignored so the log file stays complete.
+func (l *logWriter) Write(data []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.outputErr == nil {
+		_, l.outputErr = l.output.Write(data)
+	}
+
+	var stamped strings.Builder
+
+	for _, line := range strings.SplitAfter(string(data), "\n") {
+		if line == "" {
+			continue
+		}
+
+		if !l.midLine {
+			stamped.WriteString(time.Now().Format(viper.GetString("time-format")))
+			stamped.WriteString(" | ")
+		}
+
+		stamped.WriteString(line)
+		l.midLine = !strings.HasSuffix(line, "\n")
+	}
+
+	_, err := io.WriteString(l.file, stamped.String())
+	if err != nil {
+		return 0, err
+	}
+
+	return len(data), nil
+}
+
+// stage marks the start of a deploy stage in its output.
+func stage(output io.Writer, name string, format string, args ...interface{}) {
+	fmt.Fprintf(output, "-----> [%s] %s\n", name, fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,286 @@
+package deploy
+
+import (
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+
+	"github.com/antoniomika/pcompose/project"
+	"github.com/spf13/viper"
+)
+
+// NetworkLabel is set on the networks pcompose creates to the full name of their project,
+// so they can be removed once the project is taken down or deleted.
+const NetworkLabel = "pcompose.project"
+
+// PublicNetwork is a network of a project that frontend containers are connected to.
+type PublicNetwork struct {
+	// Name is the name of the network in the compose files, e.g. default.
+	Name string
+
+	// DockerName is the name of the docker network, e.g. user_httpbin_default.
+	DockerName string
+
+	// External is set for networks docker-compose doesn't create, which pcompose doesn't create or remove either.
+	External bool
+
+	// Driver and Internal are the settings of the network in the compose files, which the
+	// network is created with so docker-compose accepts it as its own.
+	Driver   string
+	Internal bool
+
+	// Frontends are the names of the frontend containers connected to the network.
+	Frontends []string
+}
+
+// FrontendContainers returns the names of the frontend containers set in frontend-container-name.
+func FrontendContainers() []string {
+	var containers []string
+
+	for _, container := range strings.Split(viper.GetString("frontend-container-name"), ",") {
+		if container = strings.TrimSpace(container); container != "" {
+			containers = append(containers, container)
+		}
+	}
+
+	return containers
+}
+
+// PublicNetworks returns the public networks of a project in a compose directory, resolving their
+// docker names and settings from the compose files. The default network is public if none are configured.
+func PublicNetworks(p project.Project, composeDir string) []PublicNetwork {
+	configs := p.Config().Deploy.PublicNetworks
+	if len(configs) == 0 {
+		configs = []project.PublicNetworkConfig{{Name: "default"}}
+	}
+
+	composeConfigs := readComposeFiles(p, composeDir)
+
+	var networks []PublicNetwork
+
+	for _, config := range configs {
+		network := PublicNetwork{
+			Name:       config.Name,
+			DockerName: fmt.Sprintf("%s_%s", p.ComposeProject(), config.Name),
+			Frontends:  config.Frontends,
+		}
+
+		if len(network.Frontends) == 0 {
+			network.Frontends = FrontendContainers()
+		}
+
+		// Later compose files override the settings of earlier ones.
+		for _, composeConfig := range composeConfigs {
+			key := "networks." + config.Name
+			if !composeConfig.IsSet(key) {
+				continue
+			}
+
+			// external is either a bool or, in old compose files, a map with the name of the network.
+			if composeConfig.GetBool(key+".external") || composeConfig.IsSet(key+".external.name") {
+				network.External = true
+				network.DockerName = config.Name
+			}
+
+			if name := composeConfig.GetString(key + ".external.name"); name != "" {
+				network.DockerName = name
+			}
+
+			if name := composeConfig.GetString(key + ".name"); name != "" {
+				network.DockerName = name
+			}
+
+			if driver := composeConfig.GetString(key + ".driver"); driver != "" {
+				network.Driver = driver
+			}
+
+			network.Internal = network.Internal || composeConfig.GetBool(key+".internal")
+		}
+
+		networks = append(networks, network)
+	}
+
+	return networks
+}
+
+// networkNames returns the docker names of networks.
+func networkNames(networks []PublicNetwork) string {
+	var names []string
+	for _, network := range networks {
+		names = append(names, network.DockerName)
+	}
+
+	return strings.Join(names, ", ")
+}
+
+// ConnectFrontend creates the public networks of the project in a compose directory and connects
+// their frontend containers to them.
+func ConnectFrontend(p project.Project, composeDir string) {
+	for _, network := range PublicNetworks(p, composeDir) {
+		if !network.External {
+			// The compose labels make docker-compose treat the network as one it created.
+			args := []string{"network", "create",
+				"--label", fmt.Sprintf("%s=%s", NetworkLabel, p.FullName()),
+				"--label", fmt.Sprintf("com.docker.compose.project=%s", p.ComposeProject()),
+				"--label", fmt.Sprintf("com.docker.compose.network=%s", network.Name),
+			}
+
+			if network.Driver != "" {
+				args = append(args, "--driver", network.Driver)
+			}
+
+			if network.Internal {
+				args = append(args, "--internal")
+			}
+
+			_ = exec.Command("docker", append(args, network.DockerName)...).Run()
+		}
+
+		for _, frontend := range network.Frontends {
+			_ = exec.Command("docker", "network", "connect", network.DockerName, frontend).Run()
+		}
+	}
+}
+
+// DisconnectFrontend disconnects the frontend containers from the public networks of the project
+// in a compose directory. They stay connected to external networks, which other projects may share.
+func DisconnectFrontend(p project.Project, composeDir string) {
+	for _, network := range PublicNetworks(p, composeDir) {
+		if network.External {
+			continue
+		}
+
+		for _, frontend := range network.Frontends {
+			_ = exec.Command("docker", "network", "disconnect", "--force", network.DockerName, frontend).Run()
+		}
+	}
+}
+
+// RemoveNetworks disconnects the frontend from the networks pcompose created for a project and
+// removes them. docker-compose down may have removed them already.
+func RemoveNetworks(p project.Project) error {
+	networks, err := Networks()
+	if err != nil {
+		return err
+	}
+
+	for network, owner := range networks {
+		if owner != p.FullName() {
+			continue
+		}
+
+		err := RemoveNetwork(network)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Networks returns the networks pcompose created, mapped to the full names of their projects.
+func Networks() (map[string]string, error) {
+	output, err := exec.Command("docker", "network", "ls", "--filter", "label="+NetworkLabel, "--format", fmt.Sprintf(`{{.Name}}\t{{.Label %q}}`, NetworkLabel)).Output()
+	if err != nil {
+		return nil, fmt.Errorf("error listing networks: %w", err)
+	}
+
+	networks := map[string]string{}
+
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		name, owner, _ := strings.Cut(line, "\t")
+		if name != "" && owner != "" {
+			networks[name] = owner
+		}
+	}
+
+	return networks, nil
+}
+
+// ReconcileNetworks removes the networks and routes pcompose created for projects that don't exist
+// anymore, e.g. because their repository or deployment was deleted.
+func ReconcileNetworks() {
+	networks, err := Networks()
+	if err != nil {
+		log.Println("Error reconciling networks:", err)
+		return
+	}
+
+	projects, err := project.List()
+	if err != nil {
+		log.Println("Error reconciling networks:", err)
+		return
+	}
+
+	known := map[string]bool{}
+	for _, p := range projects {
+		known[p.FullName()] = true
+	}
+
+	for network, owner := range networks {
+		if known[owner] {
+			continue
+		}
+
+		log.Printf("Removing network %s of %s, which doesn't exist anymore", network, owner)
+
+		err := RemoveNetwork(network)
+		if err != nil {
+			log.Println("Error reconciling networks:", err)
+		}
+
+		err = RemoveRoutes(project.New(owner).ComposeProject())
+		if err != nil {
+			log.Println("Error reconciling routes:", err)
+		}
+	}
+}
+
+// RemoveNetwork disconnects the frontend containers from a network and removes it. Networks
+// that containers besides the frontends are still connected to aren't removed.
+func RemoveNetwork(network string) error {
+	for _, frontend := range allFrontendContainers() {
+		_ = exec.Command("docker", "network", "disconnect", "--force", network, frontend).Run()
+	}
+
+	output, err := exec.Command("docker", "network", "rm", network).CombinedOutput()
+	if err != nil && !strings.Contains(string(output), "No such network") && !strings.Contains(string(output), "not found") {
+		return fmt.Errorf("unable to remove network %s: %s", network, strings.TrimSpace(string(output)))
+	}
+
+	return nil
+}
+
+// allFrontendContainers returns the frontend containers set in frontend-container-name and the
+// public networks of every project, since the project a network belonged to may be gone.
+func allFrontendContainers() []string {
+	seen := map[string]bool{}
+
+	var containers []string
+
+	add := func(names []string) {
+		for _, name := range names {
+			if !seen[name] {
+				seen[name] = true
+				containers = append(containers, name)
+			}
+		}
+	}
+
+	add(FrontendContainers())
+
+	for _, config := range project.Configs() {
+		for _, network := range config.Deploy.PublicNetworks {
+			add(network.Frontends)
+		}
+
+		for _, deployment := range config.Deployments {
+			for _, network := range deployment.PublicNetworks {
+				add(network.Frontends)
+			}
+		}
+	}
+
+	return containers
+}
@@ -0,0 +1,147 @@
+package deploy
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+
+	"github.com/antoniomika/pcompose/project"
+)
+
+const (
+	// PolicyDefaultBranch deploys pushes to the default branch of the repository.
+	PolicyDefaultBranch = "default-branch"
+
+	// PolicyTags deploys pushed annotated tags matching a pattern.
+	PolicyTags = "tags"
+
+	// PolicyPushed deploys whichever ref was pushed.
+	PolicyPushed = "pushed"
+)
+
+// ZeroRev is the revision git uses for refs that don't exist.
+var ZeroRev = strings.Repeat("0", 40)
+
+// RefUpdate is a ref changed by a push or a mirror fetch.
+type RefUpdate struct {
+	Ref    string
+	OldRev string
+	NewRev string
+}
+
+// Policy returns the deploy ref policy of a project.
+func Policy(p project.Project) string {
+	policy := p.Config().Deploy.Refs
+	if policy == "" {
+		return PolicyDefaultBranch
+	}
+
+	return policy
+}
+
+// PushedRevision returns the commit to deploy for an updated ref according to the
+// deploy ref policy of the project, or an error describing why it isn't deployed.
+func PushedRevision(p project.Project, update RefUpdate) (string, error) {
+	if update.NewRev == "" || update.NewRev == ZeroRev {
+		return "", fmt.Errorf("%s was deleted", update.Ref)
+	}
+
+	config := p.Config().Deploy
+
+	switch Policy(p) {
+	case PolicyDefaultBranch:
+		defaultBranch := project.DefaultBranch(p.RepoDir)
+		if update.Ref != defaultBranch {
+			// The first branch pushed to a repository without a default branch becomes the default branch.
+			if _, exists := project.Refs(p.RepoDir)[defaultBranch]; exists || !strings.HasPrefix(update.Ref, "refs/heads/") {
+				return "", fmt.Errorf("only the default branch %s is deployed", defaultBranch)
+			}
+		}
+	case PolicyTags:
+		if !strings.HasPrefix(update.Ref, "refs/tags/") {
+			return "", fmt.Errorf("only tags are deployed")
+		}
+
+		if config.Tags != "" {
+			if match, err := path.Match(config.Tags, strings.TrimPrefix(update.Ref, "refs/tags/")); err != nil || !match {
+				return "", fmt.Errorf("only tags matching %s are deployed", config.Tags)
+			}
+		}
+
+		objectType, err := RepoGit(p, "cat-file", "-t", update.NewRev)
+		if err != nil {
+			return "", err
+		}
+
+		if objectType != "tag" {
+			return "", fmt.Errorf("only annotated tags are deployed")
+		}
+	case PolicyPushed:
+	default:
+		return "", fmt.Errorf("unknown deploy ref policy %q", Policy(p))
+	}
+
+	return RepoGit(p, "rev-parse", "--verify", update.NewRev+"^{commit}")
+}
+
+// DeployableRef returns the last ref out of a list of updates that the policy deploys, along with
+// the commit to deploy. Each skipped ref is passed to skip with the reason it isn't deployed.
+func DeployableRef(p project.Project, updates []RefUpdate, skip func(update RefUpdate, err error)) (RefUpdate, string, bool) {
+	var deployable RefUpdate
+	var revision string
+
+	for _, update := range updates {
+		rev, err := PushedRevision(p, update)
+		if err == nil && !changesDeployment(p, update) {
+			err = fmt.Errorf("it doesn't change %s", path.Join("/", p.Config().Deploy.Directory))
+		}
+
+		if err != nil {
+			if skip != nil {
+				skip(update, err)
+			}
+
+			continue
+		}
+
+		deployable = update
+		revision = rev
+	}
+
+	return deployable, revision, revision != ""
+}
+
+// changesDeployment returns whether an update changes the directory or compose files of a project. Every update
+// does unless skip-unchanged is enabled. New refs always do, since there is nothing to compare them with.
+func changesDeployment(p project.Project, update RefUpdate) bool {
+	config := p.Config().Deploy
+	if !config.SkipUnchanged || update.OldRev == "" || update.OldRev == ZeroRev {
+		return true
+	}
+
+	pathspecs := []string{path.Join(".", config.Directory)}
+	for _, composeFile := range config.ComposeFiles {
+		pathspecs = append(pathspecs, path.Join(config.Directory, composeFile))
+	}
+
+	// git diff --quiet exits with 1 if there are changes and fails with a higher status on errors, which deploys as well.
+	_, err := RepoGit(p, append([]string{"diff", "--quiet", update.OldRev, update.NewRev, "--"}, pathspecs...)...)
+
+	return err != nil
+}
+
+// RepoGit runs a git command in the bare repository of a project and returns its trimmed output.
+// The environment is kept so hooks can read objects of a push that are still in quarantine.
+func RepoGit(p project.Project, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Env = append(os.Environ(), fmt.Sprintf("GIT_DIR=%s", p.RepoDir))
+
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("error running git %s: %w", args[0], err)
+	}
+
+	return strings.TrimSpace(string(output)), nil
+}
@@ -0,0 +1,99 @@
+package deploy
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/antoniomika/pcompose/internal/gittest"
+	"github.com/antoniomika/pcompose/project"
+	"github.com/spf13/viper"
+)
+
+// newPolicyRepo creates a repository with a commit on main, an annotated tag v1.0.0 and a lightweight tag latest.
+func newPolicyRepo(t *testing.T) project.Project {
+	t.Helper()
+
+	gittest.Setup(t)
+
+	dir := t.TempDir()
+
+	gittest.Run(t, dir, "init", "-q", "-b", "main")
+	gittest.Run(t, dir, "commit", "-q", "--allow-empty", "-m", "initial")
+	gittest.Run(t, dir, "tag", "-m", "release", "v1.0.0")
+	gittest.Run(t, dir, "tag", "latest")
+
+	return project.Project{Name: "alice/app", RepoDir: filepath.Join(dir, ".git")}
+}
+
+func TestPushedRevision(t *testing.T) {
+	p := newPolicyRepo(t)
+
+	commit := gittest.Run(t, p.RepoDir, "rev-parse", "HEAD")
+	tag := gittest.Run(t, p.RepoDir, "rev-parse", "refs/tags/v1.0.0")
+
+	tests := []struct {
+		name    string
+		deploy  map[string]interface{}
+		update  RefUpdate
+		wantErr bool
+	}{
+		{name: "default branch", update: RefUpdate{Ref: "refs/heads/main", NewRev: commit}},
+		{name: "other branch", update: RefUpdate{Ref: "refs/heads/feature", NewRev: commit}, wantErr: true},
+		{name: "tag with default branch policy", update: RefUpdate{Ref: "refs/tags/v1.0.0", NewRev: tag}, wantErr: true},
+		{name: "deleted default branch", update: RefUpdate{Ref: "refs/heads/main", OldRev: commit, NewRev: ZeroRev}, wantErr: true},
+		{name: "annotated tag", deploy: map[string]interface{}{"refs": PolicyTags}, update: RefUpdate{Ref: "refs/tags/v1.0.0", NewRev: tag}},
+		{name: "matching tag", deploy: map[string]interface{}{"refs": PolicyTags, "tags": "v*"}, update: RefUpdate{Ref: "refs/tags/v1.0.0", NewRev: tag}},
+		{name: "tag not matching", deploy: map[string]interface{}{"refs": PolicyTags, "tags": "release-*"}, update: RefUpdate{Ref: "refs/tags/v1.0.0", NewRev: tag}, wantErr: true},
+		{name: "lightweight tag", deploy: map[string]interface{}{"refs": PolicyTags}, update: RefUpdate{Ref: "refs/tags/latest", NewRev: commit}, wantErr: true},
+		{name: "branch with tags policy", deploy: map[string]interface{}{"refs": PolicyTags}, update: RefUpdate{Ref: "refs/heads/main", NewRev: commit}, wantErr: true},
+		{name: "pushed branch", deploy: map[string]interface{}{"refs": PolicyPushed}, update: RefUpdate{Ref: "refs/heads/feature", NewRev: commit}},
+		{name: "pushed tag", deploy: map[string]interface{}{"refs": PolicyPushed}, update: RefUpdate{Ref: "refs/tags/v1.0.0", NewRev: tag}},
+		{name: "unknown policy", deploy: map[string]interface{}{"refs": "everything"}, update: RefUpdate{Ref: "refs/heads/main", NewRev: commit}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			viper.Set("projects", []map[string]interface{}{{"name": p.Name, "deploy": tt.deploy}})
+			defer viper.Reset()
+
+			revision, err := PushedRevision(p, tt.update)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("PushedRevision() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			// Tags deploy the commit they point to.
+			if !tt.wantErr && revision != commit {
+				t.Errorf("PushedRevision() = %s, want %s", revision, commit)
+			}
+		})
+	}
+}
+
+func TestDeployableRef(t *testing.T) {
+	p := newPolicyRepo(t)
+
+	commit := gittest.Run(t, p.RepoDir, "rev-parse", "HEAD")
+	tag := gittest.Run(t, p.RepoDir, "rev-parse", "refs/tags/v1.0.0")
+
+	viper.Set("projects", []map[string]interface{}{{"name": p.Name, "deploy": map[string]interface{}{"refs": PolicyTags}}})
+	defer viper.Reset()
+
+	var skipped []string
+
+	update, revision, ok := DeployableRef(p, []RefUpdate{
+		{Ref: "refs/heads/main", OldRev: ZeroRev, NewRev: commit},
+		{Ref: "refs/tags/v1.0.0", OldRev: ZeroRev, NewRev: tag},
+		{Ref: "refs/tags/latest", OldRev: ZeroRev, NewRev: commit},
+	}, func(update RefUpdate, err error) {
+		skipped = append(skipped, update.Ref)
+	})
+
+	if !ok || update.Ref != "refs/tags/v1.0.0" || revision != commit {
+		t.Errorf("DeployableRef() = %s, %s, %v, want refs/tags/v1.0.0, %s, true", update.Ref, revision, ok, commit)
+	}
+
+	if strings.Join(skipped, ",") != "refs/heads/main,refs/tags/latest" {
+		t.Errorf("DeployableRef() skipped %v, want refs/heads/main and refs/tags/latest", skipped)
+	}
+}
@@ -0,0 +1,149 @@
+package deploy
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/antoniomika/pcompose/project"
+)
+
+// ErrRecordNotFound is returned when a deploy doesn't exist.
+var ErrRecordNotFound = errors.New("deploy not found")
+
+// Record is the history entry of a single deploy.
+type Record struct {
+	ID       string     `json:"id"`
+	Project  string     `json:"project"`
+	Trigger  string     `json:"trigger"`
+	Ref      string     `json:"ref,omitempty"`
+	OldRev   string     `json:"old_rev,omitempty"`
+	NewRev   string     `json:"new_rev,omitempty"`
+	Revision string     `json:"revision,omitempty"`
+	Pusher   string     `json:"pusher,omitempty"`
+	Images   []string   `json:"images,omitempty"`
+	Status   string     `json:"status"`
+	Error    string     `json:"error,omitempty"`
+	Start    time.Time  `json:"start"`
+	End      *time.Time `json:"end,omitempty"`
+}
+
+// Duration returns how long the deploy took, or has been running for.
+func (r Record) Duration() time.Duration {
+	if r.End == nil {
+		return time.Since(r.Start)
+	}
+
+	return r.End.Sub(r.Start)
+}
+
+// recordsDir returns the directory deploy records of a project are stored in.
+func recordsDir(p project.Project) string {
+	return path.Join(p.MetadataDir(), "deploys")
+}
+
+// saveRecord atomically writes a deploy record.
+func saveRecord(p project.Project, record *Record) error {
+	err := os.MkdirAll(recordsDir(p), os.FileMode(0755))
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	recordFile := path.Join(recordsDir(p), record.ID+".json")
+
+	err = os.WriteFile(recordFile+".tmp", data, os.FileMode(0644))
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(recordFile+".tmp", recordFile)
+}
+
+// GetRecord returns a single deploy record of a project.
+func GetRecord(p project.Project, id string) (*Record, error) {
+	if id == "" || strings.ContainsAny(id, "/.") {
+		return nil, ErrRecordNotFound
+	}
+
+	data, err := os.ReadFile(path.Join(recordsDir(p), id+".json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrRecordNotFound
+		}
+
+		return nil, err
+	}
+
+	record := &Record{}
+
+	err = json.Unmarshal(data, record)
+	if err != nil {
+		return nil, err
+	}
+
+	return record, nil
+}
+
+// Records returns the deploy history of a project, newest first.
+func Records(p project.Project) ([]*Record, error) {
+	entries, err := os.ReadDir(recordsDir(p))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	var records []*Record
+
+	for _, entry := range entries {
+		if entry.IsDir() || path.Ext(entry.Name()) != ".json" {
+			continue
+		}
+
+		record, err := GetRecord(p, strings.TrimSuffix(entry.Name(), ".json"))
+		if err != nil {
+			continue
+		}
+
+		records = append(records, record)
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].ID > records[j].ID
+	})
+
+	return records, nil
+}
+
+// PreviousRevision returns the most recently deployed revision that succeeded and
+// differs from the revision currently deployed, which is what a rollback deploys.
+func PreviousRevision(p project.Project) (string, error) {
+	current, err := CurrentRevision(p)
+	if err != nil {
+		return "", err
+	}
+
+	records, err := Records(p)
+	if err != nil {
+		return "", err
+	}
+
+	for _, record := range records {
+		if record.Status == StatusSucceeded && record.Revision != "" && record.Revision != current {
+			return record.Revision, nil
+		}
+	}
+
+	return "", errors.New("no previous revision to roll back to")
+}
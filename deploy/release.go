@@ -0,0 +1,245 @@
+package deploy
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/antoniomika/pcompose/project"
+	"github.com/antoniomika/pcompose/utils"
+	"github.com/spf13/viper"
+)
+
+// releaseMarker is written to the git directory of a release once it is complete, so a
+// release whose checkout was interrupted isn't reused. Its modification time is when the
+// release was last deployed.
+const releaseMarker = "pcompose-release"
+
+// checkout materializes a revision, or the default branch if revision is empty, in a release
+// directory of its own and returns the commit and the release directory. A release that was
+// already checked out is reused, which makes redeploys and rollbacks fast.
+func checkout(p project.Project, revision string, output io.Writer) (string, string, error) {
+	if revision == "" {
+		revision = project.DefaultBranch(p.RepoDir)
+		if revision == "" {
+			revision = "refs/heads/main"
+		}
+	}
+
+	commit, err := RepoGit(p, "rev-parse", "--verify", "--quiet", revision+"^{commit}")
+	if err != nil {
+		return "", "", fmt.Errorf("unknown revision %s", revision)
+	}
+
+	releaseDir := path.Join(p.ReleasesDir(), commit)
+	marker := path.Join(releaseDir, ".git", releaseMarker)
+
+	if _, err := os.Stat(marker); err == nil {
+		fmt.Fprintf(output, "Reusing the release of %s\n", utils.ShortRev(commit))
+
+		now := time.Now()
+		return commit, releaseDir, os.Chtimes(marker, now, now)
+	}
+
+	err = os.RemoveAll(releaseDir)
+	if err != nil {
+		return "", "", fmt.Errorf("error removing incomplete release: %w", err)
+	}
+
+	err = os.MkdirAll(p.ReleasesDir(), os.FileMode(0755))
+	if err != nil {
+		return "", "", err
+	}
+
+	// The release borrows the objects of the repository instead of copying them.
+	cloneCmd := exec.Command("git", "clone", "--quiet", "--shared", "--no-checkout", p.RepoDir, releaseDir)
+	cloneCmd.Stdout = output
+	cloneCmd.Stderr = output
+
+	err = cloneCmd.Run()
+	if err != nil {
+		return "", "", fmt.Errorf("error cloning git repository: %w", err)
+	}
+
+	err = checkoutCommand(p, releaseDir, output, "checkout", "--quiet", "--detach", commit).Run()
+	if err != nil {
+		return "", "", fmt.Errorf("error checking out %s: %w", utils.ShortRev(commit), err)
+	}
+
+	err = updateSubmodules(p, releaseDir, output)
+	if err != nil {
+		return "", "", err
+	}
+
+	err = pullLFS(p, releaseDir, output)
+	if err != nil {
+		return "", "", err
+	}
+
+	err = linkShared(p, releaseDir)
+	if err != nil {
+		return "", "", err
+	}
+
+	err = os.WriteFile(marker, []byte(commit+"\n"), os.FileMode(0644))
+	if err != nil {
+		return "", "", err
+	}
+
+	return commit, releaseDir, nil
+}
+
+// linkShared replaces the shared paths of a release with links to the shared directory of the
+// project. The first release that contains a shared path seeds the shared directory with it.
+func linkShared(p project.Project, releaseDir string) error {
+	config := p.Config()
+	composeDir := path.Join(releaseDir, config.Deploy.Directory)
+
+	err := os.MkdirAll(p.SharedDir(), os.FileMode(0755))
+	if err != nil {
+		return err
+	}
+
+	for _, shared := range config.Deploy.Shared {
+		shared = path.Clean(shared)
+		if path.IsAbs(shared) || shared == "." || shared == ".." || strings.HasPrefix(shared, "../") {
+			return fmt.Errorf("invalid shared path %s", shared)
+		}
+
+		target := path.Join(p.SharedDir(), shared)
+		link := path.Join(composeDir, shared)
+
+		if _, err := os.Lstat(target); os.IsNotExist(err) {
+			err := os.MkdirAll(path.Dir(target), os.FileMode(0755))
+			if err != nil {
+				return err
+			}
+
+			if _, err := os.Lstat(link); err == nil {
+				err = os.Rename(link, target)
+			} else {
+				err = os.Mkdir(target, os.FileMode(0755))
+			}
+
+			if err != nil {
+				return fmt.Errorf("error creating shared path %s: %w", shared, err)
+			}
+		}
+
+		err := os.RemoveAll(link)
+		if err != nil {
+			return err
+		}
+
+		err = os.MkdirAll(path.Dir(link), os.FileMode(0755))
+		if err != nil {
+			return err
+		}
+
+		// Relative links keep working if the data directory moves.
+		relTarget, err := filepath.Rel(path.Dir(link), target)
+		if err != nil {
+			return err
+		}
+
+		err = os.Symlink(relTarget, link)
+		if err != nil {
+			return fmt.Errorf("error linking shared path %s: %w", shared, err)
+		}
+	}
+
+	return nil
+}
+
+// activate points the deployment directory of the project to the release of a commit.
+// The link is replaced atomically, so it always points to a complete release.
+func activate(p project.Project, commit string) error {
+	link := p.DeploymentDir()
+	tmpLink := link + ".tmp"
+
+	_ = os.Remove(tmpLink)
+
+	err := os.Symlink(path.Join(path.Base(p.ReleasesDir()), commit), tmpLink)
+	if err != nil {
+		return fmt.Errorf("error linking release: %w", err)
+	}
+
+	err = os.Rename(tmpLink, link)
+	if err != nil {
+		return fmt.Errorf("error activating release: %w", err)
+	}
+
+	return nil
+}
+
+// pruneReleases removes the least recently deployed releases of a project, keeping the number
+// of releases set by deploy-keep-releases. The current release is never removed.
+func pruneReleases(p project.Project) {
+	keep := viper.GetInt("deploy-keep-releases")
+	if keep <= 0 {
+		return
+	}
+
+	entries, err := os.ReadDir(p.ReleasesDir())
+	if err != nil {
+		log.Println("Error reading releases:", err)
+		return
+	}
+
+	current, _ := CurrentRevision(p)
+
+	type release struct {
+		commit   string
+		deployed time.Time
+	}
+
+	var releases []release
+
+	for _, entry := range entries {
+		if !entry.IsDir() || entry.Name() == current {
+			continue
+		}
+
+		// Incomplete releases sort first and are removed before complete ones.
+		var deployed time.Time
+		if info, err := os.Stat(path.Join(p.ReleasesDir(), entry.Name(), ".git", releaseMarker)); err == nil {
+			deployed = info.ModTime()
+		}
+
+		releases = append(releases, release{commit: entry.Name(), deployed: deployed})
+	}
+
+	sort.Slice(releases, func(i, j int) bool {
+		return releases[i].deployed.Before(releases[j].deployed)
+	})
+
+	// The current release counts towards the releases to keep.
+	remove := len(releases) - keep
+	if current != "" {
+		remove++
+	}
+
+	for i := 0; i < remove && i < len(releases); i++ {
+		err := os.RemoveAll(path.Join(p.ReleasesDir(), releases[i].commit))
+		if err != nil {
+			log.Println("Error removing release:", err)
+		}
+	}
+}
+
+// CurrentRevision returns the commit of the release the deployment directory points to.
+func CurrentRevision(p project.Project) (string, error) {
+	target, err := os.Readlink(p.DeploymentDir())
+	if err != nil {
+		return "", fmt.Errorf("error getting current revision: %w", err)
+	}
+
+	return path.Base(target), nil
+}
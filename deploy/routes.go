@@ -0,0 +1,249 @@
+package deploy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+
+	"github.com/antoniomika/pcompose/frontend"
+	"github.com/antoniomika/pcompose/project"
+	"github.com/spf13/viper"
+)
+
+// defaultComposeFiles are the compose files docker-compose looks for in order if none are configured.
+var defaultComposeFiles = []string{"docker-compose.yml", "docker-compose.yaml", "compose.yml", "compose.yaml"}
+
+// defaultOverrideFiles are the override files docker-compose applies if no compose files are configured.
+var defaultOverrideFiles = []string{"docker-compose.override.yml", "docker-compose.override.yaml"}
+
+// ComposeFiles returns the compose files of the current release of a project, which are empty
+// if docker-compose finds them on its own.
+func ComposeFiles(p project.Project) []string {
+	return composeFiles(p, p.ComposeDir())
+}
+
+// composeFiles returns the compose files of a project in a compose directory. The override with the
+// routes of the project is added to them, which requires naming the files docker-compose would find.
+func composeFiles(p project.Project, composeDir string) []string {
+	files := p.Config().Deploy.ComposeFiles
+
+	if _, err := os.Stat(path.Join(composeDir, frontend.OverrideFile)); err != nil {
+		return files
+	}
+
+	if len(files) == 0 {
+		files = baseComposeFiles(composeDir)
+	}
+
+	return append(append([]string{}, files...), frontend.OverrideFile)
+}
+
+// baseComposeFiles returns the compose file and override files docker-compose finds in a compose directory.
+func baseComposeFiles(composeDir string) []string {
+	var files []string
+
+	for _, file := range defaultComposeFiles {
+		if _, err := os.Stat(path.Join(composeDir, file)); err == nil {
+			files = append(files, file)
+			break
+		}
+	}
+
+	for _, file := range defaultOverrideFiles {
+		if _, err := os.Stat(path.Join(composeDir, file)); err == nil {
+			files = append(files, file)
+			break
+		}
+	}
+
+	return files
+}
+
+// readComposeFiles reads the compose files of a project in a compose directory, without the override
+// pcompose generates. Files that can't be read are skipped.
+func readComposeFiles(p project.Project, composeDir string) []*viper.Viper {
+	files := p.Config().Deploy.ComposeFiles
+	if len(files) == 0 {
+		files = baseComposeFiles(composeDir)
+	}
+
+	var configs []*viper.Viper
+
+	for _, file := range files {
+		config := viper.New()
+		config.SetConfigFile(path.Join(composeDir, file))
+		config.SetConfigType("yaml")
+
+		err := config.ReadInConfig()
+		if err != nil {
+			continue
+		}
+
+		configs = append(configs, config)
+	}
+
+	return configs
+}
+
+// writeOverride writes the override file applying the routes of a project to its services into a
+// compose directory, or removes it if there are no routes. networks maps the public networks of
+// the project to their docker names.
+func writeOverride(p project.Project, composeDir string, networks map[string]string, routes []frontend.Route) error {
+	overrideFile := path.Join(composeDir, frontend.OverrideFile)
+
+	if len(routes) == 0 {
+		err := os.Remove(overrideFile)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		return nil
+	}
+
+	f, err := frontend.Get()
+	if err != nil {
+		return err
+	}
+
+	composeConfigs := readComposeFiles(p, composeDir)
+
+	serviceRoutes := map[string][]frontend.Route{}
+	for _, route := range routes {
+		serviceRoutes[route.Service] = append(serviceRoutes[route.Service], route)
+	}
+
+	services := map[string]interface{}{}
+
+	for service, routes := range serviceRoutes {
+		environment, labels := f.Service(p, service, networks[routes[0].Network], routes)
+
+		// The frontends are connected to the public networks, so routed services have to be as well.
+		serviceNetworks := map[string]interface{}{}
+		for _, route := range routes {
+			serviceNetworks[route.Network] = map[string]interface{}{
+				"aliases": []string{frontend.Alias(p, service)},
+			}
+		}
+
+		// Services without networks are only connected to the default network, which they would leave
+		// once the override names other networks.
+		if _, ok := serviceNetworks["default"]; !ok && !hasNetworks(composeConfigs, service) {
+			serviceNetworks["default"] = map[string]interface{}{}
+		}
+
+		override := map[string]interface{}{
+			"networks": serviceNetworks,
+		}
+
+		if len(environment) > 0 {
+			override["environment"] = environment
+		}
+
+		if len(labels) > 0 {
+			override["labels"] = labels
+		}
+
+		services[service] = override
+	}
+
+	compose := map[string]interface{}{
+		"services": services,
+	}
+
+	// docker-compose requires the version of override files to match the version of the compose files.
+	if len(composeConfigs) > 0 && composeConfigs[0].GetString("version") != "" {
+		compose["version"] = composeConfigs[0].GetString("version")
+	}
+
+	// JSON is valid YAML, so docker-compose reads it like any other compose file.
+	data, err := json.MarshalIndent(compose, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(overrideFile, data, os.FileMode(0644))
+}
+
+// hasNetworks returns whether a service declares networks in one of the compose files.
+func hasNetworks(composeConfigs []*viper.Viper, service string) bool {
+	for _, composeConfig := range composeConfigs {
+		if composeConfig.IsSet(fmt.Sprintf("services.%s.networks", service)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// writeRoutes reads the routes of a release and writes the compose override applying them. Routes
+// without a network use the first public network of the project.
+func writeRoutes(p project.Project, composeDir string, output io.Writer) ([]frontend.Route, error) {
+	routes, err := frontend.Routes(composeDir)
+	if err != nil {
+		return nil, err
+	}
+
+	networks := map[string]string{}
+
+	publicNetworks := PublicNetworks(p, composeDir)
+	for _, network := range publicNetworks {
+		networks[network.Name] = network.DockerName
+	}
+
+	for i, route := range routes {
+		if route.Network == "" {
+			routes[i].Network = publicNetworks[0].Name
+		} else if _, ok := networks[route.Network]; !ok {
+			return nil, fmt.Errorf("network %s of route %d of %s is not a public network", route.Network, i+1, frontend.ConfigFile)
+		}
+	}
+
+	if len(routes) > 0 {
+		fmt.Fprintf(output, "Routing %d routes using %s\n", len(routes), viper.GetString("frontend"))
+	}
+
+	err = writeOverride(p, composeDir, networks, routes)
+	if err != nil {
+		return nil, fmt.Errorf("error writing the routes override: %w", err)
+	}
+
+	return routes, nil
+}
+
+// applyRoutes configures the routes of a project on the frontend once its containers started. Without
+// routes, routes of previous deploys are removed, but errors don't fail the deploy.
+func applyRoutes(p project.Project, routes []frontend.Route) error {
+	f, err := frontend.Get()
+	if err != nil {
+		return err
+	}
+
+	if len(routes) == 0 {
+		err := f.Remove(p.ComposeProject())
+		if err != nil {
+			log.Println("Error removing routes:", err)
+		}
+
+		return nil
+	}
+
+	err = f.Apply(p, routes)
+	if err != nil {
+		return fmt.Errorf("error configuring routes: %w", err)
+	}
+
+	return nil
+}
+
+// RemoveRoutes removes the routes of a compose project from the frontend.
+func RemoveRoutes(composeProject string) error {
+	f, err := frontend.Get()
+	if err != nil {
+		return err
+	}
+
+	return f.Remove(composeProject)
+}
@@ -0,0 +1,245 @@
+package frontend
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/antoniomika/pcompose/project"
+)
+
+// caddy is Caddy, whose routes are configured using its admin API.
+type caddy struct {
+	adminURL string
+	server   string
+}
+
+// caddyRoute is a route in the JSON config of Caddy.
+type caddyRoute struct {
+	ID       string                   `json:"@id"`
+	Match    []map[string][]string    `json:"match"`
+	Handle   []map[string]interface{} `json:"handle"`
+	Terminal bool                     `json:"terminal"`
+}
+
+// Service returns nothing, Caddy reaches services using their network alias.
+func (caddy) Service(p project.Project, service string, network string, routes []Route) (map[string]string, map[string]string) {
+	return nil, nil
+}
+
+// Apply replaces the routes of a project in the server of Caddy. They are placed before
+// the other routes of the server, with longer paths first. Hooks and the server configure Caddy
+// concurrently, so routes are changed one at a time by their @id rather than as a whole: the new
+// routes are inserted in front of the old ones, which are removed afterwards, so the project stays
+// reachable while its routes are replaced.
+func (c caddy) Apply(p project.Project, routes []Route) error {
+	existing, exists, err := c.routes()
+	if err != nil {
+		return err
+	}
+
+	old := c.projectRouteIDs(existing, p.ComposeProject())
+
+	sorted := append([]Route{}, routes...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return len(sorted[i].Path) > len(sorted[j].Path)
+	})
+
+	// Every generation of routes gets new IDs, since they exist next to the old ones for a moment.
+	generation := strconv.FormatInt(time.Now().UnixNano(), 36)
+
+	var updated []caddyRoute
+
+	for i, route := range sorted {
+		match := map[string][]string{
+			"host": {route.Host},
+		}
+
+		if route.Path != "" && route.Path != "/" {
+			match["path"] = []string{strings.TrimSuffix(route.Path, "/"), strings.TrimSuffix(route.Path, "/") + "/*"}
+		}
+
+		updated = append(updated, caddyRoute{
+			ID:    fmt.Sprintf("%s%s-%d", caddyRoutePrefix(p.ComposeProject()), generation, i),
+			Match: []map[string][]string{match},
+			Handle: []map[string]interface{}{
+				{
+					"handler":   "reverse_proxy",
+					"upstreams": []map[string]string{{"dial": fmt.Sprintf("%s:%d", Alias(p, route.Service), route.Port)}},
+				},
+			},
+			Terminal: true,
+		})
+	}
+
+	err = c.insert(updated, exists)
+	if err != nil {
+		return err
+	}
+
+	return c.delete(old)
+}
+
+// Remove removes the routes of a compose project from the server of Caddy.
+func (c caddy) Remove(composeProject string) error {
+	existing, _, err := c.routes()
+	if err != nil {
+		return err
+	}
+
+	return c.delete(c.projectRouteIDs(existing, composeProject))
+}
+
+// insert adds routes in order in front of the routes of the server of Caddy. A server without
+// routes gets them as a whole, unless another process created its routes in the meantime.
+func (c caddy) insert(routes []caddyRoute, exists bool) error {
+	if len(routes) == 0 {
+		return nil
+	}
+
+	if !exists {
+		err := c.do(http.MethodPut, c.routesPath(), routes, nil)
+		if err == nil {
+			return nil
+		}
+
+		_, exists, routesErr := c.routes()
+		if routesErr != nil || !exists {
+			return err
+		}
+	}
+
+	// PUT at an index inserts before the route at that index, so the last route goes first.
+	for i := len(routes) - 1; i >= 0; i-- {
+		err := c.do(http.MethodPut, c.routesPath()+"/0", routes[i], nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// delete removes routes by their @id. Routes that were removed in the meantime are skipped.
+func (c caddy) delete(ids []string) error {
+	for _, id := range ids {
+		err := c.do(http.MethodDelete, "/id/"+url.PathEscape(id), nil, nil)
+		if err != nil && err != errCaddyNotFound {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// routes returns the routes of the server of Caddy and whether it has any.
+func (c caddy) routes() ([]json.RawMessage, bool, error) {
+	var routes []json.RawMessage
+
+	err := c.do(http.MethodGet, c.routesPath(), nil, &routes)
+	if err == errCaddyNotFound {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+
+	return routes, routes != nil, nil
+}
+
+// projectRouteIDs returns the @ids of the routes that belong to a compose project.
+func (c caddy) projectRouteIDs(routes []json.RawMessage, composeProject string) []string {
+	var ids []string
+
+	for _, route := range routes {
+		var id struct {
+			ID string `json:"@id"`
+		}
+
+		_ = json.Unmarshal(route, &id)
+
+		if strings.HasPrefix(id.ID, caddyRoutePrefix(composeProject)) {
+			ids = append(ids, id.ID)
+		}
+	}
+
+	return ids
+}
+
+// routesPath returns the path of the routes of the server in the config of Caddy.
+func (c caddy) routesPath() string {
+	return fmt.Sprintf("/config/apps/http/servers/%s/routes", c.server)
+}
+
+// errCaddyNotFound is returned when a path or @id doesn't exist in the config of Caddy.
+var errCaddyNotFound = errors.New("routes not found")
+
+// do sends a request for a path of the admin API of Caddy, encoding body and decoding the
+// response into result if they are set.
+func (c caddy) do(method string, requestPath string, body interface{}, result interface{}) error {
+	var reqBody io.Reader
+
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+
+		reqBody = bytes.NewReader(data)
+	}
+
+	requestURL := c.adminURL + requestPath
+
+	req, err := http.NewRequest(method, requestURL, reqBody)
+	if err != nil {
+		return err
+	}
+
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	client := &http.Client{
+		Timeout: 10 * time.Second,
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error reaching the Caddy admin API: %w", err)
+	}
+
+	defer resp.Body.Close()
+
+	message, err := io.ReadAll(io.LimitReader(resp.Body, 10<<20))
+	if err != nil {
+		return err
+	}
+
+	// Caddy responds with an error if a path of the config doesn't exist.
+	if resp.StatusCode == http.StatusNotFound || (resp.StatusCode == http.StatusBadRequest && strings.Contains(string(message), "invalid traversal path")) {
+		return errCaddyNotFound
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("Caddy admin API request %s %s failed with %s: %s", method, requestURL, resp.Status, strings.TrimSpace(string(message)))
+	}
+
+	if result != nil {
+		return json.Unmarshal(message, result)
+	}
+
+	return nil
+}
+
+// caddyRoutePrefix returns the prefix of the IDs of the routes of a compose project. The deployment
+// separator can't be part of compose project names, so prefixes of different projects don't overlap.
+func caddyRoutePrefix(composeProject string) string {
+	return fmt.Sprintf("pcompose%s%s%s", project.DeploymentSeparator, composeProject, project.DeploymentSeparator)
+}
@@ -0,0 +1,197 @@
+package frontend
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/antoniomika/pcompose/project"
+)
+
+// fakeCaddy is the admin API of Caddy for the routes of a single server. Each request is applied
+// atomically like Caddy does, but nothing serializes the requests of different clients.
+type fakeCaddy struct {
+	mu     sync.Mutex
+	routes []map[string]interface{}
+}
+
+// ServeHTTP handles reading and creating the routes, inserting a route at an index and deleting by @id.
+func (f *fakeCaddy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	const routesPath = "/config/apps/http/servers/srv0/routes"
+
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == routesPath:
+		_ = json.NewEncoder(w).Encode(f.routes)
+	case r.Method == http.MethodPut && r.URL.Path == routesPath:
+		if f.routes != nil {
+			http.Error(w, `{"error":"key already exists: routes"}`, http.StatusConflict)
+			return
+		}
+
+		_ = json.NewDecoder(r.Body).Decode(&f.routes)
+	case r.Method == http.MethodPut && r.URL.Path == routesPath+"/0":
+		if f.routes == nil {
+			http.Error(w, `{"error":"invalid traversal path"}`, http.StatusBadRequest)
+			return
+		}
+
+		var route map[string]interface{}
+		_ = json.NewDecoder(r.Body).Decode(&route)
+
+		f.routes = append([]map[string]interface{}{route}, f.routes...)
+	case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, "/id/"):
+		for i, route := range f.routes {
+			if route["@id"] == strings.TrimPrefix(r.URL.Path, "/id/") {
+				f.routes = append(f.routes[:i], f.routes[i+1:]...)
+				return
+			}
+		}
+
+		http.Error(w, `{"error":"unknown object ID"}`, http.StatusNotFound)
+	default:
+		http.Error(w, "unexpected request", http.StatusMethodNotAllowed)
+	}
+}
+
+// summary returns the owner and host and path of every route in order, e.g. alice_app example.com/api.
+func (f *fakeCaddy) summary() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var routes []string
+
+	for _, route := range f.routes {
+		id, _ := route["@id"].(string)
+		owner := "other"
+
+		if strings.HasPrefix(id, "pcompose:") {
+			owner = strings.Split(id, ":")[1]
+		}
+
+		match := route["match"].([]interface{})[0].(map[string]interface{})
+		host := match["host"].([]interface{})[0].(string)
+
+		if paths, ok := match["path"].([]interface{}); ok {
+			host += paths[0].(string)
+		}
+
+		routes = append(routes, owner+" "+host)
+	}
+
+	return routes
+}
+
+// newFakeCaddy starts a fake Caddy whose server already has a route that pcompose didn't add.
+func newFakeCaddy(t *testing.T) (caddy, *fakeCaddy) {
+	t.Helper()
+
+	fake := &fakeCaddy{
+		routes: []map[string]interface{}{
+			{"match": []interface{}{map[string]interface{}{"host": []interface{}{"static.example.com"}}}},
+		},
+	}
+
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	return caddy{adminURL: server.URL, server: "srv0"}, fake
+}
+
+func TestCaddyApply(t *testing.T) {
+	c, fake := newFakeCaddy(t)
+	p := project.New("alice/app")
+
+	err := c.Apply(p, []Route{
+		{Host: "app.example.com", Service: "web", Port: 80},
+		{Host: "app.example.com", Path: "/api/", Service: "api", Port: 8080},
+	})
+	if err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+
+	want := "alice_app app.example.com/api,alice_app app.example.com,other static.example.com"
+	if got := strings.Join(fake.summary(), ","); got != want {
+		t.Errorf("routes after Apply() = %s, want %s", got, want)
+	}
+
+	// Applying again replaces the routes of the project.
+	err = c.Apply(p, []Route{{Host: "www.example.com", Service: "web", Port: 80}})
+	if err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+
+	want = "alice_app www.example.com,other static.example.com"
+	if got := strings.Join(fake.summary(), ","); got != want {
+		t.Errorf("routes after replacing them = %s, want %s", got, want)
+	}
+
+	err = c.Remove(p.ComposeProject())
+	if err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+
+	want = "other static.example.com"
+	if got := strings.Join(fake.summary(), ","); got != want {
+		t.Errorf("routes after Remove() = %s, want %s", got, want)
+	}
+}
+
+func TestCaddyApplyWithoutRoutes(t *testing.T) {
+	c, fake := newFakeCaddy(t)
+	fake.routes = nil
+
+	err := c.Apply(project.New("alice/app"), []Route{{Host: "app.example.com", Service: "web", Port: 80}})
+	if err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+
+	if got := strings.Join(fake.summary(), ","); got != "alice_app app.example.com" {
+		t.Errorf("routes after Apply() = %s, want alice_app app.example.com", got)
+	}
+}
+
+func TestCaddyApplyConcurrently(t *testing.T) {
+	c, fake := newFakeCaddy(t)
+
+	// Hooks of different projects deploy at the same time, each in a process of its own.
+	var wg sync.WaitGroup
+
+	for _, name := range []string{"alice/app", "bob/app", "carol/app"} {
+		wg.Add(1)
+
+		go func(p project.Project) {
+			defer wg.Done()
+
+			for i := 0; i < 20; i++ {
+				err := c.Apply(p, []Route{{Host: p.ComposeProject() + ".example.com", Service: "web", Port: 80}})
+				if err != nil {
+					t.Errorf("Apply() error = %v", err)
+					return
+				}
+			}
+		}(project.New(name))
+	}
+
+	wg.Wait()
+
+	got := map[string]int{}
+	for _, route := range fake.summary() {
+		got[route]++
+	}
+
+	for _, route := range []string{"alice_app alice_app.example.com", "bob_app bob_app.example.com", "carol_app carol_app.example.com", "other static.example.com"} {
+		if got[route] != 1 {
+			t.Errorf("route %s exists %d times, want once in %v", route, got[route], fake.summary())
+		}
+	}
+
+	if len(got) != 4 {
+		t.Errorf("routes = %v, want one route per project and the other route", fake.summary())
+	}
+}
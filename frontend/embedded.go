@@ -0,0 +1,75 @@
+package frontend
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/antoniomika/pcompose/project"
+)
+
+// RouteLabel prefixes the container labels the embedded proxy discovers routes from, e.g.
+// pcompose.route.web.host. Routes from the config file are turned into these labels as well.
+const RouteLabel = "pcompose.route"
+
+var (
+	// changeHooks are called when the routes of a project changed.
+	changeHooks   []func()
+	changeHooksMu sync.Mutex
+)
+
+// OnChange registers a function called when the routes of a project changed, e.g. after a deploy.
+func OnChange(hook func()) {
+	changeHooksMu.Lock()
+	defer changeHooksMu.Unlock()
+
+	changeHooks = append(changeHooks, hook)
+}
+
+// changed calls the functions registered with OnChange.
+func changed() {
+	changeHooksMu.Lock()
+	defer changeHooksMu.Unlock()
+
+	for _, hook := range changeHooks {
+		hook()
+	}
+}
+
+// embedded is the reverse proxy built into pcompose, which discovers routes from container labels.
+type embedded struct{}
+
+// Service returns the route labels of the embedded proxy for the routes of a service.
+func (embedded) Service(p project.Project, service string, network string, routes []Route) (map[string]string, map[string]string) {
+	labels := map[string]string{}
+
+	for i, route := range routes {
+		prefix := fmt.Sprintf("%s.%d", RouteLabel, i)
+
+		labels[prefix+".host"] = route.Host
+		labels[prefix+".port"] = strconv.Itoa(route.Port)
+		labels[prefix+".network"] = network
+
+		if route.Path != "" {
+			labels[prefix+".path"] = route.Path
+		}
+
+		if route.TLS {
+			labels[prefix+".tls"] = "true"
+		}
+	}
+
+	return nil, labels
+}
+
+// Apply makes the embedded proxy pick up the containers of a project right away.
+func (embedded) Apply(p project.Project, routes []Route) error {
+	changed()
+	return nil
+}
+
+// Remove makes the embedded proxy drop the routes of stopped containers right away.
+func (embedded) Remove(composeProject string) error {
+	changed()
+	return nil
+}
@@ -0,0 +1,158 @@
+// Package frontend implements configuring the reverse proxy that routes requests to projects used by pcompose
+package frontend
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/antoniomika/pcompose/project"
+	"github.com/spf13/viper"
+)
+
+const (
+	// KindNginxProxy routes requests using nginx-proxy, configured using environment variables.
+	KindNginxProxy = "nginx-proxy"
+
+	// KindTraefik routes requests using Traefik, configured using container labels.
+	KindTraefik = "traefik"
+
+	// KindCaddy routes requests using Caddy, configured using its admin API.
+	KindCaddy = "caddy"
+
+	// KindEmbedded routes requests using the reverse proxy built into pcompose.
+	KindEmbedded = "pcompose"
+)
+
+// ConfigFile is the file in the deployed directory of a repository that configures its routes.
+const ConfigFile = ".pcompose.yml"
+
+// OverrideFile is the compose file pcompose generates next to the compose files of a release
+// to apply the routes of the project to its services.
+const OverrideFile = "docker-compose.pcompose.yml"
+
+// Route routes requests for a host, and optionally a path prefix, to a port of a service.
+type Route struct {
+	// Host is the host name requests are routed for, e.g. http.example.com.
+	Host string `mapstructure:"host"`
+
+	// Service is the compose service requests are routed to.
+	Service string `mapstructure:"service"`
+
+	// Port is the port the service listens on. Defaults to 80.
+	Port int `mapstructure:"port"`
+
+	// Path is a path prefix requests have to match, e.g. /api. Every path matches if empty.
+	Path string `mapstructure:"path"`
+
+	// TLS serves the host over HTTPS using a certificate from Let's Encrypt.
+	TLS bool `mapstructure:"tls"`
+
+	// Network is the public network of the project the frontend reaches the service on.
+	// Defaults to the first public network.
+	Network string `mapstructure:"network"`
+}
+
+// Frontend is a reverse proxy that routes requests to the services of projects.
+type Frontend interface {
+	// Service returns the environment variables and labels a service needs for its routes. network
+	// is the docker network the frontend reaches the service on.
+	Service(p project.Project, service string, network string, routes []Route) (environment map[string]string, labels map[string]string)
+
+	// Apply configures the routes of a project once its containers started, replacing its previous routes.
+	Apply(p project.Project, routes []Route) error
+
+	// Remove removes the routes of a compose project once it was taken down or deleted.
+	Remove(composeProject string) error
+}
+
+// Get returns the frontend configured using the frontend flag.
+func Get() (Frontend, error) {
+	switch kind := viper.GetString("frontend"); kind {
+	case "", KindNginxProxy:
+		return nginxProxy{}, nil
+	case KindTraefik:
+		return traefik{
+			entrypoints:  viper.GetString("frontend-traefik-entrypoints"),
+			certResolver: viper.GetString("frontend-traefik-certresolver"),
+		}, nil
+	case KindCaddy:
+		return caddy{
+			adminURL: strings.TrimSuffix(viper.GetString("frontend-caddy-admin-url"), "/"),
+			server:   viper.GetString("frontend-caddy-server"),
+		}, nil
+	case KindEmbedded:
+		return embedded{}, nil
+	default:
+		return nil, fmt.Errorf("unknown frontend %q", kind)
+	}
+}
+
+// Routes reads the routes from the config file in a directory. A missing config file has no routes.
+func Routes(dir string) ([]Route, error) {
+	configFile := path.Join(dir, ConfigFile)
+
+	if _, err := os.Stat(configFile); os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	config := viper.New()
+	config.SetConfigFile(configFile)
+	config.SetConfigType("yaml")
+
+	err := config.ReadInConfig()
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", ConfigFile, err)
+	}
+
+	var routes []Route
+
+	err = config.UnmarshalKey("routes", &routes)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing routes of %s: %w", ConfigFile, err)
+	}
+
+	for i, route := range routes {
+		if route.Host == "" || route.Service == "" {
+			return nil, fmt.Errorf("route %d of %s needs a host and a service", i+1, ConfigFile)
+		}
+
+		if route.Path != "" && !strings.HasPrefix(route.Path, "/") {
+			return nil, fmt.Errorf("path %q of route %d of %s has to start with /", route.Path, i+1, ConfigFile)
+		}
+
+		if route.Port == 0 {
+			routes[i].Port = 80
+		}
+	}
+
+	return routes, nil
+}
+
+// Alias returns the network alias of a service, which is unique across projects since the
+// frontends are connected to the networks of every project.
+func Alias(p project.Project, service string) string {
+	return fmt.Sprintf("%s.%s", service, p.ComposeProject())
+}
+
+// routeHosts returns the sorted hosts of routes without duplicates, only those using TLS if tlsOnly is set.
+func routeHosts(routes []Route, tlsOnly bool) []string {
+	seen := map[string]bool{}
+
+	var hosts []string
+
+	for _, route := range routes {
+		if seen[route.Host] || (tlsOnly && !route.TLS) {
+			continue
+		}
+
+		seen[route.Host] = true
+		hosts = append(hosts, route.Host)
+	}
+
+	sort.Strings(hosts)
+
+	return hosts
+}
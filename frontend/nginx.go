@@ -0,0 +1,41 @@
+package frontend
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/antoniomika/pcompose/project"
+)
+
+// nginxProxy is nginx-proxy, which routes requests to containers based on their environment variables.
+// TLS certificates are requested by its acme-companion.
+type nginxProxy struct{}
+
+// Service returns the VIRTUAL_HOST environment variables of nginx-proxy for the routes of a service.
+// nginx-proxy supports a single port and path per container, so those of the first route are used.
+func (nginxProxy) Service(p project.Project, service string, network string, routes []Route) (map[string]string, map[string]string) {
+	environment := map[string]string{
+		"VIRTUAL_HOST": strings.Join(routeHosts(routes, false), ","),
+		"VIRTUAL_PORT": strconv.Itoa(routes[0].Port),
+	}
+
+	if routes[0].Path != "" {
+		environment["VIRTUAL_PATH"] = routes[0].Path
+	}
+
+	if hosts := routeHosts(routes, true); len(hosts) > 0 {
+		environment["LETSENCRYPT_HOST"] = strings.Join(hosts, ",")
+	}
+
+	return environment, nil
+}
+
+// Apply does nothing, nginx-proxy picks up containers on its own.
+func (nginxProxy) Apply(p project.Project, routes []Route) error {
+	return nil
+}
+
+// Remove does nothing, nginx-proxy removes routes of stopped containers on its own.
+func (nginxProxy) Remove(composeProject string) error {
+	return nil
+}
@@ -0,0 +1,65 @@
+package frontend
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/antoniomika/pcompose/project"
+)
+
+// traefik is Traefik with its Docker provider, which routes requests to containers based on their labels.
+type traefik struct {
+	entrypoints  string
+	certResolver string
+}
+
+// Service returns the Traefik labels for the routes of a service, with a router per route. Traefik
+// reaches a container on a single network, so routes of a service should share their network.
+func (t traefik) Service(p project.Project, service string, network string, routes []Route) (map[string]string, map[string]string) {
+	// Router and service names are global in Traefik, so they are prefixed with the compose project.
+	name := fmt.Sprintf("%s-%s", p.ComposeProject(), service)
+
+	labels := map[string]string{
+		"traefik.enable": "true",
+		// Traefik has to reach the service on a network it is connected to.
+		"traefik.docker.network": network,
+	}
+
+	for i, route := range routes {
+		router := fmt.Sprintf("traefik.http.routers.%s-%d", name, i)
+		serviceName := fmt.Sprintf("%s-%d", name, route.Port)
+
+		rule := fmt.Sprintf("Host(`%s`)", route.Host)
+		if route.Path != "" {
+			rule += fmt.Sprintf(" && PathPrefix(`%s`)", route.Path)
+		}
+
+		labels[router+".rule"] = rule
+		labels[router+".service"] = serviceName
+		labels[fmt.Sprintf("traefik.http.services.%s.loadbalancer.server.port", serviceName)] = strconv.Itoa(route.Port)
+
+		if t.entrypoints != "" {
+			labels[router+".entrypoints"] = t.entrypoints
+		}
+
+		if route.TLS {
+			labels[router+".tls"] = "true"
+
+			if t.certResolver != "" {
+				labels[router+".tls.certresolver"] = t.certResolver
+			}
+		}
+	}
+
+	return nil, labels
+}
+
+// Apply does nothing, Traefik picks up containers on its own.
+func (traefik) Apply(p project.Project, routes []Route) error {
+	return nil
+}
+
+// Remove does nothing, Traefik removes routes of stopped containers on its own.
+func (traefik) Remove(composeProject string) error {
+	return nil
+}
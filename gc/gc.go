@@ -0,0 +1,331 @@
+// Package gc implements removing unused images, build cache and stale projects used by pcompose
+package gc
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/antoniomika/pcompose/deploy"
+	"github.com/antoniomika/pcompose/project"
+	"github.com/spf13/viper"
+)
+
+// StaleProject is a compose project that was deployed by pcompose from the data directory
+// but whose repository or deployment doesn't exist anymore.
+type StaleProject struct {
+	ComposeProject string
+	WorkingDir     string
+	Containers     []string
+	Networks       []string
+}
+
+// Start runs a garbage collection every gc-interval. It doesn't return if the interval is set.
+func Start() {
+	interval := viper.GetDuration("gc-interval")
+	if interval <= 0 {
+		return
+	}
+
+	for {
+		err := Run(log.Writer(), false)
+		if err != nil {
+			log.Println("Error collecting garbage:", err)
+		}
+
+		time.Sleep(interval)
+	}
+}
+
+// Run removes stale projects if gc-remove-stale-projects is set, otherwise reports them, removes
+// the networks of deleted projects and images no recent deploy uses and prunes the build cache
+// beyond gc-build-cache-size. If dryRun is set, only what would be removed is printed.
+func Run(output io.Writer, dryRun bool) error {
+	projects, err := project.List()
+	if err != nil {
+		return fmt.Errorf("error listing projects: %w", err)
+	}
+
+	staleImages, err := collectStaleProjects(projects, output, dryRun)
+	if err != nil {
+		return err
+	}
+
+	if !dryRun {
+		deploy.ReconcileNetworks()
+	}
+
+	err = collectImages(projects, staleImages, output, dryRun)
+	if err != nil {
+		return err
+	}
+
+	return collectBuildCache(output, dryRun)
+}
+
+// collectStaleProjects reports or removes the containers and networks of stale projects and
+// returns the images of the removed containers, which are unused now.
+func collectStaleProjects(projects []project.Project, output io.Writer, dryRun bool) ([]string, error) {
+	stale, err := StaleProjects(projects)
+	if err != nil {
+		return nil, err
+	}
+
+	remove := viper.GetBool("gc-remove-stale-projects") && !dryRun
+
+	var images []string
+
+	for _, s := range stale {
+		if !remove {
+			fmt.Fprintf(output, "Stale project %s from %s has %d containers and %d networks\n", s.ComposeProject, s.WorkingDir, len(s.Containers), len(s.Networks))
+			continue
+		}
+
+		fmt.Fprintf(output, "Removing stale project %s from %s\n", s.ComposeProject, s.WorkingDir)
+
+		projectImages, err := deploy.ContainerImages(s.Containers)
+		if err != nil {
+			log.Println("Error listing images of stale project:", err)
+		}
+
+		rmOutput, err := exec.Command("docker", append([]string{"rm", "--force"}, s.Containers...)...).CombinedOutput()
+		if err != nil {
+			fmt.Fprintf(output, "Error removing containers of %s: %s\n", s.ComposeProject, strings.TrimSpace(string(rmOutput)))
+			continue
+		}
+
+		images = append(images, projectImages...)
+
+		for _, network := range s.Networks {
+			err := deploy.RemoveNetwork(network)
+			if err != nil {
+				fmt.Fprintln(output, err)
+			}
+		}
+	}
+
+	return images, nil
+}
+
+// StaleProjects returns the compose projects with containers created from a directory inside
+// the data directory that don't belong to one of projects. Volumes are never considered stale.
+func StaleProjects(projects []project.Project) ([]StaleProject, error) {
+	known := map[string]bool{}
+	for _, p := range projects {
+		known[p.ComposeProject()] = true
+	}
+
+	dataDir, err := filepath.Abs(project.DataDir())
+	if err != nil {
+		return nil, err
+	}
+
+	psOutput, err := exec.Command("docker", "ps", "-a", "--no-trunc", "--filter", "label=com.docker.compose.project", "--format", `{{.ID}}\t{{.Label "com.docker.compose.project"}}\t{{.Label "com.docker.compose.project.working_dir"}}`).Output()
+	if err != nil {
+		return nil, fmt.Errorf("error listing containers: %w", err)
+	}
+
+	staleProjects := map[string]*StaleProject{}
+
+	for _, line := range strings.Split(strings.TrimSpace(string(psOutput)), "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) != 3 || known[fields[1]] {
+			continue
+		}
+
+		// Only projects pcompose deployed are considered, not other compose projects on the host.
+		if !strings.HasPrefix(filepath.Clean(fields[2]), dataDir+string(filepath.Separator)) {
+			continue
+		}
+
+		s, ok := staleProjects[fields[1]]
+		if !ok {
+			s = &StaleProject{
+				ComposeProject: fields[1],
+				WorkingDir:     fields[2],
+			}
+
+			staleProjects[fields[1]] = s
+		}
+
+		s.Containers = append(s.Containers, fields[0])
+	}
+
+	var stale []StaleProject
+
+	for _, s := range staleProjects {
+		s.Networks, err = projectNetworks(s.ComposeProject)
+		if err != nil {
+			return nil, err
+		}
+
+		stale = append(stale, *s)
+	}
+
+	sort.Slice(stale, func(i, j int) bool {
+		return stale[i].ComposeProject < stale[j].ComposeProject
+	})
+
+	return stale, nil
+}
+
+// projectNetworks returns the networks docker-compose created for a compose project and
+// its default network, which pcompose creates to connect the frontend.
+func projectNetworks(composeProject string) ([]string, error) {
+	defaultNetwork := composeProject + "_default"
+
+	lsOutput, err := exec.Command("docker", "network", "ls", "--format", `{{.Name}}\t{{.Label "com.docker.compose.project"}}`).Output()
+	if err != nil {
+		return nil, fmt.Errorf("error listing networks: %w", err)
+	}
+
+	var networks []string
+
+	for _, line := range strings.Split(strings.TrimSpace(string(lsOutput)), "\n") {
+		name, label, _ := strings.Cut(line, "\t")
+		if name != "" && (name == defaultNetwork || label == composeProject) {
+			networks = append(networks, name)
+		}
+	}
+
+	return networks, nil
+}
+
+// collectImages removes the images recorded by deploys and dangling images unless one of the
+// last gc-keep-deploys successful deploys of a project, a deploy in progress or a container uses them.
+func collectImages(projects []project.Project, staleImages []string, output io.Writer, dryRun bool) error {
+	candidates := map[string]bool{}
+	keep := map[string]bool{}
+
+	for _, image := range staleImages {
+		candidates[image] = true
+	}
+
+	keepDeploys := viper.GetInt("gc-keep-deploys")
+
+	for _, p := range projects {
+		records, err := deploy.Records(p)
+		if err != nil {
+			// Without the history of a project, its images can't be told apart from unused ones.
+			return fmt.Errorf("error listing deploys of %s: %w", p.FullName(), err)
+		}
+
+		kept := 0
+
+		for _, record := range records {
+			keepRecord := record.End == nil
+
+			if record.Status == deploy.StatusSucceeded && kept < keepDeploys {
+				keepRecord = true
+				kept++
+			}
+
+			for _, image := range record.Images {
+				candidates[image] = true
+
+				if keepRecord {
+					keep[image] = true
+				}
+			}
+		}
+	}
+
+	dangling, err := dockerFields("images", "--quiet", "--no-trunc", "--filter", "dangling=true")
+	if err != nil {
+		return fmt.Errorf("error listing dangling images: %w", err)
+	}
+
+	for _, image := range dangling {
+		candidates[image] = true
+	}
+
+	containers, err := dockerFields("ps", "-a", "--quiet", "--no-trunc")
+	if err != nil {
+		return fmt.Errorf("error listing containers: %w", err)
+	}
+
+	used, err := deploy.ContainerImages(containers)
+	if err != nil {
+		return err
+	}
+
+	for _, image := range used {
+		keep[image] = true
+	}
+
+	existing, err := dockerFields("images", "--all", "--quiet", "--no-trunc")
+	if err != nil {
+		return fmt.Errorf("error listing images: %w", err)
+	}
+
+	var removed int
+
+	for _, image := range existing {
+		if !candidates[image] || keep[image] {
+			continue
+		}
+
+		// Images are listed once per tag.
+		candidates[image] = false
+
+		if dryRun {
+			fmt.Fprintf(output, "Would remove image %s\n", image)
+			continue
+		}
+
+		rmOutput, err := exec.Command("docker", "image", "rm", image).CombinedOutput()
+		if err != nil {
+			fmt.Fprintf(output, "Error removing image %s: %s\n", image, strings.TrimSpace(string(rmOutput)))
+			continue
+		}
+
+		removed++
+	}
+
+	if !dryRun {
+		fmt.Fprintf(output, "Removed %d images\n", removed)
+	}
+
+	return nil
+}
+
+// collectBuildCache prunes the build cache down to gc-build-cache-size if it is set.
+func collectBuildCache(output io.Writer, dryRun bool) error {
+	size := viper.GetString("gc-build-cache-size")
+	if size == "" {
+		return nil
+	}
+
+	if dryRun {
+		fmt.Fprintf(output, "Would prune the build cache down to %s\n", size)
+		return nil
+	}
+
+	pruneOutput, err := exec.Command("docker", "builder", "prune", "--force", "--keep-storage", size).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("error pruning build cache: %s", strings.TrimSpace(string(pruneOutput)))
+	}
+
+	for _, line := range strings.Split(strings.TrimSpace(string(pruneOutput)), "\n") {
+		if strings.HasPrefix(line, "Total") {
+			fmt.Fprintf(output, "Pruned the build cache down to %s: %s\n", size, line)
+		}
+	}
+
+	return nil
+}
+
+// dockerFields runs a docker command and returns the fields of its output.
+func dockerFields(args ...string) ([]string, error) {
+	output, err := exec.Command("docker", args...).Output()
+	if err != nil {
+		return nil, err
+	}
+
+	return strings.Fields(string(output)), nil
+}
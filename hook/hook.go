@@ -6,13 +6,13 @@ import (
 	"io"
 	"log"
 	"os"
-	"os/exec"
-	"path"
-	"path/filepath"
 	"strings"
 
+	"github.com/antoniomika/pcompose/deploy"
+	"github.com/antoniomika/pcompose/mirror"
+	"github.com/antoniomika/pcompose/notify"
+	"github.com/antoniomika/pcompose/project"
 	"github.com/antoniomika/pcompose/utils"
-	"github.com/spf13/viper"
 )
 
 // Start initializes the git hook command.
@@ -24,130 +24,180 @@ func Start() {
 		log.Println("Error getting working directory:", err)
 	}
 
-	data, err := io.ReadAll(os.Stdin)
-	if err != nil {
-		log.Println("Error reading from stdin:", err)
-	}
+	var updates []deploy.RefUpdate
 
-	commandArgs := strings.Fields(strings.TrimSpace(string(data)))
+	if len(os.Args) >= 4 {
+		// The update hook is called once per ref with the ref as arguments.
+		updates = append(updates, deploy.RefUpdate{
+			Ref:    os.Args[1],
+			OldRev: os.Args[2],
+			NewRev: os.Args[3],
+		})
+	} else {
+		// The receive hooks get one "<old-rev> <new-rev> <ref>" line per updated ref on stdin.
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			log.Println("Error reading from stdin:", err)
+		}
 
-	var oldRev string
-	var newRev string
-	var refName string
+		for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) != 3 {
+				continue
+			}
+
+			updates = append(updates, deploy.RefUpdate{
+				Ref:    fields[2],
+				OldRev: fields[0],
+				NewRev: fields[1],
+			})
+		}
+	}
 
-	if len(commandArgs) > len(os.Args) {
-		oldRev = commandArgs[0]
-		newRev = commandArgs[1]
-		refName = commandArgs[2]
-	} else {
-		oldRev = os.Args[2]
-		newRev = os.Args[3]
-		refName = os.Args[1]
+	if len(updates) == 0 {
+		log.Println("No refs were updated")
+		return
 	}
 
 	switch hookType {
 	case "pre-receive":
-		handlePreReceive(hookType, repoDir, oldRev, newRev, refName)
+		handlePreReceive(hookType, repoDir, updates)
 	case "update":
-		handleUpdate(hookType, repoDir, oldRev, newRev, refName)
+		handleUpdate(hookType, repoDir, updates[0])
 	case "post-receive":
-		handlePostReceive(hookType, repoDir, oldRev, newRev, refName)
+		handlePostReceive(hookType, repoDir, updates)
 	default:
 		log.Println("Undefined hook type:", hookType)
 		return
 	}
 }
 
-func handlePreReceive(hookType, repoDir, oldRev, newRev, refName string) {
+func handlePreReceive(hookType, repoDir string, updates []deploy.RefUpdate) {
+	p := project.FromRepoDir(repoDir)
+
+	if remote := p.Config().Mirror.URL; remote != "" {
+		log.Printf("%s is a mirror of %s, push there instead", p.Name, mirror.Redact(remote))
+		os.Exit(1)
+	}
 }
 
-func handleUpdate(hookType, repoDir, oldRev, newRev, refName string) {
+func handleUpdate(hookType, repoDir string, update deploy.RefUpdate) {
+	p := project.FromRepoDir(repoDir)
+
+	err := checkRules(p, update, os.Getenv(utils.PusherEnv))
+	if err == nil && p.Config().Deploy.RejectOtherRefs {
+		err = checkDeployable(p, update)
+	}
+
+	if err != nil {
+		log.Printf("Rejecting %s, %s", update.Ref, err)
+		os.Exit(1)
+	}
 }
 
-func handlePostReceive(hookType, repoDir, oldRev, newRev, refName string) {
-	deploymentDir := path.Join(repoDir, path.Base(repoDir))
+// checkDeployable returns why none of the deployments of a repository deploys an update, if none does.
+// Deployments can deploy other refs than the repository, so the update is allowed if any of them deploys it.
+func checkDeployable(p project.Project, update deploy.RefUpdate) error {
+	var err error
 
-	if _, err := os.Stat(deploymentDir); os.IsNotExist(err) {
-		cloneCmd := exec.Command("git", "clone", repoDir, deploymentDir)
-		err := cloneCmd.Run()
-		if err != nil {
-			log.Println("Error cloning git repository:", err)
-			os.Exit(1)
-		}
-	} else {
-		fetchCmd := exec.Command("git", "fetch")
-		fetchCmd.Dir = deploymentDir
-		fetchCmd.Env = append(fetchCmd.Env, "GIT_DIR=.git")
-		err := fetchCmd.Run()
-		if err != nil {
-			log.Println("Error fetching git repository:", err)
-			os.Exit(1)
+	for _, deployment := range p.Deployments() {
+		_, err = deploy.PushedRevision(deployment, update)
+		if err == nil {
+			return nil
 		}
+	}
+
+	return err
+}
 
-		mainBranchCmd := exec.Command("sh", "-c", "git symbolic-ref refs/remotes/origin/HEAD | sed 's@^refs/remotes/origin/@@'")
-		mainBranchCmd.Dir = deploymentDir
-		mainBranchCmd.Env = append(mainBranchCmd.Env, "GIT_DIR=.git")
+func handlePostReceive(hookType, repoDir string, updates []deploy.RefUpdate) {
+	p := project.FromRepoDir(repoDir)
 
-		mainBranch, err := mainBranchCmd.Output()
-		if err != nil {
-			log.Println("Error getting main branch:", err)
-			os.Exit(1)
-		}
+	recordPush(updates)
 
-		mainBranchString := strings.TrimSpace(string(mainBranch))
-		if mainBranchString == "" {
-			mainBranchString = "main"
+	setInitialBranch(p, updates)
+
+	failed := false
+
+	// Each deployment of the repository decides on its own whether the push is deployed.
+	for _, deployment := range p.Deployments() {
+		update, revision, ok := deploy.DeployableRef(deployment, updates, func(update deploy.RefUpdate, err error) {
+			if deployment.Deployment == "" {
+				log.Printf("Not deploying %s, %s", update.Ref, err)
+			} else {
+				log.Printf("Not deploying %s to %s, %s", update.Ref, deployment.Deployment, err)
+			}
+		})
+		if !ok {
+			continue
 		}
 
-		resetCmd := exec.Command("git", "reset", fmt.Sprintf("origin/%s", mainBranchString), "--hard")
-		resetCmd.Dir = deploymentDir
-		resetCmd.Env = append(resetCmd.Env, "GIT_DIR=.git")
-		err = resetCmd.Run()
+		_, err := deploy.Run(deployment, deploy.Options{
+			Trigger:  deploy.TriggerPush,
+			Ref:      update.Ref,
+			OldRev:   update.OldRev,
+			NewRev:   update.NewRev,
+			Revision: revision,
+			Pusher:   os.Getenv(utils.PusherEnv),
+			Output:   os.Stdout,
+		})
+
+		// The deploy output already ends with the error.
 		if err != nil {
-			log.Println("Error resetting git repository:", err)
-			os.Exit(1)
+			failed = true
 		}
 	}
 
-	executable, err := os.Executable()
-	if err != nil {
-		log.Println("Error getting executable path:", err)
-	}
+	// The hook process exits once the deploys are done, so deliver pending notifications first.
+	notify.Wait()
 
-	executablePath, err := filepath.EvalSymlinks(executable)
-	if err != nil {
-		log.Println("Unable to evaluate symlink:", err)
+	if failed {
+		os.Exit(1)
 	}
+}
 
-	appDir := path.Dir(executablePath)
-
-	dataDir := viper.GetString("data-directory")
-	if !path.IsAbs(dataDir) {
-		dataDir = path.Join(appDir, dataDir)
+// recordPush appends the ref updates of a push to the push log of the server that received it,
+// which audits them once the push is done. Only post-receive knows which refs were really updated.
+func recordPush(updates []deploy.RefUpdate) {
+	pushLog := os.Getenv(utils.PushLogEnv)
+	if pushLog == "" {
+		return
 	}
 
-	pathSlice := strings.Split(strings.TrimPrefix(repoDir, dataDir+string(os.PathSeparator)), string(os.PathSeparator))
-	composeProject := strings.Join(pathSlice, "_")
+	file, err := os.OpenFile(pushLog, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		log.Println("Error opening push log:", err)
+		return
+	}
 
-	networkName := fmt.Sprintf("%s_default", composeProject)
+	defer file.Close()
 
-	networkCreate := exec.Command("docker", "network", "create", networkName)
-	networkCreate.Dir = deploymentDir
-	_ = networkCreate.Run()
+	for _, update := range updates {
+		_, err := fmt.Fprintf(file, "%s %s %s\n", update.OldRev, update.NewRev, update.Ref)
+		if err != nil {
+			log.Println("Error writing push log:", err)
+			return
+		}
+	}
+}
 
-	networkConnect := exec.Command("docker", "network", "connect", networkName, viper.GetString("frontend-container-name"))
-	networkConnect.Dir = deploymentDir
-	_ = networkConnect.Run()
+// setInitialBranch makes the first pushed branch the default branch if HEAD of the
+// repository points to a branch that doesn't exist, e.g. master when main was pushed.
+func setInitialBranch(p project.Project, updates []deploy.RefUpdate) {
+	refs := project.Refs(p.RepoDir)
 
-	cmd := exec.Command("docker-compose", "-p", composeProject, "up", "-d", "--build")
+	if _, ok := refs[project.DefaultBranch(p.RepoDir)]; ok {
+		return
+	}
 
-	cmd.Dir = deploymentDir
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	for _, update := range updates {
+		if _, ok := refs[update.Ref]; ok && strings.HasPrefix(update.Ref, "refs/heads/") {
+			err := project.SetDefaultBranch(p.RepoDir, update.Ref)
+			if err != nil {
+				log.Println("Error setting default branch:", err)
+			}
 
-	err = cmd.Run()
-	if err != nil {
-		log.Println("Error running docker-compose up:", err)
-		os.Exit(1)
+			return
+		}
 	}
 }
@@ -0,0 +1,44 @@
+package hook
+
+import (
+	"testing"
+
+	"github.com/antoniomika/pcompose/deploy"
+	"github.com/spf13/viper"
+)
+
+func TestCheckDeployable(t *testing.T) {
+	repo := newTestRepo(t)
+	main := repo.git("rev-parse", "refs/heads/main")
+	tag := repo.tag(main, false)
+
+	viper.Set("projects", []map[string]interface{}{{
+		"name":   "alice/app",
+		"deploy": map[string]interface{}{"reject-other-refs": true},
+		"deployments": []map[string]interface{}{
+			{"name": "production"},
+			{"name": "staging", "refs": deploy.PolicyTags, "tags": "rc-*"},
+		},
+	}})
+	defer viper.Reset()
+
+	tests := []struct {
+		name    string
+		update  deploy.RefUpdate
+		wantErr bool
+	}{
+		{name: "default branch of production", update: deploy.RefUpdate{Ref: "refs/heads/main", OldRev: deploy.ZeroRev, NewRev: main}},
+		{name: "tag of staging", update: deploy.RefUpdate{Ref: "refs/tags/rc-1", OldRev: deploy.ZeroRev, NewRev: tag}},
+		{name: "tag of neither", update: deploy.RefUpdate{Ref: "refs/tags/v1", OldRev: deploy.ZeroRev, NewRev: tag}, wantErr: true},
+		{name: "branch of neither", update: deploy.RefUpdate{Ref: "refs/heads/feature", OldRev: deploy.ZeroRev, NewRev: main}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkDeployable(repo.project, tt.update)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkDeployable() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
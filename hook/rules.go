@@ -0,0 +1,147 @@
+package hook
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/antoniomika/pcompose/deploy"
+	"github.com/antoniomika/pcompose/project"
+	"github.com/antoniomika/pcompose/utils"
+)
+
+// checkRules returns an error describing why an identity isn't allowed to update a ref
+// according to the ref rules of the project, or nil if the update is allowed.
+func checkRules(p project.Project, update deploy.RefUpdate, identity string) error {
+	rules := p.Config().Rules
+	ref, oldRev, newRev := update.Ref, update.OldRev, update.NewRev
+
+	err := checkPermissions(rules.Permissions, ref, identity)
+	if err != nil {
+		return err
+	}
+
+	if isProtected(p, rules, ref) {
+		if newRev == deploy.ZeroRev {
+			return fmt.Errorf("%s is protected and can't be deleted", ref)
+		}
+
+		if oldRev != deploy.ZeroRev && !isAncestor(p, oldRev, newRev) {
+			return fmt.Errorf("%s is protected and can't be force pushed, pull and merge the remote changes first", ref)
+		}
+	}
+
+	if rules.AllowedSigners != "" && newRev != deploy.ZeroRev && (len(rules.SignedRefs) == 0 || matchesAny(rules.SignedRefs, ref)) {
+		return checkSignatures(p, rules, update)
+	}
+
+	return nil
+}
+
+// checkPermissions returns an error if a ref matches permissions that don't include the identity.
+func checkPermissions(permissions []project.RefPermission, ref string, identity string) error {
+	restricted := false
+
+	for _, permission := range permissions {
+		if !matchesAny(permission.Refs, ref) {
+			continue
+		}
+
+		restricted = true
+
+		for _, allowed := range permission.Identities {
+			if identity != "" && allowed == identity {
+				return nil
+			}
+		}
+	}
+
+	if !restricted {
+		return nil
+	}
+
+	if identity == "" {
+		return fmt.Errorf("%s can only be updated by authenticated identities", ref)
+	}
+
+	return fmt.Errorf("%s can't update %s", identity, ref)
+}
+
+// isProtected returns whether a ref can't be force pushed or deleted.
+func isProtected(p project.Project, rules project.RulesConfig, ref string) bool {
+	if rules.ProtectDefaultBranch && ref == project.DefaultBranch(p.RepoDir) {
+		return true
+	}
+
+	return matchesAny(rules.ProtectedRefs, ref)
+}
+
+// isAncestor returns whether the old revision of a ref is reachable from the new one, i.e. the update fast-forwards.
+func isAncestor(p project.Project, oldRev string, newRev string) bool {
+	_, err := deploy.RepoGit(p, "merge-base", "--is-ancestor", oldRev, newRev)
+
+	return err == nil
+}
+
+// checkSignatures verifies the signature of a pushed tag and of every commit a ref gains against
+// an ssh allowed signers file. Commits reachable from the previous revision of the ref or from refs
+// that require signatures were verified when those refs were updated. Every other commit is verified,
+// including commits that were pushed to refs that don't require signatures before.
+func checkSignatures(p project.Project, rules project.RulesConfig, update deploy.RefUpdate) error {
+	allowedSigners := project.ServerPath(rules.AllowedSigners)
+
+	if _, err := os.Stat(allowedSigners); err != nil {
+		return fmt.Errorf("unable to read allowed signers: %w", err)
+	}
+
+	signersConfig := []string{"-c", "gpg.format=ssh", "-c", "gpg.ssh.allowedSignersFile=" + allowedSigners}
+
+	objectType, err := deploy.RepoGit(p, "cat-file", "-t", update.NewRev)
+	if err != nil {
+		return err
+	}
+
+	if objectType == "tag" {
+		_, err := deploy.RepoGit(p, append(signersConfig, "verify-tag", update.NewRev)...)
+		if err != nil {
+			return fmt.Errorf("tag %s isn't signed by an allowed signer", utils.ShortRev(update.NewRev))
+		}
+	}
+
+	revList := []string{"rev-list", update.NewRev, "--not"}
+	if update.OldRev != deploy.ZeroRev {
+		revList = append(revList, update.OldRev)
+	}
+
+	for ref, rev := range project.Refs(p.RepoDir) {
+		if len(rules.SignedRefs) == 0 || matchesAny(rules.SignedRefs, ref) {
+			revList = append(revList, rev)
+		}
+	}
+
+	commits, err := deploy.RepoGit(p, revList...)
+	if err != nil {
+		return err
+	}
+
+	for _, commit := range strings.Fields(commits) {
+		_, err := deploy.RepoGit(p, append(signersConfig, "verify-commit", commit)...)
+		if err != nil {
+			return fmt.Errorf("commit %s isn't signed by an allowed signer", utils.ShortRev(commit))
+		}
+	}
+
+	return nil
+}
+
+// matchesAny returns whether a ref matches any of the patterns.
+func matchesAny(patterns []string, ref string) bool {
+	for _, pattern := range patterns {
+		if match, err := path.Match(pattern, ref); err == nil && match {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,255 @@
+package hook
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/antoniomika/pcompose/deploy"
+	"github.com/antoniomika/pcompose/internal/gittest"
+	"github.com/antoniomika/pcompose/project"
+	"github.com/antoniomika/pcompose/utils"
+	"github.com/spf13/viper"
+)
+
+// testRepo is a repository with an ssh signing key whose git directory is used as the repository of a project.
+type testRepo struct {
+	t       *testing.T
+	dir     string
+	signers string
+	project project.Project
+	commits int
+}
+
+// newTestRepo creates a repository with a signed initial commit on main and an allowed signers file for its key.
+func newTestRepo(t *testing.T) *testRepo {
+	t.Helper()
+
+	gittest.Setup(t)
+
+	if _, err := exec.LookPath("ssh-keygen"); err != nil {
+		t.Skip("ssh-keygen is not available")
+	}
+
+	dir := t.TempDir()
+	key := filepath.Join(dir, "key")
+
+	output, err := exec.Command("ssh-keygen", "-q", "-t", "ed25519", "-N", "", "-C", "", "-f", key).CombinedOutput()
+	if err != nil {
+		t.Fatalf("ssh-keygen failed: %v: %s", err, output)
+	}
+
+	publicKey, err := os.ReadFile(key + ".pub")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signers := filepath.Join(dir, "allowed_signers")
+
+	err = os.WriteFile(signers, []byte("alice@example.com "+string(publicKey)), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo := &testRepo{
+		t:       t,
+		dir:     filepath.Join(dir, "repo"),
+		signers: signers,
+	}
+
+	gittest.Run(t, dir, "init", "-q", "-b", "main", repo.dir)
+	repo.git("config", "gpg.format", "ssh")
+	repo.git("config", "user.signingkey", key)
+
+	repo.project = project.Project{Name: "alice/app", RepoDir: filepath.Join(repo.dir, ".git")}
+
+	repo.git("update-ref", "refs/heads/main", repo.commit(deploy.ZeroRev, true))
+	repo.git("symbolic-ref", "HEAD", "refs/heads/main")
+
+	return repo
+}
+
+// git runs a git command in the repository and returns its trimmed output.
+func (r *testRepo) git(args ...string) string {
+	r.t.Helper()
+
+	return gittest.Run(r.t, r.dir, args...)
+}
+
+// commit creates a commit on top of parent that isn't referenced by any ref, like the objects of a push before the update hook.
+func (r *testRepo) commit(parent string, signed bool) string {
+	r.t.Helper()
+
+	r.commits++
+
+	args := []string{"commit-tree", r.git("hash-object", "-t", "tree", "-w", os.DevNull), "-m", fmt.Sprintf("change %d", r.commits)}
+	if parent != deploy.ZeroRev {
+		args = append(args, "-p", parent)
+	}
+
+	if signed {
+		args = append(args, "-S")
+	}
+
+	return r.git(args...)
+}
+
+// tag creates an annotated tag object for a commit that isn't referenced by any ref.
+func (r *testRepo) tag(commit string, signed bool) string {
+	r.t.Helper()
+
+	args := []string{"tag", "-m", "release", "tmp", commit}
+	if signed {
+		args = append(args, "-s")
+	}
+
+	r.git(args...)
+	tag := r.git("rev-parse", "refs/tags/tmp")
+	r.git("update-ref", "-d", "refs/tags/tmp")
+
+	return tag
+}
+
+// setRules configures the rules of the project of the repository.
+func setRules(t *testing.T, rules map[string]interface{}) {
+	t.Helper()
+
+	viper.Set("projects", []map[string]interface{}{{"name": "alice/app", "rules": rules}})
+	t.Cleanup(viper.Reset)
+}
+
+func TestCheckRulesSignatures(t *testing.T) {
+	repo := newTestRepo(t)
+	main := repo.git("rev-parse", "refs/heads/main")
+
+	setRules(t, map[string]interface{}{
+		"allowed-signers": repo.signers,
+		"signed-refs":     []string{"refs/heads/main", "refs/tags/*"},
+	})
+
+	// Unsigned commits can be pushed to refs that don't require signatures.
+	unsigned := repo.commit(main, false)
+
+	err := checkRules(repo.project, deploy.RefUpdate{Ref: "refs/heads/feature", OldRev: deploy.ZeroRev, NewRev: unsigned}, "alice")
+	if err != nil {
+		t.Fatalf("pushing an unsigned commit to an unsigned ref: %v", err)
+	}
+
+	repo.git("update-ref", "refs/heads/feature", unsigned)
+
+	signed := repo.commit(main, true)
+	signedOnUnsigned := repo.commit(unsigned, true)
+
+	tests := []struct {
+		name    string
+		update  deploy.RefUpdate
+		wantErr bool
+	}{
+		{name: "signed commit", update: deploy.RefUpdate{Ref: "refs/heads/main", OldRev: main, NewRev: signed}},
+		{name: "unsigned commit", update: deploy.RefUpdate{Ref: "refs/heads/main", OldRev: main, NewRev: repo.commit(main, false)}, wantErr: true},
+		{name: "fast-forward to an unsigned branch", update: deploy.RefUpdate{Ref: "refs/heads/main", OldRev: main, NewRev: unsigned}, wantErr: true},
+		{name: "signed commit on an unsigned branch", update: deploy.RefUpdate{Ref: "refs/heads/main", OldRev: main, NewRev: signedOnUnsigned}, wantErr: true},
+		{name: "new signed ref from an unsigned branch", update: deploy.RefUpdate{Ref: "refs/tags/v1", OldRev: deploy.ZeroRev, NewRev: repo.tag(unsigned, true)}, wantErr: true},
+		{name: "signed tag", update: deploy.RefUpdate{Ref: "refs/tags/v1", OldRev: deploy.ZeroRev, NewRev: repo.tag(main, true)}},
+		{name: "unsigned tag", update: deploy.RefUpdate{Ref: "refs/tags/v1", OldRev: deploy.ZeroRev, NewRev: repo.tag(main, false)}, wantErr: true},
+		{name: "lightweight tag", update: deploy.RefUpdate{Ref: "refs/tags/v1", OldRev: deploy.ZeroRev, NewRev: main}},
+		{name: "deletion", update: deploy.RefUpdate{Ref: "refs/heads/main", OldRev: main, NewRev: deploy.ZeroRev}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkRules(repo.project, tt.update, "alice")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkRules() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckRulesRelativeAllowedSigners(t *testing.T) {
+	repo := newTestRepo(t)
+	main := repo.git("rev-parse", "refs/heads/main")
+
+	// Hooks run from within the repository, not the working directory of the server.
+	t.Setenv(utils.WorkingDirEnv, filepath.Dir(repo.signers))
+
+	setRules(t, map[string]interface{}{"allowed-signers": filepath.Base(repo.signers)})
+
+	err := checkRules(repo.project, deploy.RefUpdate{Ref: "refs/heads/main", OldRev: main, NewRev: repo.commit(main, true)}, "alice")
+	if err != nil {
+		t.Errorf("checkRules() error = %v", err)
+	}
+
+	t.Setenv(utils.WorkingDirEnv, t.TempDir())
+
+	err = checkRules(repo.project, deploy.RefUpdate{Ref: "refs/heads/main", OldRev: main, NewRev: repo.commit(main, true)}, "alice")
+	if err == nil {
+		t.Error("checkRules() error = nil with a missing allowed signers file")
+	}
+}
+
+func TestCheckRulesProtectedRefs(t *testing.T) {
+	repo := newTestRepo(t)
+	main := repo.git("rev-parse", "refs/heads/main")
+
+	setRules(t, map[string]interface{}{
+		"protect-default-branch": true,
+		"protected-refs":         []string{"refs/tags/*"},
+	})
+
+	tests := []struct {
+		name    string
+		update  deploy.RefUpdate
+		wantErr bool
+	}{
+		{name: "fast-forward", update: deploy.RefUpdate{Ref: "refs/heads/main", OldRev: main, NewRev: repo.commit(main, false)}},
+		{name: "force push", update: deploy.RefUpdate{Ref: "refs/heads/main", OldRev: main, NewRev: repo.commit(deploy.ZeroRev, false)}, wantErr: true},
+		{name: "delete default branch", update: deploy.RefUpdate{Ref: "refs/heads/main", OldRev: main, NewRev: deploy.ZeroRev}, wantErr: true},
+		{name: "delete protected tag", update: deploy.RefUpdate{Ref: "refs/tags/v1", OldRev: main, NewRev: deploy.ZeroRev}, wantErr: true},
+		{name: "force push unprotected branch", update: deploy.RefUpdate{Ref: "refs/heads/feature", OldRev: main, NewRev: repo.commit(deploy.ZeroRev, false)}},
+		{name: "delete unprotected branch", update: deploy.RefUpdate{Ref: "refs/heads/feature", OldRev: main, NewRev: deploy.ZeroRev}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkRules(repo.project, tt.update, "alice")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkRules() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckPermissions(t *testing.T) {
+	permissions := []project.RefPermission{
+		{Refs: []string{"refs/heads/main", "refs/tags/*"}, Identities: []string{"alice"}},
+		{Refs: []string{"refs/tags/*"}, Identities: []string{"release-bot"}},
+	}
+
+	tests := []struct {
+		ref      string
+		identity string
+		wantErr  bool
+	}{
+		{ref: "refs/heads/main", identity: "alice"},
+		{ref: "refs/heads/main", identity: "bob", wantErr: true},
+		{ref: "refs/heads/main", identity: "", wantErr: true},
+		{ref: "refs/tags/v1", identity: "alice"},
+		{ref: "refs/tags/v1", identity: "release-bot"},
+		{ref: "refs/tags/v1", identity: "bob", wantErr: true},
+		{ref: "refs/heads/feature", identity: "bob"},
+		{ref: "refs/heads/feature", identity: ""},
+		{ref: "refs/heads/main/sub", identity: "bob"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ref+" "+tt.identity, func(t *testing.T) {
+			err := checkPermissions(permissions, tt.ref, tt.identity)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkPermissions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,568 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/antoniomika/pcompose/audit"
+	"github.com/antoniomika/pcompose/auth"
+	"github.com/antoniomika/pcompose/deploy"
+	"github.com/antoniomika/pcompose/jobs"
+	"github.com/antoniomika/pcompose/mirror"
+	"github.com/antoniomika/pcompose/project"
+	"golang.org/x/crypto/ssh"
+)
+
+// apiPrefix is the path the management API is served under.
+const apiPrefix = "/api/"
+
+// actionSeparator separates the project name, which can contain slashes, from
+// the action in API paths, e.g. /api/projects/user/httpbin/-/deploys.
+const actionSeparator = "/-/"
+
+// projectInfo is the API representation of a project.
+type projectInfo struct {
+	Name           string         `json:"name"`
+	ComposeProject string         `json:"compose_project"`
+	Revision       string         `json:"revision,omitempty"`
+	LastDeploy     *deploy.Record `json:"last_deploy,omitempty"`
+
+	PushMirrors []mirror.PushStatus `json:"push_mirrors,omitempty"`
+}
+
+// deployRequest is the body of redeploy and rollback requests.
+type deployRequest struct {
+	Revision string `json:"revision"`
+	Deploy   string `json:"deploy"`
+}
+
+// apiRequest holds the context of an authenticated API request for a project.
+type apiRequest struct {
+	w       http.ResponseWriter
+	r       *http.Request
+	perms   *ssh.Permissions
+	project project.Project
+	action  string
+}
+
+// handleAPI routes management API requests. The API deploys and stops projects, so it always
+// requires a token instead of allowing anonymous requests when authentication is disabled.
+func handleAPI(w http.ResponseWriter, r *http.Request) {
+	perms, ok := authenticateToken(r)
+	if !ok {
+		requireAuthentication(w)
+		return
+	}
+
+	route := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, apiPrefix), "/")
+
+	if route == "projects" {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+
+		listProjects(w, perms)
+		return
+	}
+
+	if !strings.HasPrefix(route, "projects/") {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	name, action := strings.TrimPrefix(route, "projects/"), ""
+	if i := strings.Index(name, actionSeparator); i >= 0 {
+		name, action = name[:i], name[i+len(actionSeparator):]
+	}
+
+	p, err := project.Get(name)
+	if err != nil || !auth.Authorized(perms, p.Name) {
+		writeError(w, http.StatusNotFound, project.ErrNotFound.Error())
+		return
+	}
+
+	req := &apiRequest{
+		w:       w,
+		r:       r,
+		perms:   perms,
+		project: p,
+		action:  action,
+	}
+
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, getProjectInfo(p))
+	case action == "deploys" && r.Method == http.MethodGet:
+		listDeploys(req)
+	case action == "deploys" && r.Method == http.MethodPost:
+		startDeploy(req, deploy.TriggerRedeploy)
+	case strings.HasPrefix(action, "deploys/") && strings.HasSuffix(action, "/log") && r.Method == http.MethodGet:
+		getDeployLog(req, strings.TrimSuffix(strings.TrimPrefix(action, "deploys/"), "/log"))
+	case strings.HasPrefix(action, "deploys/") && r.Method == http.MethodGet:
+		getDeploy(req, strings.TrimPrefix(action, "deploys/"))
+	case action == "rollback" && r.Method == http.MethodPost:
+		startDeploy(req, deploy.TriggerRollback)
+	case action == "ps" && r.Method == http.MethodGet:
+		listContainers(req)
+	case (action == "start" || action == "stop" || action == "restart") && r.Method == http.MethodPost:
+		controlServices(req)
+	case action == "logs" && r.Method == http.MethodGet:
+		streamLogs(req)
+	case action == "jobs" && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, jobs.Statuses(p))
+	case strings.HasPrefix(action, "jobs/"):
+		routeJob(req, strings.TrimPrefix(action, "jobs/"))
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+// getProjectInfo returns the API representation of a project.
+func getProjectInfo(p project.Project) projectInfo {
+	info := projectInfo{
+		Name:           p.FullName(),
+		ComposeProject: p.ComposeProject(),
+	}
+
+	revision, err := deploy.CurrentRevision(p)
+	if err == nil {
+		info.Revision = revision
+	}
+
+	records, err := deploy.Records(p)
+	if err == nil && len(records) > 0 {
+		info.LastDeploy = records[0]
+	}
+
+	pushMirrors, err := mirror.PushStatuses(p)
+	if err == nil {
+		info.PushMirrors = pushMirrors
+	}
+
+	return info
+}
+
+// listProjects returns every project the identity can access.
+func listProjects(w http.ResponseWriter, perms *ssh.Permissions) {
+	projects, err := project.List()
+	if err != nil {
+		log.Println("Error listing projects:", err)
+		writeError(w, http.StatusInternalServerError, "unable to list projects")
+		return
+	}
+
+	infos := []projectInfo{}
+
+	for _, p := range projects {
+		if !auth.Authorized(perms, p.Name) {
+			continue
+		}
+
+		infos = append(infos, getProjectInfo(p))
+	}
+
+	writeJSON(w, http.StatusOK, infos)
+}
+
+// listDeploys returns the deploy history of a project.
+func listDeploys(req *apiRequest) {
+	records, err := deploy.Records(req.project)
+	if err != nil {
+		log.Println("Error listing deploys:", err)
+		writeError(req.w, http.StatusInternalServerError, "unable to list deploys")
+		return
+	}
+
+	if records == nil {
+		records = []*deploy.Record{}
+	}
+
+	writeJSON(req.w, http.StatusOK, records)
+}
+
+// getDeploy returns a single deploy of a project.
+func getDeploy(req *apiRequest, id string) {
+	record, err := deploy.GetRecord(req.project, id)
+	if err != nil {
+		if errors.Is(err, deploy.ErrRecordNotFound) {
+			writeError(req.w, http.StatusNotFound, err.Error())
+			return
+		}
+
+		log.Println("Error reading deploy:", err)
+		writeError(req.w, http.StatusInternalServerError, "unable to read deploy")
+		return
+	}
+
+	writeJSON(req.w, http.StatusOK, record)
+}
+
+// getDeployLog returns the timestamped output of a deploy of a project.
+func getDeployLog(req *apiRequest, id string) {
+	data, err := deploy.ReadLog(req.project, id)
+	if err != nil {
+		if errors.Is(err, deploy.ErrRecordNotFound) {
+			writeError(req.w, http.StatusNotFound, err.Error())
+			return
+		}
+
+		log.Println("Error reading deploy log:", err)
+		writeError(req.w, http.StatusInternalServerError, "unable to read deploy log")
+		return
+	}
+
+	req.w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+	_, err = req.w.Write(data)
+	if err != nil {
+		log.Println("Error writing deploy log:", err)
+	}
+}
+
+// startDeploy redeploys or rolls back a project in the background. Rollbacks
+// deploy the revision of the given deploy, the given revision or the previous
+// successfully deployed revision.
+func startDeploy(req *apiRequest, trigger string) {
+	var body deployRequest
+
+	if req.r.ContentLength != 0 {
+		err := json.NewDecoder(req.r.Body).Decode(&body)
+		if err != nil && !errors.Is(err, io.EOF) {
+			writeError(req.w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+	}
+
+	revision := body.Revision
+
+	if trigger == deploy.TriggerRollback && revision == "" {
+		var err error
+
+		revision, err = rollbackRevision(req.project, body.Deploy)
+		if err != nil {
+			writeError(req.w, http.StatusConflict, err.Error())
+			return
+		}
+	}
+
+	if strings.HasPrefix(revision, "-") {
+		writeError(req.w, http.StatusBadRequest, "invalid revision")
+		return
+	}
+
+	auditAPI(req)
+
+	id := startBackgroundDeploy(req.project, trigger, revision, req.perms)
+
+	writeJSON(req.w, http.StatusAccepted, map[string]string{
+		"id":     id,
+		"status": deploy.StatusQueued,
+	})
+}
+
+// rollbackRevision returns the revision of the given deploy, or the previous
+// successfully deployed revision if no deploy is given.
+func rollbackRevision(p project.Project, deployID string) (string, error) {
+	if deployID == "" {
+		return deploy.PreviousRevision(p)
+	}
+
+	record, err := deploy.GetRecord(p, deployID)
+	if err != nil {
+		return "", err
+	}
+
+	if record.Revision == "" {
+		return "", errors.New("deploy has no revision to roll back to")
+	}
+
+	return record.Revision, nil
+}
+
+// startBackgroundDeploy deploys a project in the background and returns the ID of the deploy.
+func startBackgroundDeploy(p project.Project, trigger string, revision string, perms *ssh.Permissions) string {
+	opts := deploy.Options{
+		ID:       deploy.NewID(),
+		Trigger:  trigger,
+		Revision: revision,
+		Pusher:   auth.Identity(perms),
+		Output:   log.Writer(),
+	}
+
+	go func() {
+		_, err := deploy.Run(p, opts)
+		if err != nil {
+			log.Println("Error deploying project:", err)
+		}
+	}()
+
+	return opts.ID
+}
+
+// routeJob routes the requests for the runs of a job, e.g. jobs/<job>/runs/<id>/log.
+func routeJob(req *apiRequest, route string) {
+	parts := strings.Split(route, "/")
+	if len(parts) < 2 || parts[1] != "runs" {
+		writeError(req.w, http.StatusNotFound, "not found")
+		return
+	}
+
+	switch {
+	case len(parts) == 2 && req.r.Method == http.MethodGet:
+		listJobRuns(req, parts[0])
+	case len(parts) == 2 && req.r.Method == http.MethodPost:
+		startJobRun(req, parts[0])
+	case len(parts) == 3 && req.r.Method == http.MethodGet:
+		getJobRun(req, parts[0], parts[2])
+	case len(parts) == 4 && parts[3] == "log" && req.r.Method == http.MethodGet:
+		getJobLog(req, parts[0], parts[2])
+	default:
+		writeError(req.w, http.StatusNotFound, "not found")
+	}
+}
+
+// listJobRuns returns the run history of a job.
+func listJobRuns(req *apiRequest, job string) {
+	if _, err := jobs.Find(req.project, job); err != nil {
+		writeError(req.w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	runs, err := jobs.Runs(req.project, job)
+	if err != nil {
+		if errors.Is(err, jobs.ErrJobNotFound) {
+			writeError(req.w, http.StatusNotFound, err.Error())
+			return
+		}
+
+		log.Println("Error listing job runs:", err)
+		writeError(req.w, http.StatusInternalServerError, "unable to list job runs")
+		return
+	}
+
+	if runs == nil {
+		runs = []*jobs.Run{}
+	}
+
+	writeJSON(req.w, http.StatusOK, runs)
+}
+
+// getJobRun returns a single run of a job.
+func getJobRun(req *apiRequest, job string, id string) {
+	run, err := jobs.GetRun(req.project, job, id)
+	if err != nil {
+		if errors.Is(err, jobs.ErrRunNotFound) {
+			writeError(req.w, http.StatusNotFound, err.Error())
+			return
+		}
+
+		log.Println("Error reading job run:", err)
+		writeError(req.w, http.StatusInternalServerError, "unable to read job run")
+		return
+	}
+
+	writeJSON(req.w, http.StatusOK, run)
+}
+
+// getJobLog returns the timestamped output of a run of a job as plain text.
+func getJobLog(req *apiRequest, job string, id string) {
+	data, err := jobs.ReadLog(req.project, job, id)
+	if err != nil {
+		if errors.Is(err, jobs.ErrRunNotFound) {
+			writeError(req.w, http.StatusNotFound, err.Error())
+			return
+		}
+
+		log.Println("Error reading job log:", err)
+		writeError(req.w, http.StatusInternalServerError, "unable to read job log")
+		return
+	}
+
+	req.w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+	_, err = req.w.Write(data)
+	if err != nil {
+		log.Println("Error writing job log:", err)
+	}
+}
+
+// startJobRun runs a job in the background and returns the ID of the run.
+func startJobRun(req *apiRequest, name string) {
+	job, err := jobs.Find(req.project, name)
+	if err != nil {
+		writeError(req.w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	auditAPI(req)
+
+	opts := jobs.Options{
+		ID:      deploy.NewID(),
+		Trigger: jobs.TriggerManual,
+		Output:  log.Writer(),
+	}
+
+	go func() {
+		_, err := jobs.Execute(req.project, job, opts)
+		if err != nil {
+			log.Println("Error running job:", err)
+		}
+	}()
+
+	writeJSON(req.w, http.StatusAccepted, map[string]string{
+		"id":     opts.ID,
+		"status": deploy.StatusRunning,
+	})
+}
+
+// listContainers returns the status of the containers of a project.
+func listContainers(req *apiRequest) {
+	containers, err := deploy.Containers(req.project)
+	if err != nil {
+		log.Println("Error listing containers:", err)
+		writeError(req.w, http.StatusInternalServerError, "unable to list containers")
+		return
+	}
+
+	writeJSON(req.w, http.StatusOK, containers)
+}
+
+// controlServices starts, stops or restarts every service of a project or the service given as a query parameter.
+func controlServices(req *apiRequest) {
+	args := []string{req.action}
+
+	service := req.r.URL.Query().Get("service")
+	if strings.HasPrefix(service, "-") {
+		writeError(req.w, http.StatusBadRequest, "invalid service")
+		return
+	}
+
+	if service != "" {
+		args = append(args, service)
+	}
+
+	auditAPI(req)
+
+	output, err := deploy.Compose(req.project, args...).CombinedOutput()
+	if err != nil {
+		writeJSON(req.w, http.StatusInternalServerError, map[string]string{
+			"error":  err.Error(),
+			"output": string(output),
+		})
+		return
+	}
+
+	writeJSON(req.w, http.StatusOK, map[string]string{
+		"output": string(output),
+	})
+}
+
+// streamLogs streams the logs of a project or a single service until the client disconnects.
+func streamLogs(req *apiRequest) {
+	query := req.r.URL.Query()
+
+	tail := query.Get("tail")
+	if tail == "" {
+		tail = "100"
+	} else if _, err := strconv.Atoi(tail); err != nil && tail != "all" {
+		writeError(req.w, http.StatusBadRequest, "invalid tail")
+		return
+	}
+
+	args := []string{"logs", "--no-color", "--tail", tail}
+
+	if follow, _ := strconv.ParseBool(query.Get("follow")); follow {
+		args = append(args, "--follow")
+	}
+
+	service := query.Get("service")
+	if strings.HasPrefix(service, "-") {
+		writeError(req.w, http.StatusBadRequest, "invalid service")
+		return
+	}
+
+	if service != "" {
+		args = append(args, service)
+	}
+
+	req.w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	req.w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	output := flushWriter{w: req.w}
+
+	cmd := deploy.Compose(req.project, args...)
+	cmd.Stdout = output
+	cmd.Stderr = output
+
+	err := cmd.Start()
+	if err != nil {
+		log.Println("Error streaming logs:", err)
+		writeError(req.w, http.StatusInternalServerError, "unable to stream logs")
+		return
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		select {
+		case <-req.r.Context().Done():
+			_ = cmd.Process.Kill()
+		case <-done:
+		}
+	}()
+
+	_ = cmd.Wait()
+}
+
+// auditAPI logs a management API call that changes a project.
+func auditAPI(req *apiRequest) {
+	audit.Log(audit.Entry{
+		Action:     audit.ActionAPI,
+		Identity:   auth.Identity(req.perms),
+		RemoteAddr: req.r.RemoteAddr,
+		Method:     "token",
+		Target:     req.project.FullName(),
+		Payload:    req.r.Method + " " + req.r.URL.RequestURI(),
+	})
+}
+
+// flushWriter flushes the response after every write so output is streamed.
+type flushWriter struct {
+	w http.ResponseWriter
+}
+
+// Write writes to and flushes the response.
+func (f flushWriter) Write(p []byte) (int, error) {
+	n, err := f.w.Write(p)
+
+	if flusher, ok := f.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+
+	return n, err
+}
+
+// writeJSON writes a JSON response.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		log.Println("Error writing response:", err)
+	}
+}
+
+// writeError writes a JSON error response.
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{
+		"error": msg,
+	})
+}
@@ -0,0 +1,56 @@
+package httpserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestAPIRequiresToken(t *testing.T) {
+	tokensFile := filepath.Join(t.TempDir(), "tokens")
+
+	err := os.WriteFile(tokensFile, []byte("alice s3cret\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	viper.Set("authentication", false)
+	viper.Set("http-tokens-file", tokensFile)
+	viper.Set("data-directory", t.TempDir())
+
+	defer viper.Reset()
+
+	tests := []struct {
+		name          string
+		method        string
+		path          string
+		authorization string
+		want          int
+	}{
+		{name: "anonymous list", method: http.MethodGet, path: "/api/projects", want: http.StatusUnauthorized},
+		{name: "anonymous deploy", method: http.MethodPost, path: "/api/projects/alice/app/-/deploys", want: http.StatusUnauthorized},
+		{name: "anonymous stop", method: http.MethodPost, path: "/api/projects/alice/app/-/stop", want: http.StatusUnauthorized},
+		{name: "invalid token", method: http.MethodGet, path: "/api/projects", authorization: "Bearer wrong", want: http.StatusUnauthorized},
+		{name: "valid token", method: http.MethodGet, path: "/api/projects", authorization: "Bearer s3cret", want: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			}
+
+			w := httptest.NewRecorder()
+			handleAPI(w, r)
+
+			if w.Code != tt.want {
+				t.Errorf("handleAPI() status = %d, want %d: %s", w.Code, tt.want, w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,341 @@
+package httpserver
+
+import (
+	"crypto/subtle"
+	"embed"
+	"errors"
+	"html/template"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/antoniomika/pcompose/audit"
+	"github.com/antoniomika/pcompose/auth"
+	"github.com/antoniomika/pcompose/deploy"
+	"github.com/antoniomika/pcompose/metrics"
+	"github.com/antoniomika/pcompose/project"
+	"github.com/antoniomika/pcompose/utils"
+	"github.com/spf13/viper"
+)
+
+// dashboardPrefix is the path the web dashboard is served under.
+const dashboardPrefix = "/dashboard/"
+
+// sessionCookie is the name of the cookie holding the dashboard session.
+const sessionCookie = "pcompose_session"
+
+// dashboardDeploys is the number of recent deploys shown for a project.
+const dashboardDeploys = 20
+
+//go:embed templates/*.html
+var templateFiles embed.FS
+
+// templates holds the parsed dashboard templates.
+var templates = template.Must(template.New("").Funcs(template.FuncMap{
+	"shortRev": utils.ShortRev,
+	"formatTime": func(t time.Time) string {
+		return t.Format(viper.GetString("time-format"))
+	},
+	"duration": func(d time.Duration) string {
+		return d.Round(time.Second).String()
+	},
+}).ParseFS(templateFiles, "templates/*.html"))
+
+// dashboardProject is a project with the status of its containers.
+type dashboardProject struct {
+	Info       projectInfo
+	Containers []deploy.Container
+	Running    int
+	Unhealthy  int
+}
+
+// dashboardPage is the data passed to every dashboard template.
+type dashboardPage struct {
+	Title    string
+	Session  *auth.Session
+	Identity string
+	Message  string
+	Host     string
+	Projects []dashboardProject
+	Project  dashboardProject
+	Deploys  []*deploy.Record
+	Deploy   *deploy.Record
+	Log      string
+}
+
+// handleDashboard routes web dashboard requests.
+func handleDashboard(w http.ResponseWriter, r *http.Request) {
+	route := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, dashboardPrefix), "/")
+
+	if route == "login" {
+		handleDashboardLogin(w, r)
+		return
+	}
+
+	session := dashboardSession(r)
+	if session == nil {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+
+		w.WriteHeader(http.StatusUnauthorized)
+		renderTemplate(w, "login.html", dashboardPage{
+			Title: "Log in",
+			Host:  host,
+		})
+		return
+	}
+
+	page := dashboardPage{
+		Session:  session,
+		Identity: auth.Identity(session.Permissions),
+		Message:  r.URL.Query().Get("message"),
+	}
+
+	if r.Method == http.MethodPost {
+		if subtle.ConstantTimeCompare([]byte(r.PostFormValue("csrf")), []byte(session.CSRFToken)) != 1 {
+			http.Error(w, "invalid csrf token", http.StatusForbidden)
+			return
+		}
+	}
+
+	switch {
+	case route == "" && r.Method == http.MethodGet:
+		renderDashboardIndex(w, page)
+	case route == "logout" && r.Method == http.MethodPost:
+		auth.EndSession(session.ID)
+
+		http.SetCookie(w, &http.Cookie{
+			Name:   sessionCookie,
+			Path:   dashboardPrefix,
+			MaxAge: -1,
+		})
+
+		http.Redirect(w, r, dashboardPrefix, http.StatusSeeOther)
+	case strings.HasPrefix(route, "projects/"):
+		name, action := strings.TrimPrefix(route, "projects/"), ""
+		if i := strings.Index(name, actionSeparator); i >= 0 {
+			name, action = name[:i], name[i+len(actionSeparator):]
+		}
+
+		p, err := project.Get(name)
+		if err != nil || !auth.Authorized(session.Permissions, p.Name) {
+			http.NotFound(w, r)
+			return
+		}
+
+		if action == "" && r.Method == http.MethodGet {
+			renderDashboardProject(w, page, p)
+		} else if strings.HasPrefix(action, "deploys/") && strings.HasSuffix(action, "/log") && r.Method == http.MethodGet {
+			renderDashboardDeployLog(w, r, page, p, strings.TrimSuffix(strings.TrimPrefix(action, "deploys/"), "/log"))
+		} else if r.Method == http.MethodPost {
+			handleDashboardAction(w, r, session, p, action)
+		} else {
+			http.NotFound(w, r)
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// handleDashboardLogin exchanges a login code created over SSH for a session cookie.
+func handleDashboardLogin(w http.ResponseWriter, r *http.Request) {
+	session, ok := auth.RedeemLoginCode(r.URL.Query().Get("code"))
+	if !ok {
+		metrics.AuthFailure("http", "invalid_login_code")
+		http.Error(w, "invalid or expired login code, request a new one using: ssh <host> pcompose login", http.StatusUnauthorized)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    session.ID,
+		Path:     dashboardPrefix,
+		Expires:  session.Expires,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
+	})
+
+	// A redirect from another site would not carry a strict cookie, so use a page refresh instead.
+	w.Header().Set("Refresh", "0; url="+dashboardPrefix)
+	w.WriteHeader(http.StatusOK)
+
+	_, err := w.Write([]byte("Logged in, redirecting to the dashboard.\n"))
+	if err != nil {
+		log.Println("Error writing response:", err)
+	}
+}
+
+// dashboardSession returns the session of the request, if any.
+func dashboardSession(r *http.Request) *auth.Session {
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return nil
+	}
+
+	session, ok := auth.GetSession(cookie.Value)
+	if !ok {
+		return nil
+	}
+
+	return session
+}
+
+// getDashboardProject returns a project along with the status of its containers.
+func getDashboardProject(p project.Project) dashboardProject {
+	dp := dashboardProject{
+		Info: getProjectInfo(p),
+	}
+
+	containers, err := deploy.Containers(p)
+	if err != nil {
+		log.Println("Error listing containers:", err)
+	}
+
+	dp.Containers = containers
+
+	for _, c := range containers {
+		if c.State == "running" {
+			dp.Running++
+		}
+
+		if c.Health == "unhealthy" {
+			dp.Unhealthy++
+		}
+	}
+
+	return dp
+}
+
+// renderDashboardIndex renders the list of projects the session can access.
+func renderDashboardIndex(w http.ResponseWriter, page dashboardPage) {
+	projects, err := project.List()
+	if err != nil {
+		log.Println("Error listing projects:", err)
+		http.Error(w, "unable to list projects", http.StatusInternalServerError)
+		return
+	}
+
+	page.Title = "Projects"
+
+	for _, p := range projects {
+		if !auth.Authorized(page.Session.Permissions, p.Name) {
+			continue
+		}
+
+		page.Projects = append(page.Projects, getDashboardProject(p))
+	}
+
+	renderTemplate(w, "index.html", page)
+}
+
+// renderDashboardProject renders the status and deploy history of a project.
+func renderDashboardProject(w http.ResponseWriter, page dashboardPage, p project.Project) {
+	page.Title = p.FullName()
+	page.Project = getDashboardProject(p)
+
+	records, err := deploy.Records(p)
+	if err != nil {
+		log.Println("Error listing deploys:", err)
+	}
+
+	if len(records) > dashboardDeploys {
+		records = records[:dashboardDeploys]
+	}
+
+	page.Deploys = records
+
+	renderTemplate(w, "project.html", page)
+}
+
+// renderDashboardDeployLog renders the timestamped output of a deploy of a project.
+func renderDashboardDeployLog(w http.ResponseWriter, r *http.Request, page dashboardPage, p project.Project, id string) {
+	record, err := deploy.GetRecord(p, id)
+	if err != nil {
+		if errors.Is(err, deploy.ErrRecordNotFound) {
+			http.NotFound(w, r)
+			return
+		}
+
+		log.Println("Error reading deploy:", err)
+		http.Error(w, "unable to read deploy", http.StatusInternalServerError)
+		return
+	}
+
+	data, err := deploy.ReadLog(p, record.ID)
+	if err != nil && !errors.Is(err, deploy.ErrRecordNotFound) {
+		log.Println("Error reading deploy log:", err)
+		http.Error(w, "unable to read deploy log", http.StatusInternalServerError)
+		return
+	}
+
+	page.Title = p.FullName() + " deploy " + record.ID
+	page.Project = dashboardProject{Info: getProjectInfo(p)}
+	page.Deploy = record
+	page.Log = string(data)
+
+	renderTemplate(w, "deploy.html", page)
+}
+
+// handleDashboardAction redeploys, restarts or rolls back a project.
+func handleDashboardAction(w http.ResponseWriter, r *http.Request, session *auth.Session, p project.Project, action string) {
+	var message string
+
+	switch action {
+	case "redeploy":
+		id := startBackgroundDeploy(p, deploy.TriggerRedeploy, "", session.Permissions)
+		message = "Started deploy " + id
+	case "rollback":
+		revision, err := rollbackRevision(p, r.PostFormValue("deploy"))
+		if err != nil {
+			message = "Unable to roll back: " + err.Error()
+			break
+		}
+
+		id := startBackgroundDeploy(p, deploy.TriggerRollback, revision, session.Permissions)
+		message = "Started rollback " + id
+	case "restart":
+		output, err := deploy.Compose(p, "restart").CombinedOutput()
+		if err != nil {
+			log.Println("Error restarting project:", err, string(output))
+			message = "Unable to restart: " + err.Error()
+			break
+		}
+
+		message = "Restarted all services"
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	auditDashboard(r, session, p, action)
+
+	http.Redirect(w, r, dashboardPrefix+"projects/"+p.FullName()+"?message="+url.QueryEscape(message), http.StatusSeeOther)
+}
+
+// auditDashboard logs a dashboard action that changes a project.
+func auditDashboard(r *http.Request, session *auth.Session, p project.Project, action string) {
+	audit.Log(audit.Entry{
+		Action:     audit.ActionAPI,
+		Identity:   auth.Identity(session.Permissions),
+		RemoteAddr: r.RemoteAddr,
+		Method:     "session",
+		Target:     p.FullName(),
+		Payload:    r.Method + " " + r.URL.RequestURI(),
+	})
+}
+
+// renderTemplate renders a dashboard template.
+func renderTemplate(w http.ResponseWriter, name string, page dashboardPage) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+	err := templates.ExecuteTemplate(w, name, page)
+	if err != nil {
+		log.Println("Error rendering template:", err)
+	}
+}
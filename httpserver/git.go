@@ -0,0 +1,165 @@
+package httpserver
+
+import (
+	"log"
+	"net/http"
+	"net/http/cgi"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/antoniomika/pcompose/audit"
+	"github.com/antoniomika/pcompose/auth"
+	"github.com/antoniomika/pcompose/metrics"
+	"github.com/antoniomika/pcompose/mirror"
+	"github.com/antoniomika/pcompose/project"
+	"github.com/antoniomika/pcompose/utils"
+	"github.com/spf13/viper"
+)
+
+// gitPrefix is the path repositories are served under, e.g. /git/user/httpbin.git.
+const gitPrefix = "/git/"
+
+// gitEndpoints are the smart http endpoints served for every repository.
+var gitEndpoints = []string{"/info/refs", "/" + utils.UploadPackServiceName, "/" + utils.ReceivePackServiceName}
+
+// handleGit serves the git smart http protocol using git http-backend.
+func handleGit(w http.ResponseWriter, r *http.Request) {
+	if isLFSRequest(r) {
+		handleLFS(w, r)
+		return
+	}
+
+	repoPath := strings.TrimPrefix(r.URL.Path, strings.TrimSuffix(gitPrefix, "/"))
+
+	var endpoint string
+	for _, gitEndpoint := range gitEndpoints {
+		if strings.HasSuffix(repoPath, gitEndpoint) {
+			endpoint = gitEndpoint
+			break
+		}
+	}
+
+	if endpoint == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	service := strings.TrimPrefix(endpoint, "/")
+	if endpoint == "/info/refs" {
+		service = r.URL.Query().Get("service")
+	}
+
+	if service != utils.UploadPackServiceName && service != utils.ReceivePackServiceName {
+		http.Error(w, "only the smart http protocol is supported", http.StatusForbidden)
+		return
+	}
+
+	repo, err := project.CleanRepoName(strings.TrimSuffix(repoPath, endpoint))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	perms, ok := authenticate(r)
+	if !ok {
+		requireAuthentication(w)
+		return
+	}
+
+	identity := auth.Identity(perms)
+
+	auditLog := audit.Entry{
+		Action:     audit.ActionExec,
+		Identity:   identity,
+		RemoteAddr: r.RemoteAddr,
+		Method:     "token",
+		Payload:    service + " " + repo,
+	}
+
+	if !auth.Authorized(perms, repo) {
+		auditLog.Error = "access to " + repo + " denied"
+		audit.Log(auditLog)
+
+		http.Error(w, auditLog.Error, http.StatusForbidden)
+		return
+	}
+
+	repoDir := project.RepoDir(repo)
+
+	if service == utils.ReceivePackServiceName {
+		err := project.InitRepository(repoDir)
+		if err != nil {
+			log.Println("Error initializing repository:", err)
+			http.Error(w, "unable to initialize repository", http.StatusInternalServerError)
+			return
+		}
+	} else if _, err := os.Stat(repoDir); os.IsNotExist(err) {
+		http.NotFound(w, r)
+		return
+	}
+
+	projectRoot, err := filepath.Abs(viper.GetString("data-directory"))
+	if err != nil {
+		log.Println("Error getting data directory:", err)
+		http.Error(w, "unable to find repository", http.StatusInternalServerError)
+		return
+	}
+
+	gitPath, err := exec.LookPath("git")
+	if err != nil {
+		log.Println("Error finding git:", err)
+		http.Error(w, "git is not available", http.StatusInternalServerError)
+		return
+	}
+
+	remoteUser := identity
+	if remoteUser == "" {
+		remoteUser = "anonymous"
+	}
+
+	handler := &cgi.Handler{
+		Path: gitPath,
+		Args: []string{"http-backend"},
+		Root: strings.TrimSuffix(gitPrefix, "/"),
+		Env: append(os.Environ(),
+			"GIT_PROJECT_ROOT="+projectRoot,
+			"GIT_HTTP_EXPORT_ALL=1",
+			// http-backend only allows pushes from authenticated users.
+			"REMOTE_USER="+remoteUser,
+			utils.PusherEnv+"="+identity,
+		),
+	}
+
+	// Repositories are stored without the .git suffix clients usually add.
+	backendRequest := r.Clone(r.Context())
+	backendRequest.URL.Path = gitPrefix + repo + endpoint
+
+	if endpoint != "/"+utils.ReceivePackServiceName {
+		handler.ServeHTTP(w, backendRequest)
+		return
+	}
+
+	pushLog, err := audit.PushLog()
+	if err != nil {
+		log.Println("Error creating push log:", err)
+		handler.ServeHTTP(w, backendRequest)
+		return
+	}
+
+	defer func() {
+		err := os.Remove(pushLog)
+		if err != nil {
+			log.Println("Error removing push log:", err)
+		}
+	}()
+
+	handler.Env = append(handler.Env, utils.PushLogEnv+"="+pushLog)
+	handler.ServeHTTP(w, backendRequest)
+
+	if audit.Pushes(auditLog, repo, pushLog) > 0 {
+		metrics.Push(repo)
+		mirror.Pushed(repo)
+	}
+}
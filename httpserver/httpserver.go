@@ -0,0 +1,120 @@
+// Package httpserver implements the optional http server used by pcompose
+package httpserver
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/antoniomika/pcompose/audit"
+	"github.com/antoniomika/pcompose/auth"
+	"github.com/antoniomika/pcompose/metrics"
+	"github.com/spf13/viper"
+	"golang.org/x/crypto/ssh"
+)
+
+// Start initializes the http server for pcompose if an http address is configured.
+func Start() {
+	address := viper.GetString("http-address")
+	if address == "" {
+		return
+	}
+
+	mux := http.NewServeMux()
+
+	if viper.GetBool("http-git") {
+		mux.HandleFunc(gitPrefix, handleGit)
+	}
+
+	if viper.GetBool("http-api") {
+		mux.HandleFunc(apiPrefix, handleAPI)
+	}
+
+	if viper.GetBool("http-dashboard") {
+		mux.HandleFunc(dashboardPrefix, handleDashboard)
+	}
+
+	if viper.GetBool("http-webhooks") {
+		mux.HandleFunc(webhooksPrefix, handleWebhook)
+	}
+
+	if viper.GetBool("http-metrics") {
+		mux.HandleFunc(metricsPath, handleMetrics)
+	}
+
+	server := &http.Server{
+		Addr:    address,
+		Handler: mux,
+	}
+
+	var err error
+
+	if viper.GetString("https-certificate") != "" && viper.GetString("https-private-key") != "" {
+		log.Println("Starting HTTPS service on address:", address)
+		err = server.ListenAndServeTLS(viper.GetString("https-certificate"), viper.GetString("https-private-key"))
+	} else {
+		log.Println("Starting HTTP service on address:", address)
+		err = server.ListenAndServe()
+	}
+
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// requestToken returns the access token of a request, either given as a bearer
+// token or as the password of basic authentication which is what git clients use.
+func requestToken(r *http.Request) string {
+	if _, password, ok := r.BasicAuth(); ok {
+		return password
+	}
+
+	authorization := r.Header.Get("Authorization")
+	if strings.HasPrefix(authorization, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(authorization, "Bearer "))
+	}
+
+	return ""
+}
+
+// authenticate returns the permissions of the identity making a request. Requests
+// without a token are anonymous, which is only allowed if authentication is disabled.
+func authenticate(r *http.Request) (*ssh.Permissions, bool) {
+	if requestToken(r) == "" {
+		return nil, !viper.GetBool("authentication")
+	}
+
+	return authenticateToken(r)
+}
+
+// authenticateToken returns the permissions of the identity a request has a token of.
+// Requests without a valid token are rejected, even if authentication is disabled.
+func authenticateToken(r *http.Request) (*ssh.Permissions, bool) {
+	token := requestToken(r)
+	if token == "" {
+		return nil, false
+	}
+
+	identity, ok := auth.TokenIdentity(token)
+	if !ok {
+		metrics.AuthFailure("http", "invalid_token")
+
+		audit.Log(audit.Entry{
+			Action:     audit.ActionAuthFailure,
+			RemoteAddr: r.RemoteAddr,
+			Method:     "token",
+			Target:     r.URL.Path,
+			Error:      "invalid token",
+		})
+
+		return nil, false
+	}
+
+	return auth.IdentityPermissions(identity), true
+}
+
+// requireAuthentication asks the client for credentials.
+func requireAuthentication(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", `Basic realm="pcompose"`)
+	http.Error(w, "authentication required", http.StatusUnauthorized)
+}
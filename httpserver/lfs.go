@@ -0,0 +1,256 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/antoniomika/pcompose/audit"
+	"github.com/antoniomika/pcompose/auth"
+	"github.com/antoniomika/pcompose/lfs"
+	"github.com/antoniomika/pcompose/project"
+	"github.com/spf13/viper"
+)
+
+// lfsObjectsPath separates the repository from the Git LFS API path, e.g. /git/user/httpbin.git/info/lfs/objects/batch.
+const lfsObjectsPath = "/info/lfs/objects"
+
+// maxLFSBatchSize is the largest batch request accepted.
+const maxLFSBatchSize = 10 << 20
+
+// lfsMediaType is the content type of Git LFS API requests and responses.
+const lfsMediaType = "application/vnd.git-lfs+json"
+
+// lfsBatchRequest is a request of the Git LFS batch API.
+type lfsBatchRequest struct {
+	Operation string      `json:"operation"`
+	Transfers []string    `json:"transfers,omitempty"`
+	Objects   []lfsObject `json:"objects"`
+	HashAlgo  string      `json:"hash_algo,omitempty"`
+}
+
+// lfsBatchResponse is a response of the Git LFS batch API.
+type lfsBatchResponse struct {
+	Transfer string      `json:"transfer"`
+	Objects  []lfsObject `json:"objects"`
+	HashAlgo string      `json:"hash_algo"`
+}
+
+// lfsObject is an object of a batch request and response.
+type lfsObject struct {
+	OID           string               `json:"oid"`
+	Size          int64                `json:"size"`
+	Authenticated bool                 `json:"authenticated,omitempty"`
+	Actions       map[string]lfsAction `json:"actions,omitempty"`
+	Error         *lfsError            `json:"error,omitempty"`
+}
+
+// lfsAction tells the client where to transfer an object to or from.
+type lfsAction struct {
+	Href      string            `json:"href"`
+	Header    map[string]string `json:"header,omitempty"`
+	ExpiresIn int               `json:"expires_in,omitempty"`
+}
+
+// lfsError is the error of a single object or a whole request.
+type lfsError struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message"`
+}
+
+// isLFSRequest returns whether a request under the git prefix is a Git LFS API request.
+func isLFSRequest(r *http.Request) bool {
+	return strings.Contains(r.URL.Path, ".git"+lfsObjectsPath)
+}
+
+// handleLFS serves the Git LFS batch API and the basic transfer adapter for the repositories served over HTTP.
+func handleLFS(w http.ResponseWriter, r *http.Request) {
+	repoPath, objectPath, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, gitPrefix), lfsObjectsPath)
+
+	repo, err := project.CleanRepoName(repoPath)
+	if err != nil {
+		writeLFSError(w, http.StatusNotFound, "repository not found")
+		return
+	}
+
+	var batch lfsBatchRequest
+
+	operation := lfs.OperationDownload
+
+	switch {
+	case objectPath == "/batch" && r.Method == http.MethodPost:
+		err := json.NewDecoder(io.LimitReader(r.Body, maxLFSBatchSize)).Decode(&batch)
+		if err != nil {
+			writeLFSError(w, http.StatusBadRequest, "invalid batch request")
+			return
+		}
+
+		operation = batch.Operation
+	case strings.HasPrefix(objectPath, "/") && r.Method == http.MethodGet:
+	case strings.HasPrefix(objectPath, "/") && r.Method == http.MethodPut:
+		operation = lfs.OperationUpload
+	default:
+		writeLFSError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	if operation != lfs.OperationUpload && operation != lfs.OperationDownload {
+		writeLFSError(w, http.StatusUnprocessableEntity, "unknown operation")
+		return
+	}
+
+	perms, ok := auth.LFSTokenPermissions(requestToken(r), repo, operation)
+	if !ok {
+		perms, ok = authenticate(r)
+	}
+
+	if !ok {
+		w.Header().Set("LFS-Authenticate", `Basic realm="pcompose"`)
+		requireAuthentication(w)
+		return
+	}
+
+	if !auth.Authorized(perms, repo) {
+		audit.Log(audit.Entry{
+			Action:     audit.ActionExec,
+			Identity:   auth.Identity(perms),
+			RemoteAddr: r.RemoteAddr,
+			Method:     "token",
+			Payload:    "git-lfs " + operation + " " + repo,
+			Error:      "access to " + repo + " denied",
+		})
+
+		writeLFSError(w, http.StatusForbidden, "access to "+repo+" denied")
+		return
+	}
+
+	repoDir, err := lfs.RepoDir(repo, operation)
+	if errors.Is(err, lfs.ErrRepositoryNotFound) {
+		writeLFSError(w, http.StatusNotFound, "repository not found")
+		return
+	} else if err != nil {
+		log.Println("Error initializing repository:", err)
+		writeLFSError(w, http.StatusInternalServerError, "unable to initialize repository")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		lfsBatch(w, r, repo, repoDir, batch)
+	case http.MethodGet:
+		lfsDownload(w, repoDir, strings.TrimPrefix(objectPath, "/"))
+	case http.MethodPut:
+		lfsUpload(w, r, repoDir, strings.TrimPrefix(objectPath, "/"))
+	}
+}
+
+// lfsBatch tells the client which objects to transfer and where to.
+func lfsBatch(w http.ResponseWriter, r *http.Request, repo string, repoDir string, batch lfsBatchRequest) {
+	if batch.HashAlgo != "" && batch.HashAlgo != "sha256" {
+		writeLFSError(w, http.StatusConflict, "unsupported hash algorithm")
+		return
+	}
+
+	baseURL := strings.TrimSuffix(viper.GetString("http-public-url"), "/") + gitPrefix + repo + ".git" + lfsObjectsPath + "/"
+
+	var header map[string]string
+	if authorization := r.Header.Get("Authorization"); authorization != "" {
+		header = map[string]string{"Authorization": authorization}
+	}
+
+	response := lfsBatchResponse{
+		Transfer: "basic",
+		Objects:  []lfsObject{},
+		HashAlgo: "sha256",
+	}
+
+	for _, object := range batch.Objects {
+		result := lfsObject{
+			OID:           object.OID,
+			Size:          object.Size,
+			Authenticated: true,
+		}
+
+		exists := lfs.Exists(repoDir, object.OID, object.Size)
+
+		switch {
+		case !lfs.ValidOID(object.OID) || object.Size < 0:
+			result.Error = &lfsError{Code: http.StatusUnprocessableEntity, Message: "invalid object"}
+		case batch.Operation == lfs.OperationDownload && !exists:
+			result.Error = &lfsError{Code: http.StatusNotFound, Message: "object not found"}
+		case batch.Operation == lfs.OperationDownload || !exists:
+			result.Actions = map[string]lfsAction{
+				batch.Operation: {
+					Href:      baseURL + object.OID,
+					Header:    header,
+					ExpiresIn: int(auth.LFSTokenDuration.Seconds()),
+				},
+			}
+		}
+
+		response.Objects = append(response.Objects, result)
+	}
+
+	w.Header().Set("Content-Type", lfsMediaType)
+
+	err := json.NewEncoder(w).Encode(response)
+	if err != nil {
+		log.Println("Error writing LFS batch response:", err)
+	}
+}
+
+// lfsDownload sends an object to the client.
+func lfsDownload(w http.ResponseWriter, repoDir string, oid string) {
+	file, size, err := lfs.Open(repoDir, oid)
+	if err != nil {
+		writeLFSError(w, http.StatusNotFound, "object not found")
+		return
+	}
+
+	defer file.Close()
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+
+	_, err = io.Copy(w, file)
+	if err != nil {
+		log.Println("Error sending LFS object:", err)
+	}
+}
+
+// lfsUpload stores an object sent by the client.
+func lfsUpload(w http.ResponseWriter, r *http.Request, repoDir string, oid string) {
+	if r.ContentLength < 0 {
+		writeLFSError(w, http.StatusLengthRequired, "content length required")
+		return
+	}
+
+	err := lfs.Put(repoDir, oid, r.ContentLength, r.Body)
+	if err != nil {
+		if errors.Is(err, lfs.ErrInvalidObject) {
+			writeLFSError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+
+		log.Println("Error storing LFS object:", err)
+		writeLFSError(w, http.StatusInternalServerError, "unable to store object")
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// writeLFSError writes an error response of the Git LFS API.
+func writeLFSError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", lfsMediaType)
+	w.WriteHeader(status)
+
+	err := json.NewEncoder(w).Encode(lfsError{Message: message})
+	if err != nil {
+		log.Println("Error writing LFS error:", err)
+	}
+}
@@ -0,0 +1,31 @@
+package httpserver
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/antoniomika/pcompose/auth"
+	"github.com/antoniomika/pcompose/metrics"
+	"github.com/antoniomika/pcompose/project"
+)
+
+// metricsPath is the path Prometheus metrics are served under.
+const metricsPath = "/metrics"
+
+// handleMetrics serves Prometheus metrics. Project metrics only include projects the identity can access.
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	perms, ok := authenticate(r)
+	if !ok {
+		requireAuthentication(w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	err := metrics.Write(w, func(p project.Project) bool {
+		return auth.Authorized(perms, p.Name)
+	})
+	if err != nil {
+		log.Println("Error writing metrics:", err)
+	}
+}
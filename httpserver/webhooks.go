@@ -0,0 +1,161 @@
+package httpserver
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/antoniomika/pcompose/audit"
+	"github.com/antoniomika/pcompose/metrics"
+	"github.com/antoniomika/pcompose/mirror"
+	"github.com/antoniomika/pcompose/project"
+)
+
+// webhooksPrefix is the path inbound push webhooks are served under, e.g. /webhooks/user/httpbin.
+const webhooksPrefix = "/webhooks/"
+
+// maxWebhookSize is the largest webhook body accepted, which matches the limit of GitHub.
+const maxWebhookSize = 25 << 20
+
+// webhookPayload holds the fields of GitHub, Gitea and GitLab push payloads that identify the pusher.
+type webhookPayload struct {
+	Pusher struct {
+		Name     string `json:"name"`
+		Login    string `json:"login"`
+		Username string `json:"username"`
+	} `json:"pusher"`
+	UserUsername string `json:"user_username"`
+}
+
+// webhook is an inbound webhook request after its provider was detected.
+type webhook struct {
+	provider string
+	event    string
+	verified bool
+	push     bool
+}
+
+// handleWebhook fetches a mirrored project when a signed push webhook from its git host arrives.
+func handleWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Mirrors are created by their first fetch, so the project doesn't have to exist yet.
+	name, err := project.CleanName(strings.TrimPrefix(r.URL.Path, webhooksPrefix))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	p := project.New(name)
+
+	config := p.Config()
+	if p.Deployment != "" || config.IsPattern() || config.Mirror.URL == "" || config.Mirror.Secret == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookSize))
+	if err != nil {
+		http.Error(w, "unable to read body", http.StatusBadRequest)
+		return
+	}
+
+	hook := parseWebhook(r, body, config.Mirror.Secret)
+
+	auditLog := audit.Entry{
+		Action:     audit.ActionWebhook,
+		RemoteAddr: r.RemoteAddr,
+		Method:     hook.provider,
+		Target:     p.Name,
+		Payload:    hook.event,
+	}
+
+	if !hook.verified {
+		metrics.AuthFailure("http", "invalid_webhook_signature")
+
+		auditLog.Error = "invalid webhook signature"
+		audit.Log(auditLog)
+
+		http.Error(w, auditLog.Error, http.StatusUnauthorized)
+		return
+	}
+
+	if !hook.push {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	var payload webhookPayload
+
+	// The pusher is informational only, so an unexpected payload still triggers a fetch.
+	_ = json.Unmarshal(body, &payload)
+
+	pusher := payload.UserUsername
+	for _, name := range []string{payload.Pusher.Login, payload.Pusher.Username, payload.Pusher.Name} {
+		if pusher == "" {
+			pusher = name
+		}
+	}
+
+	auditLog.Identity = pusher
+	audit.Log(auditLog)
+
+	mirror.Trigger(p, pusher)
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// parseWebhook detects the git host that sent a webhook and verifies its signature.
+func parseWebhook(r *http.Request, body []byte, secret string) webhook {
+	switch {
+	case r.Header.Get("X-Gitlab-Event") != "":
+		event := r.Header.Get("X-Gitlab-Event")
+
+		return webhook{
+			provider: "gitlab",
+			event:    event,
+			verified: subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Gitlab-Token")), []byte(secret)) == 1,
+			push:     event == "Push Hook" || event == "Tag Push Hook",
+		}
+	case r.Header.Get("X-Gitea-Event") != "":
+		// Gitea also sends GitHub headers, so it has to be detected first.
+		event := r.Header.Get("X-Gitea-Event")
+
+		return webhook{
+			provider: "gitea",
+			event:    event,
+			verified: validSignature(r.Header.Get("X-Gitea-Signature"), body, secret),
+			push:     event == "push",
+		}
+	default:
+		event := r.Header.Get("X-GitHub-Event")
+
+		return webhook{
+			provider: "github",
+			event:    event,
+			verified: validSignature(strings.TrimPrefix(r.Header.Get("X-Hub-Signature-256"), "sha256="), body, secret),
+			push:     event == "push",
+		}
+	}
+}
+
+// validSignature returns whether a hex encoded HMAC-SHA256 signature of the body is valid.
+func validSignature(signature string, body []byte, secret string) bool {
+	decoded, err := hex.DecodeString(signature)
+	if err != nil || len(decoded) == 0 {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+
+	return hmac.Equal(decoded, mac.Sum(nil))
+}
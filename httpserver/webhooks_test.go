@@ -0,0 +1,131 @@
+package httpserver
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// testSignature returns the hex encoded HMAC-SHA256 signature of a body.
+func testSignature(secret string, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestParseWebhook(t *testing.T) {
+	const (
+		secret = "s3cret"
+		body   = `{"ref":"refs/heads/main"}`
+	)
+
+	tests := []struct {
+		name     string
+		headers  map[string]string
+		provider string
+		verified bool
+		push     bool
+	}{
+		{
+			name:     "github push",
+			headers:  map[string]string{"X-GitHub-Event": "push", "X-Hub-Signature-256": "sha256=" + testSignature(secret, body)},
+			provider: "github",
+			verified: true,
+			push:     true,
+		},
+		{
+			name:     "github ping",
+			headers:  map[string]string{"X-GitHub-Event": "ping", "X-Hub-Signature-256": "sha256=" + testSignature(secret, body)},
+			provider: "github",
+			verified: true,
+		},
+		{
+			name:     "github wrong secret",
+			headers:  map[string]string{"X-GitHub-Event": "push", "X-Hub-Signature-256": "sha256=" + testSignature("wrong", body)},
+			provider: "github",
+			push:     true,
+		},
+		{
+			name:     "github other body",
+			headers:  map[string]string{"X-GitHub-Event": "push", "X-Hub-Signature-256": "sha256=" + testSignature(secret, body+" ")},
+			provider: "github",
+			push:     true,
+		},
+		{
+			name:     "github sha1 signature only",
+			headers:  map[string]string{"X-GitHub-Event": "push", "X-Hub-Signature": "sha1=0123"},
+			provider: "github",
+			push:     true,
+		},
+		{
+			name:     "github invalid hex",
+			headers:  map[string]string{"X-GitHub-Event": "push", "X-Hub-Signature-256": "sha256=zz"},
+			provider: "github",
+			push:     true,
+		},
+		{
+			name:     "gitea push",
+			headers:  map[string]string{"X-Gitea-Event": "push", "X-GitHub-Event": "push", "X-Gitea-Signature": testSignature(secret, body)},
+			provider: "gitea",
+			verified: true,
+			push:     true,
+		},
+		{
+			name:     "gitea with only a github signature",
+			headers:  map[string]string{"X-Gitea-Event": "push", "X-GitHub-Event": "push", "X-Hub-Signature-256": "sha256=" + testSignature(secret, body)},
+			provider: "gitea",
+			push:     true,
+		},
+		{
+			name:     "gitlab push",
+			headers:  map[string]string{"X-Gitlab-Event": "Push Hook", "X-Gitlab-Token": secret},
+			provider: "gitlab",
+			verified: true,
+			push:     true,
+		},
+		{
+			name:     "gitlab tag push",
+			headers:  map[string]string{"X-Gitlab-Event": "Tag Push Hook", "X-Gitlab-Token": secret},
+			provider: "gitlab",
+			verified: true,
+			push:     true,
+		},
+		{
+			name:     "gitlab wrong token",
+			headers:  map[string]string{"X-Gitlab-Event": "Push Hook", "X-Gitlab-Token": "wrong"},
+			provider: "gitlab",
+			push:     true,
+		},
+		{
+			name:     "unsigned",
+			headers:  map[string]string{"X-GitHub-Event": "push"},
+			provider: "github",
+			push:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", webhooksPrefix+"alice/app", strings.NewReader(body))
+			for key, value := range tt.headers {
+				r.Header.Set(key, value)
+			}
+
+			hook := parseWebhook(r, []byte(body), secret)
+
+			if hook.provider != tt.provider || hook.verified != tt.verified || hook.push != tt.push {
+				t.Errorf("parseWebhook() = %+v, want provider %s, verified %v, push %v", hook, tt.provider, tt.verified, tt.push)
+			}
+		})
+	}
+}
+
+func TestValidSignatureEmptySecret(t *testing.T) {
+	if validSignature("", []byte("body"), "") {
+		t.Error("validSignature() = true for an empty signature")
+	}
+}
@@ -0,0 +1,41 @@
+// Package gittest runs git in tests, isolated from the git configuration of the machine.
+package gittest
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// Setup skips the test if git is not available and isolates git from the global and system
+// configuration, committing as Alice.
+func Setup(t *testing.T) {
+	t.Helper()
+
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not available")
+	}
+
+	t.Setenv("GIT_CONFIG_GLOBAL", os.DevNull)
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	t.Setenv("GIT_AUTHOR_NAME", "Alice")
+	t.Setenv("GIT_AUTHOR_EMAIL", "alice@example.com")
+	t.Setenv("GIT_COMMITTER_NAME", "Alice")
+	t.Setenv("GIT_COMMITTER_EMAIL", "alice@example.com")
+}
+
+// Run runs a git command in dir and returns its trimmed output, failing the test if it fails.
+func Run(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s failed: %v: %s", strings.Join(args, " "), err, output)
+	}
+
+	return strings.TrimSpace(string(output))
+}
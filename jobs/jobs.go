@@ -0,0 +1,430 @@
+// Package jobs implements running scheduled commands of projects used by pcompose
+package jobs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"regexp"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/antoniomika/pcompose/deploy"
+	"github.com/antoniomika/pcompose/notify"
+	"github.com/antoniomika/pcompose/project"
+)
+
+const (
+	// TriggerSchedule is used for runs started by the schedule of the job.
+	TriggerSchedule = "schedule"
+
+	// TriggerManual is used for runs started using the pcompose run-job command or the API.
+	TriggerManual = "manual"
+
+	// OverlapSkip skips a run if the previous run of the job is still running.
+	OverlapSkip = "skip"
+
+	// OverlapQueue starts a run once the previous run finished. At most one run waits.
+	OverlapQueue = "queue"
+
+	// OverlapAllow runs the job concurrently with its previous runs.
+	OverlapAllow = "allow"
+)
+
+// timeoutGracePeriod is how long a run has to stop after being interrupted for exceeding its timeout.
+const timeoutGracePeriod = 10 * time.Second
+
+var (
+	// ErrJobNotFound is returned when a project has no job with a name.
+	ErrJobNotFound = errors.New("job not found")
+
+	// ErrRunning is returned when a run is skipped because of the overlap policy of the job.
+	ErrRunning = errors.New("the previous run is still running")
+
+	// ErrNotDeployed is returned when a job is run before the project was deployed.
+	ErrNotDeployed = errors.New("the project has not been deployed yet")
+
+	// nameRegexp matches valid job names, which are used as directory names.
+	nameRegexp = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]*$`)
+
+	// locks enforce the overlap policies, keyed by project and job name.
+	locks   = map[string]*jobLock{}
+	locksMu sync.Mutex
+)
+
+// jobLock is held while a job runs, unless its runs are allowed to overlap.
+type jobLock struct {
+	mu      sync.Mutex
+	waiting int32
+}
+
+// Options describe a run of a job.
+type Options struct {
+	// ID is the ID of the run. A new ID is generated if empty.
+	ID string
+
+	// Trigger is what started the run, one of the Trigger constants.
+	Trigger string
+
+	// Output receives the output of the command.
+	Output io.Writer
+}
+
+// Status describes a job and its most recent run.
+type Status struct {
+	Name     string     `json:"name"`
+	Schedule string     `json:"schedule"`
+	Service  string     `json:"service"`
+	Command  []string   `json:"command,omitempty"`
+	Overlap  string     `json:"overlap"`
+	NextRun  *time.Time `json:"next_run,omitempty"`
+	LastRun  *Run       `json:"last_run,omitempty"`
+	Error    string     `json:"error,omitempty"`
+}
+
+// validName returns whether a job name is valid.
+func validName(name string) bool {
+	return nameRegexp.MatchString(name)
+}
+
+// Find returns the configuration of a job of a project.
+func Find(p project.Project, name string) (project.JobConfig, error) {
+	for _, job := range p.Config().Jobs {
+		if job.Name == name && validName(name) {
+			return job, nil
+		}
+	}
+
+	return project.JobConfig{}, ErrJobNotFound
+}
+
+// validate returns why a job can't run, if it can't.
+func validate(job project.JobConfig) error {
+	if !validName(job.Name) {
+		return fmt.Errorf("invalid job name %q", job.Name)
+	}
+
+	if job.Service == "" {
+		return fmt.Errorf("job %s has no service", job.Name)
+	}
+
+	switch job.Overlap {
+	case "", OverlapSkip, OverlapQueue, OverlapAllow:
+	default:
+		return fmt.Errorf("job %s has an unknown overlap policy %q", job.Name, job.Overlap)
+	}
+
+	schedule, err := ParseSchedule(job.Schedule)
+	if err != nil {
+		return err
+	}
+
+	if schedule.Next(time.Now()).IsZero() {
+		return fmt.Errorf("schedule %q of job %s never matches", job.Schedule, job.Name)
+	}
+
+	return nil
+}
+
+// Statuses returns the jobs of a project with their next and last runs.
+func Statuses(p project.Project) []Status {
+	var statuses []Status
+
+	for _, job := range p.Config().Jobs {
+		status := Status{
+			Name:     job.Name,
+			Schedule: job.Schedule,
+			Service:  job.Service,
+			Command:  job.Command,
+			Overlap:  job.Overlap,
+		}
+
+		if status.Overlap == "" {
+			status.Overlap = OverlapSkip
+		}
+
+		err := validate(job)
+		if err != nil {
+			status.Error = err.Error()
+			statuses = append(statuses, status)
+
+			continue
+		}
+
+		schedule, _ := ParseSchedule(job.Schedule)
+		if next := schedule.Next(time.Now()); !next.IsZero() {
+			status.NextRun = &next
+		}
+
+		runs, err := Runs(p, job.Name)
+		if err != nil {
+			log.Println("Error listing job runs:", err)
+		} else if len(runs) > 0 {
+			status.LastRun = runs[0]
+		}
+
+		statuses = append(statuses, status)
+	}
+
+	return statuses
+}
+
+// acquire applies the overlap policy of a job and returns a function releasing the job
+// once the run finished, or ErrRunning if the run has to be skipped.
+func acquire(p project.Project, job project.JobConfig) (func(), error) {
+	if job.Overlap == OverlapAllow {
+		return func() {}, nil
+	}
+
+	locksMu.Lock()
+	lock, ok := locks[p.FullName()+"/"+job.Name]
+	if !ok {
+		lock = &jobLock{}
+		locks[p.FullName()+"/"+job.Name] = lock
+	}
+	locksMu.Unlock()
+
+	if lock.mu.TryLock() {
+		return lock.mu.Unlock, nil
+	}
+
+	if job.Overlap != OverlapQueue || !atomic.CompareAndSwapInt32(&lock.waiting, 0, 1) {
+		return nil, ErrRunning
+	}
+
+	lock.mu.Lock()
+	atomic.StoreInt32(&lock.waiting, 0)
+
+	return lock.mu.Unlock, nil
+}
+
+// Execute runs a job in a one-off container of the current release of a project, records
+// the run in its history and sends a notification if it failed. Runs wait for deploys, backups
+// and restores of the project, which in turn wait for running jobs.
+func Execute(p project.Project, job project.JobConfig, opts Options) (*Run, error) {
+	if opts.Output == nil {
+		opts.Output = io.Discard
+	}
+
+	if opts.ID == "" {
+		opts.ID = deploy.NewID()
+	}
+
+	err := validate(job)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := deploy.CurrentRevision(p); err != nil {
+		return nil, ErrNotDeployed
+	}
+
+	release, err := acquire(p, job)
+	if err != nil {
+		return nil, err
+	}
+
+	defer release()
+
+	unlock, err := deploy.RLock(p)
+	if err != nil {
+		return nil, fmt.Errorf("error locking project: %w", err)
+	}
+
+	defer unlock()
+
+	run := &Run{
+		ID:      opts.ID,
+		Project: p.FullName(),
+		Job:     job.Name,
+		Trigger: opts.Trigger,
+		Status:  deploy.StatusRunning,
+		Start:   time.Now(),
+	}
+
+	err = saveRun(p, run)
+	if err != nil {
+		log.Println("Error saving job run:", err)
+	}
+
+	output, closeLog, err := openLog(p, run, opts.Output)
+	if err != nil {
+		log.Println("Error creating job log:", err)
+	}
+
+	defer closeLog()
+
+	fmt.Fprintf(output, "-----> Run %s of job %s of %s\n", run.ID, job.Name, p.FullName())
+
+	exitCode, err := execute(p, job, output)
+
+	end := time.Now()
+	run.End = &end
+	run.ExitCode = exitCode
+	run.Status = deploy.StatusSucceeded
+
+	if err != nil {
+		run.Status = deploy.StatusFailed
+		run.Error = err.Error()
+	}
+
+	saveErr := saveRun(p, run)
+	if saveErr != nil {
+		log.Println("Error saving job run:", saveErr)
+	}
+
+	if err != nil {
+		fmt.Fprintf(output, "-----> Job failed after %s: %s\n", run.Duration().Round(time.Second), err)
+
+		notify.Send(notify.Event{
+			Event:    notify.EventJobFailed,
+			Job:      run.Job,
+			RunID:    run.ID,
+			Project:  run.Project,
+			Trigger:  run.Trigger,
+			Error:    run.Error,
+			Duration: run.Duration(),
+		})
+	} else {
+		fmt.Fprintf(output, "-----> Job finished in %s\n", run.Duration().Round(time.Second))
+	}
+
+	pruneRuns(p, job.Name)
+
+	return run, err
+}
+
+// execute runs docker-compose run for a job, interrupting it once its timeout passed,
+// and returns the exit code of the command if it ran.
+func execute(p project.Project, job project.JobConfig, output io.Writer) (*int, error) {
+	args := append([]string{"run", "--rm", "-T", job.Service}, job.Command...)
+
+	cmd := deploy.Compose(p, args...)
+	cmd.Stdout = output
+	cmd.Stderr = output
+
+	err := cmd.Start()
+	if err != nil {
+		return nil, fmt.Errorf("error running docker-compose run: %w", err)
+	}
+
+	var timedOut int32
+
+	if job.Timeout > 0 {
+		timer := time.AfterFunc(job.Timeout, func() {
+			atomic.StoreInt32(&timedOut, 1)
+
+			// docker-compose stops the container when interrupted.
+			_ = cmd.Process.Signal(os.Interrupt)
+
+			time.AfterFunc(timeoutGracePeriod, func() {
+				_ = cmd.Process.Kill()
+			})
+		})
+
+		defer timer.Stop()
+	}
+
+	err = cmd.Wait()
+
+	var exitCode *int
+	if cmd.ProcessState != nil && cmd.ProcessState.ExitCode() >= 0 {
+		code := cmd.ProcessState.ExitCode()
+		exitCode = &code
+	}
+
+	if atomic.LoadInt32(&timedOut) == 1 {
+		return exitCode, fmt.Errorf("timed out after %s", job.Timeout)
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitCode != nil {
+		return exitCode, fmt.Errorf("command exited with code %d", *exitCode)
+	}
+
+	if err != nil {
+		return exitCode, fmt.Errorf("error running docker-compose run: %w", err)
+	}
+
+	return exitCode, nil
+}
+
+// Start runs the jobs of every project according to their schedules. Schedules are
+// checked at the start of every minute, and changes to the config file apply to the next run.
+func Start() {
+	next := map[string]time.Time{}
+
+	for {
+		now := time.Now()
+
+		projects, err := project.List()
+		if err != nil {
+			log.Println("Error listing projects:", err)
+		}
+
+		seen := map[string]bool{}
+
+		for _, p := range projects {
+			for _, job := range p.Config().Jobs {
+				key := fmt.Sprintf("%s/%s/%s", p.FullName(), job.Name, job.Schedule)
+				seen[key] = true
+
+				nextRun, ok := next[key]
+				if !ok {
+					// Jobs are scheduled from the first time they are seen, so neither a
+					// restart nor a config change runs them immediately.
+					err := validate(job)
+					if err != nil {
+						log.Printf("Error scheduling job of %s: %s", p.FullName(), err)
+					} else {
+						schedule, _ := ParseSchedule(job.Schedule)
+						nextRun = schedule.Next(now)
+					}
+
+					next[key] = nextRun
+
+					continue
+				}
+
+				if nextRun.IsZero() || now.Before(nextRun) {
+					continue
+				}
+
+				schedule, _ := ParseSchedule(job.Schedule)
+				next[key] = schedule.Next(now)
+
+				go runScheduled(p, job)
+			}
+		}
+
+		for key := range next {
+			if !seen[key] {
+				delete(next, key)
+			}
+		}
+
+		time.Sleep(time.Until(now.Truncate(time.Minute).Add(time.Minute)))
+	}
+}
+
+// runScheduled runs a job because its schedule is due.
+func runScheduled(p project.Project, job project.JobConfig) {
+	run, err := Execute(p, job, Options{Trigger: TriggerSchedule})
+
+	switch {
+	case errors.Is(err, ErrNotDeployed):
+		return
+	case errors.Is(err, ErrRunning):
+		log.Printf("Skipping run of job %s of %s: %s", job.Name, p.FullName(), err)
+	case err != nil && run != nil:
+		log.Printf("Run %s of job %s of %s failed: %s", run.ID, job.Name, p.FullName(), err)
+	case err != nil:
+		log.Printf("Error running job %s of %s: %s", job.Name, p.FullName(), err)
+	default:
+		log.Printf("Run %s of job %s of %s finished in %s", run.ID, job.Name, p.FullName(), run.Duration().Round(time.Second))
+	}
+}
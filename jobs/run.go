@@ -0,0 +1,193 @@
+package jobs
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/antoniomika/pcompose/deploy"
+	"github.com/antoniomika/pcompose/project"
+	"github.com/spf13/viper"
+)
+
+// ErrRunNotFound is returned when a run of a job doesn't exist.
+var ErrRunNotFound = errors.New("job run not found")
+
+// Run is the history entry of a single run of a job.
+type Run struct {
+	ID       string     `json:"id"`
+	Project  string     `json:"project"`
+	Job      string     `json:"job"`
+	Trigger  string     `json:"trigger"`
+	Status   string     `json:"status"`
+	Error    string     `json:"error,omitempty"`
+	ExitCode *int       `json:"exit_code,omitempty"`
+	Start    time.Time  `json:"start"`
+	End      *time.Time `json:"end,omitempty"`
+}
+
+// Duration returns how long the run took, or has been running for.
+func (r Run) Duration() time.Duration {
+	if r.End == nil {
+		return time.Since(r.Start)
+	}
+
+	return r.End.Sub(r.Start)
+}
+
+// runsDir returns the directory the runs of a job are stored in.
+func runsDir(p project.Project, job string) string {
+	return path.Join(p.MetadataDir(), "jobs", job)
+}
+
+// saveRun atomically writes a run record.
+func saveRun(p project.Project, run *Run) error {
+	err := os.MkdirAll(runsDir(p, run.Job), os.FileMode(0755))
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(run, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	runFile := path.Join(runsDir(p, run.Job), run.ID+".json")
+
+	err = os.WriteFile(runFile+".tmp", data, os.FileMode(0644))
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(runFile+".tmp", runFile)
+}
+
+// openLog creates the log file of a run and returns a writer that streams to output and
+// appends timestamped lines to the log file, and a function closing it.
+func openLog(p project.Project, run *Run, output io.Writer) (io.Writer, func(), error) {
+	file, err := os.OpenFile(path.Join(runsDir(p, run.Job), run.ID+".log"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, os.FileMode(0644))
+	if err != nil {
+		return output, func() {}, err
+	}
+
+	return deploy.NewLogWriter(output, file), func() {
+		file.Close()
+	}, nil
+}
+
+// GetRun returns a single run of a job.
+func GetRun(p project.Project, job string, id string) (*Run, error) {
+	if !validName(job) || id == "" || strings.ContainsAny(id, "/.") {
+		return nil, ErrRunNotFound
+	}
+
+	data, err := os.ReadFile(path.Join(runsDir(p, job), id+".json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrRunNotFound
+		}
+
+		return nil, err
+	}
+
+	run := &Run{}
+
+	err = json.Unmarshal(data, run)
+	if err != nil {
+		return nil, err
+	}
+
+	return run, nil
+}
+
+// Runs returns the run history of a job, newest first.
+func Runs(p project.Project, job string) ([]*Run, error) {
+	if !validName(job) {
+		return nil, ErrJobNotFound
+	}
+
+	entries, err := os.ReadDir(runsDir(p, job))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	var runs []*Run
+
+	for _, entry := range entries {
+		if entry.IsDir() || path.Ext(entry.Name()) != ".json" {
+			continue
+		}
+
+		run, err := GetRun(p, job, strings.TrimSuffix(entry.Name(), ".json"))
+		if err != nil {
+			continue
+		}
+
+		runs = append(runs, run)
+	}
+
+	sort.Slice(runs, func(i, j int) bool {
+		return runs[i].ID > runs[j].ID
+	})
+
+	return runs, nil
+}
+
+// ReadLog returns the timestamped output of a run.
+func ReadLog(p project.Project, job string, id string) ([]byte, error) {
+	if !validName(job) || id == "" || strings.ContainsAny(id, "/.") {
+		return nil, ErrRunNotFound
+	}
+
+	data, err := os.ReadFile(path.Join(runsDir(p, job), id+".log"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrRunNotFound
+		}
+
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// pruneRuns removes the oldest runs of a job exceeding job-keep-runs.
+func pruneRuns(p project.Project, job string) {
+	keep := viper.GetInt("job-keep-runs")
+	if keep <= 0 {
+		return
+	}
+
+	runs, err := Runs(p, job)
+	if err != nil {
+		log.Println("Error listing job runs:", err)
+		return
+	}
+
+	if len(runs) <= keep {
+		return
+	}
+
+	for _, run := range runs[keep:] {
+		if run.End == nil {
+			continue
+		}
+
+		for _, ext := range []string{".json", ".log"} {
+			err := os.Remove(path.Join(runsDir(p, job), run.ID+ext))
+			if err != nil && !os.IsNotExist(err) {
+				log.Println("Error removing job run:", err)
+			}
+		}
+	}
+}
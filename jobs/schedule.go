@@ -0,0 +1,209 @@
+package jobs
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// scheduleDescriptors are the shorthands accepted instead of the five fields of a cron expression.
+var scheduleDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// monthNames and dayNames can be used in the month and day of week fields.
+var (
+	monthNames = []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+	dayNames   = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+)
+
+// Schedule is a parsed cron expression. Each field is a bit set of the values it matches.
+type Schedule struct {
+	minute, hour, dom, month, dow uint64
+
+	// domStar and dowStar record whether the day fields were *, since cron runs a job on
+	// days matching either field if both are restricted.
+	domStar, dowStar bool
+}
+
+// ParseSchedule parses a cron expression with the five fields minute, hour, day of month,
+// month and day of week, e.g. 30 2 * * 1-5, or one of the @daily style shorthands.
+func ParseSchedule(expression string) (Schedule, error) {
+	expression = strings.TrimSpace(expression)
+
+	if descriptor, ok := scheduleDescriptors[strings.ToLower(expression)]; ok {
+		expression = descriptor
+	}
+
+	fields := strings.Fields(expression)
+	if len(fields) != 5 {
+		return Schedule{}, fmt.Errorf("invalid schedule %q: expected 5 fields, got %d", expression, len(fields))
+	}
+
+	var (
+		s   Schedule
+		err error
+	)
+
+	parsers := []struct {
+		set      *uint64
+		min, max int
+		names    []string
+	}{
+		{&s.minute, 0, 59, nil},
+		{&s.hour, 0, 23, nil},
+		{&s.dom, 1, 31, nil},
+		{&s.month, 1, 12, monthNames},
+		{&s.dow, 0, 7, dayNames},
+	}
+
+	for i, parser := range parsers {
+		*parser.set, err = parseField(fields[i], parser.min, parser.max, parser.names)
+		if err != nil {
+			return Schedule{}, fmt.Errorf("invalid schedule %q: %w", expression, err)
+		}
+	}
+
+	// Both 0 and 7 are Sunday.
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+
+	s.domStar = strings.HasPrefix(fields[2], "*")
+	s.dowStar = strings.HasPrefix(fields[4], "*")
+
+	return s, nil
+}
+
+// parseField parses a comma separated list of values, ranges and steps, e.g. 1-5,*/15.
+func parseField(field string, min int, max int, names []string) (uint64, error) {
+	var set uint64
+
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+
+		step := 1
+		if hasStep {
+			var err error
+
+			step, err = strconv.Atoi(stepPart)
+			if err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+		}
+
+		var start, end int
+
+		switch {
+		case rangePart == "*":
+			start, end = min, max
+		case strings.Contains(rangePart, "-"):
+			startPart, endPart, _ := strings.Cut(rangePart, "-")
+
+			var err error
+
+			start, err = parseValue(startPart, min, max, names)
+			if err != nil {
+				return 0, err
+			}
+
+			end, err = parseValue(endPart, min, max, names)
+			if err != nil {
+				return 0, err
+			}
+
+			if end < start {
+				return 0, fmt.Errorf("invalid range %q", rangePart)
+			}
+		default:
+			var err error
+
+			start, err = parseValue(rangePart, min, max, names)
+			if err != nil {
+				return 0, err
+			}
+
+			end = start
+			if hasStep {
+				end = max
+			}
+		}
+
+		for value := start; value <= end; value += step {
+			set |= 1 << uint(value)
+		}
+	}
+
+	return set, nil
+}
+
+// parseValue parses a single number or name of a field.
+func parseValue(value string, min int, max int, names []string) (int, error) {
+	for i, name := range names {
+		if strings.EqualFold(value, name) {
+			// Month names start at 1, day names at 0.
+			return i + min, nil
+		}
+	}
+
+	number, err := strconv.Atoi(value)
+	if err != nil || number < min || number > max {
+		return 0, fmt.Errorf("invalid value %q, expected %d-%d", value, min, max)
+	}
+
+	return number, nil
+}
+
+// Next returns the first time after t the schedule matches, in the location of t. It returns
+// the zero time if the schedule never matches, e.g. for February 30th.
+func (s Schedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+
+	// Every combination of month and day recurs within a few years, including leap days.
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+
+		if !s.matchesDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+
+		return t
+	}
+
+	return time.Time{}
+}
+
+// matchesDay returns whether the day fields match the day of t.
+func (s Schedule) matchesDay(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+
+	if s.domStar || s.dowStar {
+		return dom && dow
+	}
+
+	return dom || dow
+}
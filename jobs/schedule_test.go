@@ -0,0 +1,92 @@
+package jobs
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	tests := []struct {
+		expression string
+		wantErr    bool
+	}{
+		{expression: "* * * * *"},
+		{expression: "30 2 * * 1-5"},
+		{expression: "*/15 0-6/2 1,15 * *"},
+		{expression: "0 0 * jan,JUL mon-fri"},
+		{expression: "0 0 * * 7"},
+		{expression: "@daily"},
+		{expression: "@Weekly"},
+		{expression: "* * * *", wantErr: true},
+		{expression: "* * * * * *", wantErr: true},
+		{expression: "60 * * * *", wantErr: true},
+		{expression: "* 24 * * *", wantErr: true},
+		{expression: "* * 0 * *", wantErr: true},
+		{expression: "* * * 13 *", wantErr: true},
+		{expression: "* * * * 8", wantErr: true},
+		{expression: "*/0 * * * *", wantErr: true},
+		{expression: "5-1 * * * *", wantErr: true},
+		{expression: "* * * foo *", wantErr: true},
+		{expression: "* * * * monday", wantErr: true},
+		{expression: "@reboot", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expression, func(t *testing.T) {
+			_, err := ParseSchedule(tt.expression)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseSchedule() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestScheduleNext(t *testing.T) {
+	date := func(year int, month time.Month, day int, hour int, minute int) time.Time {
+		return time.Date(year, month, day, hour, minute, 0, 0, time.UTC)
+	}
+
+	// January 15th 2024 is a Monday.
+	monday := date(2024, time.January, 15, 10, 30)
+
+	tests := []struct {
+		name       string
+		expression string
+		from       time.Time
+		want       time.Time
+	}{
+		{name: "every minute", expression: "* * * * *", from: monday.Add(20 * time.Second), want: date(2024, time.January, 15, 10, 31)},
+		{name: "step", expression: "*/15 * * * *", from: monday, want: date(2024, time.January, 15, 10, 45)},
+		{name: "strictly after", expression: "*/15 * * * *", from: date(2024, time.January, 15, 10, 45), want: date(2024, time.January, 15, 11, 0)},
+		{name: "step from a value", expression: "5/20 * * * *", from: monday, want: date(2024, time.January, 15, 10, 45)},
+		{name: "next day", expression: "0 2 * * *", from: monday, want: date(2024, time.January, 16, 2, 0)},
+		{name: "weekdays over a weekend", expression: "30 2 * * 1-5", from: date(2024, time.January, 19, 10, 30), want: date(2024, time.January, 22, 2, 30)},
+		{name: "sunday as 0", expression: "0 0 * * 0", from: monday, want: date(2024, time.January, 21, 0, 0)},
+		{name: "sunday as 7", expression: "0 0 * * 7", from: monday, want: date(2024, time.January, 21, 0, 0)},
+		{name: "names", expression: "0 12 * jan,jul mon", from: date(2024, time.January, 30, 0, 0), want: date(2024, time.July, 1, 12, 0)},
+		{name: "shorthand", expression: "@hourly", from: monday, want: date(2024, time.January, 15, 11, 0)},
+		{name: "day of month only", expression: "0 0 13 * *", from: date(2024, time.February, 10, 0, 0), want: date(2024, time.February, 13, 0, 0)},
+		{name: "day of week only", expression: "0 0 * * 5", from: date(2024, time.February, 10, 0, 0), want: date(2024, time.February, 16, 0, 0)},
+		{name: "day of month or day of week", expression: "0 0 13 * 5", from: date(2024, time.February, 10, 0, 0), want: date(2024, time.February, 13, 0, 0)},
+		{name: "day of week or day of month", expression: "0 0 20 * 5", from: date(2024, time.February, 10, 0, 0), want: date(2024, time.February, 16, 0, 0)},
+		{name: "restricted day of week with star day of month step", expression: "0 0 */1 * 5", from: date(2024, time.February, 10, 0, 0), want: date(2024, time.February, 16, 0, 0)},
+		{name: "skips short months", expression: "0 0 31 * *", from: date(2024, time.February, 1, 0, 0), want: date(2024, time.March, 31, 0, 0)},
+		{name: "leap day", expression: "0 0 29 2 *", from: date(2024, time.March, 1, 0, 0), want: date(2028, time.February, 29, 0, 0)},
+		{name: "end of year", expression: "0 0 1 1 *", from: date(2024, time.December, 31, 23, 59), want: date(2025, time.January, 1, 0, 0)},
+		{name: "february 30th", expression: "0 0 30 2 *", from: monday},
+		{name: "april 31st", expression: "0 0 31 4 *", from: monday},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schedule, err := ParseSchedule(tt.expression)
+			if err != nil {
+				t.Fatalf("ParseSchedule(%q) error = %v", tt.expression, err)
+			}
+
+			if got := schedule.Next(tt.from); !got.Equal(tt.want) {
+				t.Errorf("Next(%s) = %s, want %s", tt.from, got, tt.want)
+			}
+		})
+	}
+}
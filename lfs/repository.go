@@ -0,0 +1,28 @@
+package lfs
+
+import (
+	"errors"
+	"os"
+
+	"github.com/antoniomika/pcompose/project"
+)
+
+// ErrRepositoryNotFound is returned when objects are downloaded from a repository that doesn't exist.
+var ErrRepositoryNotFound = errors.New("repository not found")
+
+// RepoDir returns the directory of the repository objects are transferred for.
+func RepoDir(repo string, operation string) (string, error) {
+	repoDir := project.RepoDir(repo)
+
+	// git-lfs uploads objects before pushing, so the first push creates the repository.
+	if operation == OperationUpload {
+		err := project.InitRepository(repoDir)
+		if err != nil {
+			return "", err
+		}
+	} else if _, err := os.Stat(repoDir); os.IsNotExist(err) {
+		return "", ErrRepositoryNotFound
+	}
+
+	return repoDir, nil
+}
@@ -0,0 +1,114 @@
+// Package lfs implements storing Git LFS objects and serving them over SSH used by pcompose
+package lfs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"regexp"
+)
+
+const (
+	// OperationUpload is the operation of clients pushing objects.
+	OperationUpload = "upload"
+
+	// OperationDownload is the operation of clients fetching objects.
+	OperationDownload = "download"
+)
+
+// ErrInvalidObject is returned when an object ID is invalid or uploaded data doesn't match it.
+var ErrInvalidObject = errors.New("invalid object")
+
+// oidRegexp matches valid object IDs, which are SHA-256 hashes of the object content.
+var oidRegexp = regexp.MustCompile("^[0-9a-f]{64}$")
+
+// ValidOID returns whether an object ID is valid.
+func ValidOID(oid string) bool {
+	return oidRegexp.MatchString(oid)
+}
+
+// objectPath returns where an object is stored in a bare repository. This is the layout
+// git-lfs uses itself, so checkouts can fetch objects from the repository directly.
+func objectPath(repoDir string, oid string) string {
+	return path.Join(repoDir, "lfs", "objects", oid[0:2], oid[2:4], oid)
+}
+
+// Exists returns whether an object with the given size is stored in a repository.
+func Exists(repoDir string, oid string, size int64) bool {
+	if !ValidOID(oid) {
+		return false
+	}
+
+	info, err := os.Stat(objectPath(repoDir, oid))
+
+	return err == nil && info.Mode().IsRegular() && info.Size() == size
+}
+
+// Open opens a stored object for reading and returns its size.
+func Open(repoDir string, oid string) (*os.File, int64, error) {
+	if !ValidOID(oid) {
+		return nil, 0, ErrInvalidObject
+	}
+
+	file, err := os.Open(objectPath(repoDir, oid))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, 0, err
+	}
+
+	return file, info.Size(), nil
+}
+
+// Put stores an object read from r. The object is only stored if its content matches the object ID and size.
+func Put(repoDir string, oid string, size int64, r io.Reader) error {
+	if !ValidOID(oid) || size < 0 {
+		return ErrInvalidObject
+	}
+
+	tmpDir := path.Join(repoDir, "lfs", "tmp")
+
+	err := os.MkdirAll(tmpDir, os.FileMode(0755))
+	if err != nil {
+		return err
+	}
+
+	tmpFile, err := os.CreateTemp(tmpDir, oid)
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(tmpFile.Name())
+
+	hash := sha256.New()
+
+	written, err := io.Copy(io.MultiWriter(tmpFile, hash), io.LimitReader(r, size+1))
+
+	closeErr := tmpFile.Close()
+	if err != nil {
+		return err
+	}
+
+	if closeErr != nil {
+		return closeErr
+	}
+
+	if written != size || hex.EncodeToString(hash.Sum(nil)) != oid {
+		return fmt.Errorf("%w: content doesn't match %s", ErrInvalidObject, oid)
+	}
+
+	err = os.MkdirAll(path.Dir(objectPath(repoDir, oid)), os.FileMode(0755))
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFile.Name(), objectPath(repoDir, oid))
+}
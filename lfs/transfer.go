@@ -0,0 +1,398 @@
+package lfs
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+)
+
+const (
+	// flushPacket ends a message of the transfer protocol.
+	flushPacket = "0000"
+
+	// delimPacket separates the arguments of a message from its data.
+	delimPacket = "0001"
+
+	// maxPacketData is the largest payload of a single pkt-line.
+	maxPacketData = 65516
+)
+
+// errFlush is returned when reading a flush packet.
+var errFlush = errors.New("flush packet")
+
+// errDelim is returned when reading a delimiter packet.
+var errDelim = errors.New("delimiter packet")
+
+// transfer serves the git-lfs-transfer protocol for a single repository.
+type transfer struct {
+	repoDir   string
+	operation string
+	reader    *bufio.Reader
+	writer    io.Writer
+}
+
+// message is a request sent by the client. Data is only read for put-object requests.
+type message struct {
+	command string
+	args    map[string]string
+	lines   []string
+}
+
+// Serve runs the git-lfs-transfer protocol, which git-lfs 3 uses to transfer objects over SSH, on a
+// connection until the client quits. Uploads are only accepted if the operation is upload.
+func Serve(repoDir string, operation string, r io.Reader, w io.Writer) error {
+	t := &transfer{
+		repoDir:   repoDir,
+		operation: operation,
+		reader:    bufio.NewReader(r),
+		writer:    w,
+	}
+
+	err := t.writeText("version=1")
+	if err != nil {
+		return err
+	}
+
+	err = t.writeFlush()
+	if err != nil {
+		return err
+	}
+
+	for {
+		msg, err := t.readMessage()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+
+			return err
+		}
+
+		name, _, _ := strings.Cut(msg.command, " ")
+
+		switch name {
+		case "version":
+			err = t.writeStatus(200, nil, nil)
+		case "batch":
+			err = t.batch(msg)
+		case "get-object":
+			err = t.getObject(msg)
+		case "put-object":
+			err = t.putObject(msg)
+		case "verify-object":
+			err = t.verifyObject(msg)
+		case "list-lock", "lock", "unlock":
+			err = t.writeStatus(501, nil, []string{"locking is not supported"})
+		case "quit":
+			return t.writeStatus(200, nil, nil)
+		default:
+			err = t.writeStatus(400, nil, []string{fmt.Sprintf("unknown command %q", msg.command)})
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// batch tells the client which objects have to be transferred.
+func (t *transfer) batch(msg message) error {
+	if algo, ok := msg.args["hash-algo"]; ok && algo != "sha256" {
+		return t.writeStatus(409, nil, []string{fmt.Sprintf("unsupported hash algorithm %q", algo)})
+	}
+
+	var objects []string
+
+	for _, line := range msg.lines {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			return t.writeStatus(400, nil, []string{fmt.Sprintf("invalid object %q", line)})
+		}
+
+		oid := fields[0]
+
+		size, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil || !ValidOID(oid) {
+			return t.writeStatus(400, nil, []string{fmt.Sprintf("invalid object %q", line)})
+		}
+
+		action := "noop"
+		exists := Exists(t.repoDir, oid, size)
+
+		switch {
+		case t.operation == OperationUpload && !exists:
+			action = OperationUpload
+		case t.operation == OperationDownload && exists:
+			action = OperationDownload
+		}
+
+		objects = append(objects, fmt.Sprintf("%s %d %s", oid, size, action))
+	}
+
+	return t.writeStatus(200, []string{"hash-algo=sha256"}, objects)
+}
+
+// getObject sends an object to the client.
+func (t *transfer) getObject(msg message) error {
+	file, size, err := Open(t.repoDir, msg.oid())
+	if err != nil {
+		return t.writeStatus(404, nil, []string{"object not found"})
+	}
+
+	defer file.Close()
+
+	err = t.writeText("status 200")
+	if err != nil {
+		return err
+	}
+
+	err = t.writeText(fmt.Sprintf("size=%d", size))
+	if err != nil {
+		return err
+	}
+
+	err = t.writePacket([]byte(delimPacket))
+	if err != nil {
+		return err
+	}
+
+	buf := make([]byte, maxPacketData)
+
+	for {
+		n, err := file.Read(buf)
+		if n > 0 {
+			writeErr := t.writeData(buf[:n])
+			if writeErr != nil {
+				return writeErr
+			}
+		}
+
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return t.writeFlush()
+}
+
+// putObject stores an object sent by the client.
+func (t *transfer) putObject(msg message) error {
+	data := &packetReader{t: t}
+
+	// The data has to be read either way to stay in sync with the client.
+	if t.operation != OperationUpload {
+		_, err := io.Copy(io.Discard, data)
+		if err != nil {
+			return err
+		}
+
+		return t.writeStatus(403, nil, []string{"uploading requires the upload operation"})
+	}
+
+	size, err := strconv.ParseInt(msg.args["size"], 10, 64)
+	if err != nil {
+		_, err := io.Copy(io.Discard, data)
+		if err != nil {
+			return err
+		}
+
+		return t.writeStatus(400, nil, []string{"invalid size"})
+	}
+
+	err = Put(t.repoDir, msg.oid(), size, data)
+
+	// Drain what Put didn't read, e.g. if the client sent more data than announced.
+	_, drainErr := io.Copy(io.Discard, data)
+	if drainErr != nil {
+		return drainErr
+	}
+
+	if err != nil {
+		log.Println("Error storing LFS object:", err)
+		return t.writeStatus(400, nil, []string{err.Error()})
+	}
+
+	return t.writeStatus(200, nil, nil)
+}
+
+// verifyObject confirms an object was stored.
+func (t *transfer) verifyObject(msg message) error {
+	size, err := strconv.ParseInt(msg.args["size"], 10, 64)
+	if err != nil || !Exists(t.repoDir, msg.oid(), size) {
+		return t.writeStatus(404, nil, []string{"object not found"})
+	}
+
+	return t.writeStatus(200, nil, nil)
+}
+
+// oid returns the object ID a get-object, put-object or verify-object request refers to.
+func (m message) oid() string {
+	_, oid, _ := strings.Cut(m.command, " ")
+	return oid
+}
+
+// readMessage reads a request up to its flush packet, or up to the delimiter of put-object requests whose data follows.
+func (t *transfer) readMessage() (message, error) {
+	msg := message{args: map[string]string{}}
+
+	command, err := t.readPacket()
+	if err != nil {
+		return msg, err
+	}
+
+	msg.command = strings.TrimSuffix(string(command), "\n")
+
+	inArgs := true
+
+	for {
+		packet, err := t.readPacket()
+
+		switch {
+		case errors.Is(err, errFlush):
+			return msg, nil
+		case errors.Is(err, errDelim):
+			if strings.HasPrefix(msg.command, "put-object ") {
+				return msg, nil
+			}
+
+			inArgs = false
+
+			continue
+		case err != nil:
+			return msg, err
+		}
+
+		line := strings.TrimSuffix(string(packet), "\n")
+
+		if inArgs {
+			key, value, _ := strings.Cut(line, "=")
+			msg.args[key] = value
+		} else {
+			msg.lines = append(msg.lines, line)
+		}
+	}
+}
+
+// readPacket reads a single pkt-line. Flush and delimiter packets are returned as errFlush and errDelim.
+func (t *transfer) readPacket() ([]byte, error) {
+	header := make([]byte, 4)
+
+	_, err := io.ReadFull(t.reader, header)
+	if err != nil {
+		return nil, err
+	}
+
+	switch string(header) {
+	case flushPacket:
+		return nil, errFlush
+	case delimPacket:
+		return nil, errDelim
+	}
+
+	length, err := strconv.ParseUint(string(header), 16, 16)
+	if err != nil || length < 4 {
+		return nil, fmt.Errorf("invalid packet length %q", header)
+	}
+
+	data := make([]byte, length-4)
+
+	_, err = io.ReadFull(t.reader, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// writeStatus writes a response with a status code, its arguments and data lines.
+func (t *transfer) writeStatus(code int, args []string, lines []string) error {
+	err := t.writeText(fmt.Sprintf("status %d", code))
+	if err != nil {
+		return err
+	}
+
+	for _, arg := range args {
+		err := t.writeText(arg)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(lines) > 0 {
+		err := t.writePacket([]byte(delimPacket))
+		if err != nil {
+			return err
+		}
+
+		for _, line := range lines {
+			err := t.writeText(line)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return t.writeFlush()
+}
+
+// writeText writes a pkt-line containing a line of text.
+func (t *transfer) writeText(text string) error {
+	return t.writeData([]byte(text + "\n"))
+}
+
+// writeData writes a pkt-line containing data.
+func (t *transfer) writeData(data []byte) error {
+	return t.writePacket(append([]byte(fmt.Sprintf("%04x", len(data)+4)), data...))
+}
+
+// writeFlush writes a flush packet.
+func (t *transfer) writeFlush() error {
+	return t.writePacket([]byte(flushPacket))
+}
+
+// writePacket writes an encoded packet.
+func (t *transfer) writePacket(packet []byte) error {
+	_, err := t.writer.Write(packet)
+	return err
+}
+
+// packetReader reads the data packets of a put-object request up to its flush packet.
+type packetReader struct {
+	t    *transfer
+	buf  []byte
+	done bool
+}
+
+// Read implements io.Reader.
+func (p *packetReader) Read(b []byte) (int, error) {
+	for len(p.buf) == 0 {
+		if p.done {
+			return 0, io.EOF
+		}
+
+		packet, err := p.t.readPacket()
+
+		switch {
+		case errors.Is(err, errFlush):
+			p.done = true
+		case errors.Is(err, errDelim):
+		case err != nil:
+			return 0, err
+		default:
+			p.buf = packet
+		}
+	}
+
+	n := copy(b, p.buf)
+	p.buf = p.buf[n:]
+
+	return n, nil
+}
@@ -0,0 +1,231 @@
+// Package metrics implements the Prometheus metrics exposed by pcompose
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// SessionShell is the session type of interactive shells into projects and containers.
+	SessionShell = "shell"
+
+	// SessionExec is the session type of docker-compose and pcompose commands.
+	SessionExec = "exec"
+
+	// SessionGit is the session type of git fetches and pushes.
+	SessionGit = "git"
+
+	// SessionLogs is the session type of container logs.
+	SessionLogs = "logs"
+
+	// SessionAttach is the session type of container attach sessions.
+	SessionAttach = "attach"
+)
+
+var (
+	// mu protects the metrics collected in memory.
+	mu sync.Mutex
+
+	// connectionsActive is the number of established SSH connections.
+	connectionsActive float64
+
+	// connectionsTotal is the number of SSH connections established since startup.
+	connectionsTotal float64
+
+	// authFailures holds failed authentication attempts by service and reason.
+	authFailures = map[[2]string]float64{}
+
+	// sessionsActive holds the number of running sessions by type.
+	sessionsActive = map[string]float64{}
+
+	// sessionsTotal holds the number of sessions started since startup by type.
+	sessionsTotal = map[string]float64{}
+
+	// pushes holds the number of pushes that updated refs by project.
+	pushes = map[string]float64{}
+)
+
+// sample is a single value of a metric. The suffix is appended to the metric name, e.g. _bucket for histograms.
+type sample struct {
+	suffix string
+	labels [][2]string
+	value  float64
+}
+
+// metric is a metric with its samples.
+type metric struct {
+	name    string
+	help    string
+	kind    string
+	samples []sample
+}
+
+// ConnectionOpened records an established SSH connection.
+func ConnectionOpened() {
+	mu.Lock()
+	defer mu.Unlock()
+
+	connectionsActive++
+	connectionsTotal++
+}
+
+// ConnectionClosed records a closed SSH connection.
+func ConnectionClosed() {
+	mu.Lock()
+	defer mu.Unlock()
+
+	connectionsActive--
+}
+
+// AuthFailure records a failed authentication attempt of a service.
+func AuthFailure(service string, reason string) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	authFailures[[2]string{service, reason}]++
+}
+
+// SessionStarted records a session of the given type and returns a function to call once it ends.
+func SessionStarted(sessionType string) func() {
+	mu.Lock()
+	defer mu.Unlock()
+
+	sessionsActive[sessionType]++
+	sessionsTotal[sessionType]++
+
+	var once sync.Once
+
+	return func() {
+		once.Do(func() {
+			mu.Lock()
+			defer mu.Unlock()
+
+			sessionsActive[sessionType]--
+		})
+	}
+}
+
+// Push records a push that updated refs of a project.
+func Push(project string) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	pushes[strings.Trim(project, "/")]++
+}
+
+// writeServerMetrics writes the metrics collected in memory.
+func writeServerMetrics(w io.Writer) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	failures := []sample{}
+	for key, value := range authFailures {
+		failures = append(failures, sample{labels: [][2]string{{"service", key[0]}, {"reason", key[1]}}, value: value})
+	}
+
+	pushSamples := []sample{}
+	for project, value := range pushes {
+		pushSamples = append(pushSamples, sample{labels: [][2]string{{"project", project}}, value: value})
+	}
+
+	return writeMetrics(w, []metric{
+		{"pcompose_ssh_connections_active", "Number of established SSH connections.", "gauge", []sample{{value: connectionsActive}}},
+		{"pcompose_ssh_connections_total", "Number of SSH connections established since startup.", "counter", []sample{{value: connectionsTotal}}},
+		{"pcompose_auth_failures_total", "Number of failed authentication attempts by service and reason.", "counter", failures},
+		{"pcompose_sessions_active", "Number of running SSH sessions by type.", "gauge", sessionSamples(sessionsActive)},
+		{"pcompose_sessions_total", "Number of SSH sessions started since startup by type.", "counter", sessionSamples(sessionsTotal)},
+		{"pcompose_pushes_total", "Number of pushes that updated refs by project.", "counter", pushSamples},
+		{"pcompose_goroutines", "Number of running goroutines.", "gauge", []sample{{value: float64(runtime.NumGoroutine())}}},
+	})
+}
+
+// sessionSamples returns a sample for every session type, including types without sessions yet.
+func sessionSamples(values map[string]float64) []sample {
+	samples := []sample{}
+
+	for _, sessionType := range []string{SessionShell, SessionExec, SessionGit, SessionLogs, SessionAttach} {
+		samples = append(samples, sample{labels: [][2]string{{"type", sessionType}}, value: values[sessionType]})
+	}
+
+	return samples
+}
+
+// writeMetrics writes metrics in the Prometheus text exposition format.
+func writeMetrics(w io.Writer, metrics []metric) error {
+	for _, m := range metrics {
+		err := writeMetric(w, m.name, m.help, m.kind, m.samples)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeMetric writes a metric in the Prometheus text exposition format.
+func writeMetric(w io.Writer, name string, help string, kind string, samples []sample) error {
+	_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	if err != nil {
+		return err
+	}
+
+	lines := make([]string, 0, len(samples))
+
+	for _, s := range samples {
+		lines = append(lines, formatSample(name, s))
+	}
+
+	// Keep the output stable between scrapes, histogram buckets are already ordered.
+	if kind != "histogram" {
+		sort.Strings(lines)
+	}
+
+	for _, line := range lines {
+		_, err := io.WriteString(w, line)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// formatSample formats a single sample line.
+func formatSample(name string, s sample) string {
+	var b strings.Builder
+
+	b.WriteString(name)
+	b.WriteString(s.suffix)
+
+	if len(s.labels) > 0 {
+		b.WriteString("{")
+
+		for i, label := range s.labels {
+			if i > 0 {
+				b.WriteString(",")
+			}
+
+			b.WriteString(label[0])
+			b.WriteString(`="`)
+			b.WriteString(labelReplacer.Replace(label[1]))
+			b.WriteString(`"`)
+		}
+
+		b.WriteString("}")
+	}
+
+	b.WriteString(" ")
+	b.WriteString(strconv.FormatFloat(s.value, 'g', -1, 64))
+	b.WriteString("\n")
+
+	return b.String()
+}
+
+// labelReplacer escapes label values.
+var labelReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
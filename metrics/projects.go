@@ -0,0 +1,187 @@
+package metrics
+
+import (
+	"io"
+	"log"
+	"strconv"
+
+	"github.com/antoniomika/pcompose/deploy"
+	"github.com/antoniomika/pcompose/jobs"
+	"github.com/antoniomika/pcompose/project"
+)
+
+// deployDurationBuckets are the upper bounds in seconds of the deploy duration histogram.
+var deployDurationBuckets = []float64{5, 15, 30, 60, 120, 300, 600, 1200, 1800}
+
+// Write writes every metric in the Prometheus text exposition format. Project metrics
+// are only written for projects include returns true for.
+func Write(w io.Writer, include func(p project.Project) bool) error {
+	err := writeServerMetrics(w)
+	if err != nil {
+		return err
+	}
+
+	projects, err := project.List()
+	if err != nil {
+		return err
+	}
+
+	var (
+		deploys     []sample
+		durations   []sample
+		lastSuccess []sample
+		lastTime    []sample
+		lastGood    []sample
+		containers  []sample
+		health      []sample
+		jobRuns     []sample
+		jobSuccess  []sample
+		jobTime     []sample
+	)
+
+	for _, p := range projects {
+		if include != nil && !include(p) {
+			continue
+		}
+
+		projectLabel := [2]string{"project", p.FullName()}
+
+		records, err := deploy.Records(p)
+		if err != nil {
+			log.Println("Error listing deploys:", err)
+		}
+
+		counts := map[[2]string]float64{}
+		buckets := make([]float64, len(deployDurationBuckets))
+
+		var durationSum, durationCount float64
+		var lastFinished, lastSucceeded *deploy.Record
+
+		for _, record := range records {
+			counts[[2]string{record.Trigger, record.Status}]++
+
+			if record.End == nil {
+				continue
+			}
+
+			// Records are sorted newest first.
+			if lastFinished == nil {
+				lastFinished = record
+			}
+
+			if lastSucceeded == nil && record.Status == deploy.StatusSucceeded {
+				lastSucceeded = record
+			}
+
+			seconds := record.Duration().Seconds()
+			durationSum += seconds
+			durationCount++
+
+			for i, bound := range deployDurationBuckets {
+				if seconds <= bound {
+					buckets[i]++
+				}
+			}
+		}
+
+		for key, value := range counts {
+			deploys = append(deploys, sample{labels: [][2]string{projectLabel, {"trigger", key[0]}, {"status", key[1]}}, value: value})
+		}
+
+		for i, bound := range deployDurationBuckets {
+			durations = append(durations, sample{suffix: "_bucket", labels: [][2]string{projectLabel, {"le", strconv.FormatFloat(bound, 'g', -1, 64)}}, value: buckets[i]})
+		}
+
+		durations = append(durations,
+			sample{suffix: "_bucket", labels: [][2]string{projectLabel, {"le", "+Inf"}}, value: durationCount},
+			sample{suffix: "_sum", labels: [][2]string{projectLabel}, value: durationSum},
+			sample{suffix: "_count", labels: [][2]string{projectLabel}, value: durationCount},
+		)
+
+		if lastFinished != nil {
+			succeeded := 0.0
+			if lastFinished.Status == deploy.StatusSucceeded {
+				succeeded = 1
+			}
+
+			lastSuccess = append(lastSuccess, sample{labels: [][2]string{projectLabel}, value: succeeded})
+			lastTime = append(lastTime, sample{labels: [][2]string{projectLabel}, value: float64(lastFinished.End.Unix())})
+		}
+
+		if lastSucceeded != nil {
+			lastGood = append(lastGood, sample{labels: [][2]string{projectLabel}, value: float64(lastSucceeded.End.Unix())})
+		}
+
+		for _, job := range p.Config().Jobs {
+			jobLabel := [2]string{"job", job.Name}
+
+			runs, err := jobs.Runs(p, job.Name)
+			if err != nil {
+				continue
+			}
+
+			runCounts := map[string]float64{}
+			var lastRun *jobs.Run
+
+			for _, run := range runs {
+				runCounts[run.Status]++
+
+				if lastRun == nil && run.End != nil {
+					lastRun = run
+				}
+			}
+
+			for status, value := range runCounts {
+				jobRuns = append(jobRuns, sample{labels: [][2]string{projectLabel, jobLabel, {"status", status}}, value: value})
+			}
+
+			if lastRun != nil {
+				succeeded := 0.0
+				if lastRun.Status == deploy.StatusSucceeded {
+					succeeded = 1
+				}
+
+				jobSuccess = append(jobSuccess, sample{labels: [][2]string{projectLabel, jobLabel}, value: succeeded})
+				jobTime = append(jobTime, sample{labels: [][2]string{projectLabel, jobLabel}, value: float64(lastRun.End.Unix())})
+			}
+		}
+
+		projectContainers, err := deploy.Containers(p)
+		if err != nil {
+			log.Println("Error listing containers:", err)
+			continue
+		}
+
+		states := map[string]float64{}
+		healths := map[string]float64{}
+
+		for _, c := range projectContainers {
+			states[c.State]++
+
+			if c.Health != "" {
+				healths[c.Health]++
+			}
+		}
+
+		for state, value := range states {
+			containers = append(containers, sample{labels: [][2]string{projectLabel, {"state", state}}, value: value})
+		}
+
+		for status, value := range healths {
+			health = append(health, sample{labels: [][2]string{projectLabel, {"health", status}}, value: value})
+		}
+	}
+
+	return writeMetrics(w, []metric{
+		{"pcompose_deploys_total", "Number of recorded deploys by project, trigger and status.", "counter", deploys},
+		{"pcompose_deploy_duration_seconds", "Duration of finished deploys by project.", "histogram", durations},
+		{"pcompose_last_deploy_success", "Whether the last finished deploy of a project succeeded.", "gauge", lastSuccess},
+		{"pcompose_last_deploy_timestamp_seconds", "Time the last deploy of a project finished.", "gauge", lastTime},
+		{"pcompose_last_successful_deploy_timestamp_seconds", "Time the last successful deploy of a project finished.", "gauge", lastGood},
+		{"pcompose_containers", "Number of containers by project and state.", "gauge", containers},
+		{"pcompose_containers_health", "Number of containers with a health check by project and health status.", "gauge", health},
+		{"pcompose_job_runs", "Number of recorded runs of scheduled jobs by project, job and status.", "gauge", jobRuns},
+		{"pcompose_last_job_success", "Whether the last finished run of a job succeeded.", "gauge", jobSuccess},
+		{"pcompose_last_job_timestamp_seconds", "Time the last run of a job finished.", "gauge", jobTime},
+	})
+}
@@ -0,0 +1,271 @@
+// Package mirror implements mirroring repositories from external git hosts used by pcompose
+package mirror
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/antoniomika/pcompose/deploy"
+	"github.com/antoniomika/pcompose/project"
+)
+
+// pollCheckInterval is how often mirrors are checked for being due to be polled.
+const pollCheckInterval = 10 * time.Second
+
+// ErrNotMirrored is returned when syncing a project that doesn't mirror a remote repository.
+var ErrNotMirrored = errors.New("project is not a mirror")
+
+var (
+	// mu protects running, pending, lastSync, pushing and pushPending.
+	mu sync.Mutex
+
+	// running holds the projects that are currently syncing.
+	running = map[string]bool{}
+
+	// pending holds the projects that were triggered while syncing and the pusher that triggered them.
+	pending = map[string]string{}
+
+	// lastSync holds when each project finished syncing.
+	lastSync = map[string]time.Time{}
+
+	// pushing holds the repositories that are being pushed to their push mirrors in the background.
+	pushing = map[string]bool{}
+
+	// pushPending holds the repositories that were pushed to again while being pushed to their push mirrors.
+	pushPending = map[string]bool{}
+)
+
+// Start syncs every mirrored project once and then polls the projects that have a poll interval.
+// Pushes to push mirrors that failed are retried as well.
+func Start() {
+	for {
+		retryPushMirrors()
+
+		for _, config := range project.Configs() {
+			if config.Mirror.URL == "" || config.IsPattern() {
+				continue
+			}
+
+			p := project.New(config.Name)
+
+			if due(p, config.Mirror.PollInterval) {
+				Trigger(p, "")
+			}
+		}
+
+		time.Sleep(pollCheckInterval)
+	}
+}
+
+// due returns whether a project was never synced or its poll interval passed.
+func due(p project.Project, pollInterval time.Duration) bool {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if running[p.Name] {
+		return false
+	}
+
+	last, synced := lastSync[p.Name]
+
+	return !synced || (pollInterval > 0 && time.Since(last) >= pollInterval)
+}
+
+// Trigger syncs a mirrored project in the background. Triggers that arrive while the
+// project is syncing are coalesced into a single sync once the current one finishes.
+func Trigger(p project.Project, pusher string) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if running[p.Name] {
+		pending[p.Name] = pusher
+		return
+	}
+
+	running[p.Name] = true
+
+	go func() {
+		for {
+			err := Sync(p, pusher)
+			if err != nil {
+				log.Printf("Error syncing mirror of %s: %s", p.Name, err)
+			}
+
+			mu.Lock()
+
+			lastSync[p.Name] = time.Now()
+
+			nextPusher, ok := pending[p.Name]
+			if !ok {
+				delete(running, p.Name)
+				mu.Unlock()
+				return
+			}
+
+			delete(pending, p.Name)
+			pusher = nextPusher
+
+			mu.Unlock()
+		}
+	}()
+}
+
+// Sync fetches the remote repository of a project and deploys it if a ref changed,
+// just like a push would.
+func Sync(p project.Project, pusher string) error {
+	remote := p.Config().Mirror.URL
+	if remote == "" {
+		return ErrNotMirrored
+	}
+
+	err := project.InitRepository(p.RepoDir)
+	if err != nil {
+		return err
+	}
+
+	before := project.Refs(p.RepoDir)
+
+	fetchCmd := gitCommand(p.RepoDir, "fetch", "--prune", "--quiet", remote, "+refs/heads/*:refs/heads/*", "+refs/tags/*:refs/tags/*")
+
+	output, err := fetchCmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("error fetching %s: %w: %s", Redact(remote), err, strings.ReplaceAll(string(output), remote, Redact(remote)))
+	}
+
+	head := updateHead(p, remote)
+	changed := changedRefs(before, project.Refs(p.RepoDir), head)
+
+	if len(changed) > 0 {
+		TriggerPush(p)
+	}
+
+	var deployErr error
+
+	for _, deployment := range p.Deployments() {
+		update, revision, ok := deploy.DeployableRef(deployment, changed, func(update deploy.RefUpdate, err error) {
+			log.Printf("Mirror of %s not deploying %s, %s", deployment.FullName(), update.Ref, err)
+		})
+		if !ok {
+			continue
+		}
+
+		log.Printf("Mirror of %s updated %s to %s", deployment.FullName(), update.Ref, update.NewRev)
+
+		_, err := deploy.Run(deployment, deploy.Options{
+			Trigger:  deploy.TriggerMirror,
+			Ref:      update.Ref,
+			OldRev:   update.OldRev,
+			NewRev:   update.NewRev,
+			Revision: revision,
+			Pusher:   pusher,
+			Output:   log.Writer(),
+		})
+		if err != nil {
+			deployErr = err
+		}
+	}
+
+	return deployErr
+}
+
+// updateHead points HEAD of the repository to the default branch of the remote and returns it.
+func updateHead(p project.Project, remote string) string {
+	output, err := gitCommand(p.RepoDir, "ls-remote", "--symref", remote, "HEAD").Output()
+	if err != nil {
+		log.Println("Error getting default branch of mirror:", err)
+		return ""
+	}
+
+	for _, line := range strings.Split(string(output), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 || fields[0] != "ref:" || fields[2] != "HEAD" {
+			continue
+		}
+
+		err := gitCommand(p.RepoDir, "symbolic-ref", "HEAD", fields[1]).Run()
+		if err != nil {
+			log.Println("Error setting default branch of mirror:", err)
+		}
+
+		return fields[1]
+	}
+
+	return ""
+}
+
+// changedRefs returns the refs a fetch updated. The last deployable ref is deployed, so they are
+// ordered by preference: tags, then other branches and the default branch last.
+func changedRefs(before map[string]string, after map[string]string, head string) []deploy.RefUpdate {
+	var changed []deploy.RefUpdate
+
+	for ref, newRev := range after {
+		if before[ref] == newRev {
+			continue
+		}
+
+		oldRev := before[ref]
+		if oldRev == "" {
+			oldRev = deploy.ZeroRev
+		}
+
+		changed = append(changed, deploy.RefUpdate{
+			Ref:    ref,
+			OldRev: oldRev,
+			NewRev: newRev,
+		})
+	}
+
+	sort.Slice(changed, func(i, j int) bool {
+		a, b := changed[i].Ref, changed[j].Ref
+
+		if (a == head) != (b == head) {
+			return b == head
+		}
+
+		if isBranch(a) != isBranch(b) {
+			return isBranch(b)
+		}
+
+		return a < b
+	})
+
+	return changed
+}
+
+// isBranch returns whether a ref is a branch.
+func isBranch(ref string) bool {
+	return strings.HasPrefix(ref, "refs/heads/")
+}
+
+// gitCommand returns a git command that runs in a bare repository. The environment is
+// kept so fetching over ssh and https can find credentials and known hosts.
+func gitCommand(repoDir string, args ...string) *exec.Cmd {
+	return gitCommandContext(context.Background(), repoDir, args...)
+}
+
+// gitCommandContext returns a git command for a bare repository that is killed once ctx is done.
+func gitCommandContext(ctx context.Context, repoDir string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Env = append(os.Environ(), fmt.Sprintf("GIT_DIR=%s", repoDir), "GIT_TERMINAL_PROMPT=0")
+
+	return cmd
+}
+
+// Redact removes credentials from a remote URL so it can be logged.
+func Redact(remote string) string {
+	u, err := url.Parse(remote)
+	if err != nil || u.User == nil {
+		return remote
+	}
+
+	return u.Redacted()
+}
@@ -0,0 +1,299 @@
+package mirror
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/antoniomika/pcompose/project"
+	"github.com/spf13/viper"
+)
+
+// pushStatusFile is the file in the metadata directory of a repository that holds the status of its push mirrors.
+const pushStatusFile = "push-mirrors.json"
+
+// PushStatus is the outcome of pushing a repository to one of its push mirrors.
+type PushStatus struct {
+	// URL is the remote repository without credentials.
+	URL string `json:"url"`
+
+	// LastAttempt is when the repository was last pushed to the mirror.
+	LastAttempt time.Time `json:"last_attempt"`
+
+	// LastSuccess is when the repository was last pushed to the mirror successfully.
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+
+	// Failures is the number of pushes that failed in a row, including retries.
+	Failures int `json:"failures"`
+
+	// Error is the error of the last push if it failed.
+	Error string `json:"error,omitempty"`
+}
+
+// Push pushes the branches and tags of the repository of a project to every push mirror, retrying
+// failed pushes with exponential backoff. Branches and tags deleted from the repository are deleted
+// from the mirrors as well.
+func Push(p project.Project) error {
+	p = project.New(p.Name)
+
+	var pushErr error
+
+	for _, pushMirror := range p.Config().PushMirrors {
+		if pushMirror.URL == "" {
+			continue
+		}
+
+		err := pushWithRetries(p, pushMirror)
+		if err != nil {
+			log.Printf("Error pushing %s to mirror %s: %s", p.Name, Redact(pushMirror.URL), err)
+			pushErr = err
+			continue
+		}
+
+		log.Printf("Pushed %s to mirror %s", p.Name, Redact(pushMirror.URL))
+	}
+
+	return pushErr
+}
+
+// pushWithRetries pushes to a mirror until it succeeds or push-mirror-retries retries failed, and records the outcome.
+func pushWithRetries(p project.Project, pushMirror project.PushMirrorConfig) error {
+	backoff := time.Second
+
+	var err error
+
+	for attempt := 0; ; attempt++ {
+		err = pushOnce(p, pushMirror)
+
+		updateErr := updatePushStatus(p, Redact(pushMirror.URL), err)
+		if updateErr != nil {
+			log.Println("Error saving push mirror status:", updateErr)
+		}
+
+		if err == nil || attempt >= viper.GetInt("push-mirror-retries") {
+			return err
+		}
+
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// pushOnce pushes the branches and tags of a repository to a mirror, giving up after push-mirror-timeout.
+func pushOnce(p project.Project, pushMirror project.PushMirrorConfig) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	if timeout := viper.GetDuration("push-mirror-timeout"); timeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), timeout)
+	}
+
+	defer cancel()
+
+	pushCmd := gitCommandContext(ctx, p.RepoDir, "push", "--porcelain", "--prune", pushMirror.URL, "+refs/heads/*:refs/heads/*", "+refs/tags/*:refs/tags/*")
+
+	if pushMirror.SSHKey != "" {
+		pushCmd.Env = append(pushCmd.Env, fmt.Sprintf("GIT_SSH_COMMAND=ssh -i '%s' -o IdentitiesOnly=yes -o StrictHostKeyChecking=accept-new", pushMirror.SSHKey))
+	}
+
+	output, err := pushCmd.CombinedOutput()
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("push timed out after %s", viper.GetDuration("push-mirror-timeout"))
+	}
+
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(strings.ReplaceAll(string(output), pushMirror.URL, Redact(pushMirror.URL))))
+	}
+
+	return nil
+}
+
+// PushStatuses returns the status of every push mirror configured for the repository of a project.
+// Mirrors that weren't pushed to yet have a zero LastAttempt.
+func PushStatuses(p project.Project) ([]PushStatus, error) {
+	p = project.New(p.Name)
+
+	saved, err := readPushStatuses(p)
+	if err != nil {
+		return nil, err
+	}
+
+	var statuses []PushStatus
+
+	for _, pushMirror := range p.Config().PushMirrors {
+		if pushMirror.URL == "" {
+			continue
+		}
+
+		status, ok := saved[Redact(pushMirror.URL)]
+		if !ok {
+			status = PushStatus{URL: Redact(pushMirror.URL)}
+		}
+
+		statuses = append(statuses, status)
+	}
+
+	return statuses, nil
+}
+
+// readPushStatuses returns the saved push mirror statuses of a repository by URL.
+func readPushStatuses(p project.Project) (map[string]PushStatus, error) {
+	statuses := map[string]PushStatus{}
+
+	data, err := os.ReadFile(path.Join(p.MetadataDir(), pushStatusFile))
+	if os.IsNotExist(err) {
+		return statuses, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var list []PushStatus
+
+	err = json.Unmarshal(data, &list)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, status := range list {
+		statuses[status.URL] = status
+	}
+
+	return statuses, nil
+}
+
+// updatePushStatus records the outcome of a push to a mirror. The file is replaced atomically,
+// since it is read by the API and the push-mirrors command while mirrors are pushed.
+func updatePushStatus(p project.Project, url string, pushErr error) error {
+	statuses, err := readPushStatuses(p)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+
+	status := statuses[url]
+	status.URL = url
+	status.LastAttempt = now
+
+	if pushErr == nil {
+		status.LastSuccess = &now
+		status.Failures = 0
+		status.Error = ""
+	} else {
+		status.Failures++
+		status.Error = pushErr.Error()
+	}
+
+	statuses[url] = status
+
+	list := make([]PushStatus, 0, len(statuses))
+	for _, pushMirror := range p.Config().PushMirrors {
+		if status, ok := statuses[Redact(pushMirror.URL)]; ok {
+			list = append(list, status)
+		}
+	}
+
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(p.MetadataDir(), os.FileMode(0755))
+	if err != nil {
+		return err
+	}
+
+	statusFile := path.Join(p.MetadataDir(), pushStatusFile)
+
+	err = os.WriteFile(statusFile+".tmp", data, os.FileMode(0644))
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(statusFile+".tmp", statusFile)
+}
+
+// retryPushMirrors pushes repositories in the background whose last push to a mirror failed
+// at least push-mirror-retry-interval ago.
+func retryPushMirrors() {
+	interval := viper.GetDuration("push-mirror-retry-interval")
+	if interval <= 0 {
+		return
+	}
+
+	projects, err := project.List()
+	if err != nil {
+		log.Println("Error listing projects:", err)
+		return
+	}
+
+	seen := map[string]bool{}
+
+	for _, p := range projects {
+		// Deployments of a repository share its push mirrors.
+		if seen[p.Name] {
+			continue
+		}
+
+		seen[p.Name] = true
+
+		statuses, err := PushStatuses(p)
+		if err != nil {
+			log.Println("Error reading push mirror status:", err)
+			continue
+		}
+
+		for _, status := range statuses {
+			if status.Error != "" && time.Since(status.LastAttempt) >= interval {
+				TriggerPush(p)
+				break
+			}
+		}
+	}
+}
+
+// Pushed updates the push mirrors of a repository after something was pushed to it over SSH or HTTP.
+// Push mirrors are updated even if a deploy failed, since the push itself succeeded.
+func Pushed(repo string) {
+	TriggerPush(project.New(repo))
+}
+
+// TriggerPush pushes a repository to its push mirrors in the background, so pushes and deploys
+// don't wait for slow mirrors. Triggers that arrive while the repository is being pushed are
+// coalesced into a single push once the current one finishes.
+func TriggerPush(p project.Project) {
+	if len(project.New(p.Name).Config().PushMirrors) == 0 {
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if pushing[p.Name] {
+		pushPending[p.Name] = true
+		return
+	}
+
+	pushing[p.Name] = true
+
+	go func() {
+		for {
+			_ = Push(p)
+
+			mu.Lock()
+
+			if !pushPending[p.Name] {
+				delete(pushing, p.Name)
+				mu.Unlock()
+				return
+			}
+
+			delete(pushPending, p.Name)
+
+			mu.Unlock()
+		}
+	}()
+}
@@ -0,0 +1,73 @@
+package mirror
+
+import (
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/antoniomika/pcompose/internal/gittest"
+	"github.com/antoniomika/pcompose/project"
+	"github.com/spf13/viper"
+)
+
+// newPushRepo creates a bare repository with a commit on main for the project alice/app.
+func newPushRepo(t *testing.T) project.Project {
+	t.Helper()
+
+	gittest.Setup(t)
+
+	dir := t.TempDir()
+
+	gittest.Run(t, dir, "init", "-q", "-b", "main", "work")
+	gittest.Run(t, filepath.Join(dir, "work"), "commit", "-q", "--allow-empty", "-m", "initial")
+	gittest.Run(t, dir, "clone", "-q", "--bare", "work", "repo.git")
+
+	return project.Project{Name: "alice/app", RepoDir: filepath.Join(dir, "repo.git")}
+}
+
+func TestPushOnce(t *testing.T) {
+	p := newPushRepo(t)
+
+	remote := filepath.Join(t.TempDir(), "mirror.git")
+	gittest.Run(t, filepath.Dir(remote), "init", "-q", "--bare", remote)
+
+	// Refs deleted from pcompose are deleted from the mirror.
+	gittest.Run(t, p.RepoDir, "push", "-q", remote, "main:refs/heads/stale")
+
+	err := pushOnce(p, project.PushMirrorConfig{URL: remote})
+	if err != nil {
+		t.Fatalf("pushOnce() error = %v", err)
+	}
+
+	refs := gittest.Run(t, remote, "for-each-ref", "--format=%(refname)")
+	if refs != "refs/heads/main" {
+		t.Errorf("mirror refs = %q, want refs/heads/main", refs)
+	}
+}
+
+func TestPushOnceTimeout(t *testing.T) {
+	p := newPushRepo(t)
+
+	if _, err := exec.LookPath("sleep"); err != nil {
+		t.Skip("sleep is not available")
+	}
+
+	// An ssh connection that hangs.
+	t.Setenv("GIT_SSH_COMMAND", "sleep 30 #")
+
+	viper.Set("push-mirror-timeout", 200*time.Millisecond)
+	defer viper.Reset()
+
+	start := time.Now()
+
+	err := pushOnce(p, project.PushMirrorConfig{URL: "ssh://git@mirror.example.com/alice/app.git"})
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("pushOnce() error = %v, want a timeout", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("pushOnce() took %s after timing out", elapsed)
+	}
+}
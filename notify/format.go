@@ -0,0 +1,177 @@
+package notify
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"strings"
+	"time"
+
+	"github.com/antoniomika/pcompose/utils"
+)
+
+// jsonEvent is the payload of the generic JSON format.
+type jsonEvent struct {
+	Event
+	DurationSeconds float64 `json:"duration_seconds,omitempty"`
+	Message         string  `json:"message"`
+}
+
+// slackField is a field of a Slack message attachment.
+type slackField struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+	Short bool   `json:"short"`
+}
+
+// slackAttachment is a Slack message attachment.
+type slackAttachment struct {
+	Color    string       `json:"color"`
+	Fallback string       `json:"fallback"`
+	Fields   []slackField `json:"fields"`
+}
+
+// slackMessage is the payload of the Slack format.
+type slackMessage struct {
+	Text        string            `json:"text"`
+	Attachments []slackAttachment `json:"attachments"`
+}
+
+// matrixMessage is the payload of the Matrix format.
+type matrixMessage struct {
+	Text     string `json:"text"`
+	HTML     string `json:"html"`
+	Username string `json:"username"`
+}
+
+// payload returns the body of a webhook request in the given format.
+func payload(format string, event Event) ([]byte, error) {
+	switch strings.ToLower(format) {
+	case "", FormatJSON:
+		return json.Marshal(jsonEvent{
+			Event:           event,
+			DurationSeconds: event.Duration.Seconds(),
+			Message:         summary(event),
+		})
+	case FormatSlack:
+		return json.Marshal(slackMessage{
+			Text: summary(event),
+			Attachments: []slackAttachment{{
+				Color:    color(event),
+				Fallback: summary(event),
+				Fields:   fields(event),
+			}},
+		})
+	case FormatMatrix:
+		var b strings.Builder
+
+		b.WriteString(html.EscapeString(summary(event)))
+
+		for _, field := range fields(event) {
+			fmt.Fprintf(&b, "<br><b>%s</b>: <code>%s</code>", html.EscapeString(field.Title), html.EscapeString(field.Value))
+		}
+
+		return json.Marshal(matrixMessage{
+			Text:     summary(event),
+			HTML:     b.String(),
+			Username: "pcompose",
+		})
+	default:
+		return nil, fmt.Errorf("unknown webhook format %q", format)
+	}
+}
+
+// revision returns the most specific revision known for an event.
+func revision(event Event) string {
+	revision := event.Revision
+	if revision == "" {
+		revision = event.NewRev
+	}
+
+	return utils.ShortRev(revision)
+}
+
+// summary returns a single line describing an event.
+func summary(event Event) string {
+	target := event.Project
+	if rev := revision(event); rev != "" {
+		target = fmt.Sprintf("%s at %s", target, rev)
+	}
+
+	var message string
+
+	switch event.Event {
+	case EventStarted:
+		message = fmt.Sprintf("Deploying %s", target)
+	case EventBuilt:
+		message = fmt.Sprintf("Built %s in %s", target, event.Duration.Round(time.Second))
+	case EventSucceeded:
+		message = fmt.Sprintf("Deployed %s in %s", target, event.Duration.Round(time.Second))
+	case EventRolledBack:
+		message = fmt.Sprintf("Rolled back %s in %s", target, event.Duration.Round(time.Second))
+	case EventFailed:
+		message = fmt.Sprintf("Deploy of %s failed after %s", target, event.Duration.Round(time.Second))
+	case EventJobFailed:
+		message = fmt.Sprintf("Job %s of %s failed after %s", event.Job, target, event.Duration.Round(time.Second))
+	default:
+		message = fmt.Sprintf("%s: %s", target, event.Event)
+	}
+
+	if event.Pusher != "" {
+		message = fmt.Sprintf("%s (%s by %s)", message, event.Trigger, event.Pusher)
+	} else {
+		message = fmt.Sprintf("%s (%s)", message, event.Trigger)
+	}
+
+	if event.Error != "" {
+		message = fmt.Sprintf("%s: %s", message, event.Error)
+	}
+
+	return message
+}
+
+// fields returns the details of an event shown by chat formats.
+func fields(event Event) []slackField {
+	fields := []slackField{
+		{Title: "Project", Value: event.Project, Short: true},
+	}
+
+	if event.Job != "" {
+		fields = append(fields,
+			slackField{Title: "Job", Value: event.Job, Short: true},
+			slackField{Title: "Run", Value: event.RunID, Short: true},
+		)
+	} else {
+		fields = append(fields, slackField{Title: "Deploy", Value: event.DeployID, Short: true})
+	}
+
+	if event.Ref != "" {
+		fields = append(fields, slackField{Title: "Ref", Value: event.Ref, Short: true})
+	}
+
+	if rev := revision(event); rev != "" {
+		fields = append(fields, slackField{Title: "Commit", Value: rev, Short: true})
+	}
+
+	if event.Pusher != "" {
+		fields = append(fields, slackField{Title: "Pusher", Value: event.Pusher, Short: true})
+	}
+
+	if event.Event != EventStarted {
+		fields = append(fields, slackField{Title: "Duration", Value: event.Duration.Round(time.Second).String(), Short: true})
+	}
+
+	return fields
+}
+
+// color returns the attachment color of an event.
+func color(event Event) string {
+	switch event.Event {
+	case EventSucceeded, EventRolledBack:
+		return "good"
+	case EventFailed, EventJobFailed:
+		return "danger"
+	default:
+		return "#439FE0"
+	}
+}
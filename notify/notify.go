@@ -0,0 +1,281 @@
+// Package notify implements the outbound deploy webhooks used by pcompose
+package notify
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	// EventStarted is sent when a deploy starts running.
+	EventStarted = "started"
+
+	// EventBuilt is sent when the images of a deploy finished building.
+	EventBuilt = "built"
+
+	// EventSucceeded is sent when a deploy finished successfully.
+	EventSucceeded = "succeeded"
+
+	// EventFailed is sent when a deploy failed.
+	EventFailed = "failed"
+
+	// EventRolledBack is sent instead of EventSucceeded when a rollback finished successfully.
+	EventRolledBack = "rolled_back"
+
+	// EventJobFailed is sent when a run of a scheduled job failed.
+	EventJobFailed = "job_failed"
+
+	// FormatJSON posts the event as JSON.
+	FormatJSON = "json"
+
+	// FormatSlack posts a Slack compatible incoming webhook message.
+	FormatSlack = "slack"
+
+	// FormatMatrix posts a message compatible with matrix-hookshot generic webhooks.
+	FormatMatrix = "matrix"
+
+	// SignatureHeader holds the HMAC-SHA256 signature of the body if the webhook has a secret.
+	SignatureHeader = "X-Pcompose-Signature"
+)
+
+// Event describes a deploy lifecycle event, or the failure of a job run.
+type Event struct {
+	Event    string        `json:"event"`
+	DeployID string        `json:"deploy_id,omitempty"`
+	Job      string        `json:"job,omitempty"`
+	RunID    string        `json:"run_id,omitempty"`
+	Project  string        `json:"project"`
+	Trigger  string        `json:"trigger"`
+	Ref      string        `json:"ref,omitempty"`
+	OldRev   string        `json:"old_rev,omitempty"`
+	NewRev   string        `json:"new_rev,omitempty"`
+	Revision string        `json:"revision,omitempty"`
+	Pusher   string        `json:"pusher,omitempty"`
+	Error    string        `json:"error,omitempty"`
+	Duration time.Duration `json:"-"`
+	Time     time.Time     `json:"time"`
+}
+
+// Webhook is a webhook configured using the webhooks list in the config file.
+type Webhook struct {
+	URL      string   `mapstructure:"url"`
+	Format   string   `mapstructure:"format"`
+	Secret   string   `mapstructure:"secret"`
+	Events   []string `mapstructure:"events"`
+	Projects []string `mapstructure:"projects"`
+}
+
+var (
+	// queue holds events waiting to be delivered, which happens in order.
+	queue = make(chan Event, 64)
+
+	// pending tracks events that haven't been delivered yet.
+	pending sync.WaitGroup
+
+	// startDispatcher starts the goroutine delivering queued events.
+	startDispatcher sync.Once
+)
+
+// Send queues an event for delivery to every matching webhook without blocking the deploy.
+// Events are dropped if too many are waiting for slow or unreachable webhooks.
+func Send(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	startDispatcher.Do(func() {
+		go dispatch()
+	})
+
+	pending.Add(1)
+
+	select {
+	case queue <- event:
+	default:
+		pending.Done()
+		log.Printf("Dropping %s event of %s, too many events are waiting to be delivered", event.Event, event.Project)
+	}
+}
+
+// Wait blocks until every queued event was delivered or gave up retrying.
+func Wait() {
+	pending.Wait()
+}
+
+// dispatch delivers queued events one after another so webhooks receive them in order.
+func dispatch() {
+	for event := range queue {
+		var wg sync.WaitGroup
+
+		for _, webhook := range Webhooks() {
+			if !webhook.matches(event) {
+				continue
+			}
+
+			wg.Add(1)
+
+			go func(webhook Webhook) {
+				defer wg.Done()
+
+				err := webhook.deliver(event)
+				if err != nil {
+					log.Printf("Error sending %s event of %s to webhook: %s", event.Event, event.Project, err)
+				}
+			}(webhook)
+		}
+
+		wg.Wait()
+		pending.Done()
+	}
+}
+
+// Webhooks returns the configured webhooks.
+func Webhooks() []Webhook {
+	var webhooks []Webhook
+
+	err := viper.UnmarshalKey("webhooks", &webhooks)
+	if err != nil {
+		log.Println("Error parsing webhooks:", err)
+		return nil
+	}
+
+	return webhooks
+}
+
+// matches returns whether the webhook is subscribed to the event and its project.
+func (w Webhook) matches(event Event) bool {
+	if w.URL == "" {
+		return false
+	}
+
+	if len(w.Events) > 0 && !contains(w.Events, event.Event) {
+		return false
+	}
+
+	if len(w.Projects) == 0 {
+		return true
+	}
+
+	for _, pattern := range w.Projects {
+		if match, err := path.Match(pattern, event.Project); err == nil && match {
+			return true
+		}
+	}
+
+	return false
+}
+
+// deliver posts an event to the webhook, retrying with exponential backoff on network and server errors.
+func (w Webhook) deliver(event Event) error {
+	body, err := payload(w.Format, event)
+	if err != nil {
+		return err
+	}
+
+	deliveryID := newDeliveryID()
+	client := &http.Client{
+		Timeout: viper.GetDuration("webhook-timeout"),
+	}
+
+	backoff := time.Second
+
+	for attempt := 0; ; attempt++ {
+		err = w.post(client, deliveryID, event, body)
+		if err == nil {
+			return nil
+		}
+
+		if _, permanent := err.(permanentError); permanent || attempt >= viper.GetInt("webhook-retries") {
+			return err
+		}
+
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// permanentError is returned for responses that won't succeed when retried.
+type permanentError struct {
+	status string
+}
+
+// Error returns the status of the response.
+func (e permanentError) Error() string {
+	return "webhook responded with " + e.status
+}
+
+// post sends a single delivery attempt.
+func (w Webhook) post(client *http.Client, deliveryID string, event Event, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return permanentError{status: err.Error()}
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "pcompose")
+	req.Header.Set("X-Pcompose-Event", event.Event)
+	req.Header.Set("X-Pcompose-Delivery", deliveryID)
+
+	if w.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign(w.Secret, body))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return fmt.Errorf("webhook responded with %s", resp.Status)
+	default:
+		return permanentError{status: resp.Status}
+	}
+}
+
+// Sign returns the signature of a body in the format of the signature header.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// newDeliveryID returns a random ID identifying a delivery across retries.
+func newDeliveryID() string {
+	id := make([]byte, 16)
+
+	_, err := rand.Read(id)
+	if err != nil {
+		log.Println("Error generating delivery ID:", err)
+	}
+
+	return hex.EncodeToString(id)
+}
+
+// contains returns whether a list contains a value, ignoring case.
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, value) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,62 @@
+package notify
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func TestSendDropsEventsWhenQueueIsFull(t *testing.T) {
+	var received int32
+
+	delivering := make(chan struct{}, 1)
+	release := make(chan struct{})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&received, 1)
+
+		select {
+		case delivering <- struct{}{}:
+		default:
+		}
+
+		<-release
+	}))
+	defer server.Close()
+
+	viper.Set("webhooks", []map[string]interface{}{{"url": server.URL}})
+	viper.Set("webhook-timeout", time.Minute)
+
+	defer viper.Reset()
+
+	// The first event blocks the dispatcher until the webhook is released.
+	Send(Event{Event: EventStarted, Project: "alice/app"})
+	<-delivering
+
+	sent := make(chan struct{})
+
+	go func() {
+		for i := 0; i < cap(queue)+10; i++ {
+			Send(Event{Event: EventSucceeded, Project: "alice/app"})
+		}
+
+		close(sent)
+	}()
+
+	select {
+	case <-sent:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Send() blocked on a full queue")
+	}
+
+	close(release)
+	Wait()
+
+	if got, want := atomic.LoadInt32(&received), int32(cap(queue)+1); got != want {
+		t.Errorf("webhook received %d events, want %d", got, want)
+	}
+}
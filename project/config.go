@@ -0,0 +1,277 @@
+package project
+
+import (
+	"log"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Config holds the server side settings of a project, configured using the projects list in the config file.
+type Config struct {
+	// Name is the name of the project or a pattern matching project names, e.g. payments/*.
+	Name string `mapstructure:"name"`
+
+	// Mirror configures mirroring the repository from an external git host.
+	Mirror MirrorConfig `mapstructure:"mirror"`
+
+	// Deploy configures which pushed refs are deployed.
+	Deploy DeployConfig `mapstructure:"deploy"`
+
+	// Rules configures the rules pushed refs have to follow.
+	Rules RulesConfig `mapstructure:"rules"`
+
+	// Checkout configures fetching the submodules of the working tree the project is deployed from.
+	Checkout CheckoutConfig `mapstructure:"checkout"`
+
+	// Deployments deploy the repository multiple times. Each deployment overrides the deploy settings.
+	Deployments []DeploymentConfig `mapstructure:"deployments"`
+
+	// PushMirrors are remote repositories every push to the repository is pushed to.
+	PushMirrors []PushMirrorConfig `mapstructure:"push-mirrors"`
+
+	// Backups configures backing up the volumes of the project. Each deployment is backed up on its own.
+	Backups BackupConfig `mapstructure:"backups"`
+
+	// Jobs are commands run on a schedule in a one-off container of a service of the project.
+	Jobs []JobConfig `mapstructure:"jobs"`
+}
+
+// DeploymentConfig configures one of multiple deployments of a repository.
+type DeploymentConfig struct {
+	// Name is the name of the deployment, e.g. api. It is appended to the compose project name.
+	Name string `mapstructure:"name"`
+
+	// Refs, Tags, Directory and ComposeFiles override the deploy settings of the project if set.
+	Refs         string   `mapstructure:"refs"`
+	Tags         string   `mapstructure:"tags"`
+	Directory    string   `mapstructure:"directory"`
+	ComposeFiles []string `mapstructure:"compose-files"`
+
+	// PublicNetworks override the public networks of the project if set.
+	PublicNetworks []PublicNetworkConfig `mapstructure:"public-networks"`
+
+	// SkipUnchanged skips deploying pushes that don't change the directory or compose files of the deployment.
+	SkipUnchanged bool `mapstructure:"skip-unchanged"`
+}
+
+// CheckoutConfig configures the credentials used to fetch private submodules.
+type CheckoutConfig struct {
+	// SSHKey is the private key used to fetch submodules over ssh.
+	SSHKey string `mapstructure:"ssh-key"`
+
+	// Credentials are used to fetch submodules over https.
+	Credentials []Credential `mapstructure:"credentials"`
+}
+
+// Credential authenticates fetching from remotes whose URL starts with URL.
+type Credential struct {
+	URL      string `mapstructure:"url"`
+	Username string `mapstructure:"username"`
+	Password string `mapstructure:"password"`
+}
+
+// RulesConfig configures the rules pushed refs have to follow.
+type RulesConfig struct {
+	// ProtectDefaultBranch blocks force pushes and deletions of the default branch.
+	ProtectDefaultBranch bool `mapstructure:"protect-default-branch"`
+
+	// ProtectedRefs are patterns of additional refs that can't be force pushed or deleted.
+	ProtectedRefs []string `mapstructure:"protected-refs"`
+
+	// AllowedSigners is an ssh allowed signers file. If set, pushed commits and tags have to be signed by one of its keys.
+	AllowedSigners string `mapstructure:"allowed-signers"`
+
+	// SignedRefs are patterns of the refs that require signatures. Every ref does if empty.
+	SignedRefs []string `mapstructure:"signed-refs"`
+
+	// Permissions restrict which identities can update refs.
+	Permissions []RefPermission `mapstructure:"permissions"`
+}
+
+// RefPermission allows identities to update refs. Refs matching a permission can only be
+// updated by the identities of the permissions they match.
+type RefPermission struct {
+	// Refs are patterns of the refs the permission applies to, e.g. refs/tags/*.
+	Refs []string `mapstructure:"refs"`
+
+	// Identities can update the refs.
+	Identities []string `mapstructure:"identities"`
+}
+
+// DeployConfig configures which pushed refs are deployed.
+type DeployConfig struct {
+	// Refs is the deploy ref policy, one of default-branch, tags or pushed. Defaults to default-branch.
+	Refs string `mapstructure:"refs"`
+
+	// Tags is the pattern annotated tags have to match to be deployed by the tags policy, e.g. v*.
+	Tags string `mapstructure:"tags"`
+
+	// RejectOtherRefs rejects pushes of refs the policy doesn't deploy.
+	RejectOtherRefs bool `mapstructure:"reject-other-refs"`
+
+	// Directory is the subdirectory of the repository docker-compose runs in, e.g. services/api.
+	Directory string `mapstructure:"directory"`
+
+	// ComposeFiles are the compose files to use, relative to Directory. docker-compose looks for docker-compose.yml if empty.
+	ComposeFiles []string `mapstructure:"compose-files"`
+
+	// SkipUnchanged skips deploying pushes that don't change Directory or the compose files.
+	SkipUnchanged bool `mapstructure:"skip-unchanged"`
+
+	// Shared are paths relative to Directory that are linked to the shared directory in every
+	// release, so the data they hold outlives the release, e.g. data or uploads.
+	Shared []string `mapstructure:"shared"`
+
+	// PublicNetworks are the compose networks frontend containers are connected to. Defaults to the default network.
+	PublicNetworks []PublicNetworkConfig `mapstructure:"public-networks"`
+}
+
+// PublicNetworkConfig configures a compose network of a project that frontend containers are connected to.
+type PublicNetworkConfig struct {
+	// Name is the name of the network in the compose files, e.g. default or web.
+	Name string `mapstructure:"name"`
+
+	// Frontends are the names of the frontend containers connected to the network.
+	// Every container in frontend-container-name is connected if empty.
+	Frontends []string `mapstructure:"frontends"`
+}
+
+// MirrorConfig configures mirroring a remote repository into the repository of a project.
+type MirrorConfig struct {
+	// URL is the remote repository to mirror. Mirroring is disabled if empty.
+	URL string `mapstructure:"url"`
+
+	// Secret verifies push webhooks sent by the git host.
+	Secret string `mapstructure:"secret"`
+
+	// PollInterval is how often the remote is fetched. Only webhooks trigger fetches if zero.
+	PollInterval time.Duration `mapstructure:"poll-interval"`
+}
+
+// PushMirrorConfig configures pushing the repository of a project to a remote repository.
+type PushMirrorConfig struct {
+	// URL is the remote repository to push to. Credentials can be part of https URLs.
+	URL string `mapstructure:"url"`
+
+	// SSHKey is the private key used to push to ssh URLs instead of the default keys.
+	SSHKey string `mapstructure:"ssh-key"`
+}
+
+// BackupConfig configures scheduled volume backups of a project and how long backups are kept.
+type BackupConfig struct {
+	// Interval is how often the volumes are backed up. Only manual backups are made if zero.
+	Interval time.Duration `mapstructure:"interval"`
+
+	// Keep is the number of backups to keep. Every backup is kept if zero.
+	Keep int `mapstructure:"keep"`
+
+	// MaxAge is how long backups are kept. Backups are kept forever if zero.
+	MaxAge time.Duration `mapstructure:"max-age"`
+
+	// Stop are the services stopped while their volumes are backed up, e.g. a database that
+	// doesn't keep its files consistent while running.
+	Stop []string `mapstructure:"stop"`
+}
+
+// JobConfig configures a command that runs on a schedule using docker-compose run.
+type JobConfig struct {
+	// Name identifies the job, e.g. nightly-report.
+	Name string `mapstructure:"name"`
+
+	// Schedule is a cron expression in the local time of the server, e.g. 0 3 * * * or @hourly.
+	Schedule string `mapstructure:"schedule"`
+
+	// Service is the compose service the one-off container is created from.
+	Service string `mapstructure:"service"`
+
+	// Command overrides the command of the service if set, e.g. [php, artisan, report].
+	Command []string `mapstructure:"command"`
+
+	// Overlap is what happens when a run is due while the previous run is still running,
+	// one of skip, queue or allow. Defaults to skip.
+	Overlap string `mapstructure:"overlap"`
+
+	// Timeout stops runs that take longer. Runs aren't stopped if zero.
+	Timeout time.Duration `mapstructure:"timeout"`
+}
+
+// Configs returns the project settings from the config file.
+func Configs() []Config {
+	var configs []Config
+
+	err := viper.UnmarshalKey("projects", &configs)
+	if err != nil {
+		log.Println("Error parsing project settings:", err)
+		return nil
+	}
+
+	return configs
+}
+
+// Config returns the settings of the project. An entry with the exact project name
+// is used if one exists, otherwise the first entry with a matching pattern. The deploy
+// settings of a deployment are applied to the deploy settings of the project.
+func (p Project) Config() Config {
+	config := p.repositoryConfig()
+
+	if p.Deployment == "" {
+		return config
+	}
+
+	for _, deployment := range config.Deployments {
+		if deployment.Name != p.Deployment {
+			continue
+		}
+
+		if deployment.Refs != "" {
+			config.Deploy.Refs = deployment.Refs
+		}
+
+		if deployment.Tags != "" {
+			config.Deploy.Tags = deployment.Tags
+		}
+
+		if deployment.Directory != "" {
+			config.Deploy.Directory = deployment.Directory
+		}
+
+		if len(deployment.ComposeFiles) > 0 {
+			config.Deploy.ComposeFiles = deployment.ComposeFiles
+		}
+
+		if len(deployment.PublicNetworks) > 0 {
+			config.Deploy.PublicNetworks = deployment.PublicNetworks
+		}
+
+		config.Deploy.SkipUnchanged = config.Deploy.SkipUnchanged || deployment.SkipUnchanged
+	}
+
+	return config
+}
+
+// repositoryConfig returns the settings of the repository of the project.
+func (p Project) repositoryConfig() Config {
+	configs := Configs()
+
+	for _, config := range configs {
+		if strings.Trim(config.Name, "/") == p.Name {
+			return config
+		}
+	}
+
+	for _, config := range configs {
+		if match, err := path.Match(config.Name, p.Name); err == nil && match {
+			return config
+		}
+	}
+
+	return Config{Name: p.Name}
+}
+
+// IsPattern returns whether the settings apply to multiple projects.
+func (c Config) IsPattern() bool {
+	return strings.ContainsAny(c.Name, "*?[")
+}
@@ -0,0 +1,416 @@
+// Package project implements the repository and project layout used by pcompose
+package project
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/antoniomika/pcompose/utils"
+	"github.com/spf13/viper"
+)
+
+// MetadataDirName is the directory inside of a repository that holds pcompose metadata.
+const MetadataDirName = ".pcompose"
+
+// DeploymentSeparator separates the project name from the deployment name, e.g. platform/app:api.
+const DeploymentSeparator = ":"
+
+// ErrNotFound is returned when a project doesn't exist.
+var ErrNotFound = errors.New("project not found")
+
+// ErrInvalidName is returned for project names that could resolve to another project or outside of the data directory.
+var ErrInvalidName = errors.New("invalid project name")
+
+// Project is a repository pushed to pcompose and the compose project deployed from it.
+type Project struct {
+	// Name is the path of the project in the data directory, e.g. user/httpbin.
+	Name string `json:"name"`
+
+	// Deployment is the name of the deployment if the repository is deployed multiple times.
+	Deployment string `json:"deployment,omitempty"`
+
+	// RepoDir is the bare repository of the project.
+	RepoDir string `json:"-"`
+}
+
+// DataDir returns the absolute path of the data directory. Hooks run from within a
+// repository, so a relative data directory is resolved from the executable instead.
+func DataDir() string {
+	dataDir := viper.GetString("data-directory")
+	if path.IsAbs(dataDir) {
+		return path.Clean(dataDir)
+	}
+
+	if strings.HasPrefix(os.Args[0], utils.HooksDirName) {
+		executable, err := os.Executable()
+		if err != nil {
+			log.Println("Error getting executable path:", err)
+		}
+
+		executablePath, err := filepath.EvalSymlinks(executable)
+		if err != nil {
+			log.Println("Unable to evaluate symlink:", err)
+		}
+
+		return path.Join(path.Dir(executablePath), dataDir)
+	}
+
+	absDataDir, err := filepath.Abs(dataDir)
+	if err != nil {
+		log.Println("Error getting data directory:", err)
+		return dataDir
+	}
+
+	return absDataDir
+}
+
+// CleanName returns a project or repository name without surrounding slashes and the .git suffix,
+// e.g. user/httpbin for /user/httpbin.git. Names are joined with the data directory, so names
+// with empty, "." or ".." segments are rejected instead of being resolved.
+func CleanName(name string) (string, error) {
+	name = strings.Trim(strings.TrimSuffix(strings.Trim(name, "/"), ".git"), "/")
+
+	repo, deployment, hasDeployment := strings.Cut(name, DeploymentSeparator)
+
+	segments := strings.Split(repo, "/")
+	if hasDeployment {
+		if strings.Contains(deployment, "/") {
+			return "", ErrInvalidName
+		}
+
+		segments = append(segments, deployment)
+	}
+
+	for _, segment := range segments {
+		if segment == "" || segment == "." || segment == ".." {
+			return "", ErrInvalidName
+		}
+	}
+
+	return name, nil
+}
+
+// CleanRepoName returns a repository name like CleanName. Deployments share the repository of
+// their project, so names of deployments are rejected instead of creating a repository for them.
+func CleanRepoName(name string) (string, error) {
+	name, err := CleanName(name)
+	if err != nil {
+		return "", err
+	}
+
+	if strings.Contains(name, DeploymentSeparator) {
+		return "", ErrInvalidName
+	}
+
+	return name, nil
+}
+
+// ServerPath resolves a relative path from the config against the working directory of the server,
+// like every other relative path. Hooks run from within a repository, so they use the working
+// directory the server passes them.
+func ServerPath(name string) string {
+	if name == "" || path.IsAbs(name) {
+		return name
+	}
+
+	workingDir := os.Getenv(utils.WorkingDirEnv)
+	if workingDir == "" {
+		var err error
+
+		workingDir, err = os.Getwd()
+		if err != nil {
+			log.Println("Error getting working directory:", err)
+			return name
+		}
+	}
+
+	return path.Join(workingDir, name)
+}
+
+// RepoDir returns the bare repository directory of a project or repository name.
+func RepoDir(name string) string {
+	return strings.TrimSuffix(path.Join(DataDir(), name), ".git")
+}
+
+// New returns the project with a project or repository name. The name may select
+// a deployment of the project, e.g. platform/app:api.
+func New(name string) Project {
+	name, deployment, _ := strings.Cut(name, DeploymentSeparator)
+
+	return Project{
+		Name:       strings.Trim(strings.TrimSuffix(name, ".git"), "/"),
+		Deployment: deployment,
+		RepoDir:    RepoDir(name),
+	}
+}
+
+// FromRepoDir returns the project a bare repository belongs to.
+func FromRepoDir(repoDir string) Project {
+	return Project{
+		Name:    strings.TrimPrefix(repoDir, DataDir()+string(os.PathSeparator)),
+		RepoDir: repoDir,
+	}
+}
+
+// Get returns an existing project.
+func Get(name string) (Project, error) {
+	p := New(name)
+
+	if _, err := CleanName(name); err != nil || !isRepository(p.RepoDir) {
+		return p, ErrNotFound
+	}
+
+	for _, deployment := range p.Deployments() {
+		if deployment.Deployment == p.Deployment {
+			return p, nil
+		}
+	}
+
+	return p, ErrNotFound
+}
+
+// List returns every project in the data directory, with a project per deployment of repositories deployed multiple times.
+func List() ([]Project, error) {
+	var projects []Project
+
+	dataDir := DataDir()
+
+	err := filepath.WalkDir(dataDir, func(walkPath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if walkPath == dataDir && os.IsNotExist(err) {
+				return filepath.SkipDir
+			}
+
+			return err
+		}
+
+		if !d.IsDir() || walkPath == dataDir {
+			return nil
+		}
+
+		if isRepository(walkPath) {
+			projects = append(projects, FromRepoDir(walkPath).Deployments()...)
+			return filepath.SkipDir
+		}
+
+		return nil
+	})
+
+	sort.Slice(projects, func(i, j int) bool {
+		return projects[i].FullName() < projects[j].FullName()
+	})
+
+	return projects, err
+}
+
+// FromComposeProject returns the project deployed as a compose project, e.g. user/httpbin for user_httpbin.
+func FromComposeProject(composeProject string) (Project, error) {
+	projects, err := List()
+	if err != nil {
+		return Project{}, err
+	}
+
+	for _, p := range projects {
+		if p.ComposeProject() == composeProject {
+			return p, nil
+		}
+	}
+
+	return Project{}, ErrNotFound
+}
+
+// isRepository returns whether a directory is a bare repository managed by pcompose.
+func isRepository(dir string) bool {
+	if _, err := os.Stat(path.Join(dir, "HEAD")); err != nil {
+		return false
+	}
+
+	_, err := os.Lstat(path.Join(dir, utils.HooksDirName, "post-receive"))
+	return err == nil
+}
+
+// FullName returns the name of the project including the deployment, e.g. platform/app:api.
+func (p Project) FullName() string {
+	if p.Deployment == "" {
+		return p.Name
+	}
+
+	return p.Name + DeploymentSeparator + p.Deployment
+}
+
+// Deployments returns a project per deployment configured for the repository,
+// or only the project itself if it is deployed once.
+func (p Project) Deployments() []Project {
+	configs := p.Config().Deployments
+	if len(configs) == 0 {
+		return []Project{p}
+	}
+
+	deployments := make([]Project, 0, len(configs))
+
+	for _, config := range configs {
+		if config.Name == "" || strings.ContainsAny(config.Name, "/.:") {
+			log.Printf("Ignoring deployment %q of %s, deployment names can't be empty or contain /, . or :", config.Name, p.Name)
+			continue
+		}
+
+		deployments = append(deployments, Project{
+			Name:       p.Name,
+			Deployment: config.Name,
+			RepoDir:    p.RepoDir,
+		})
+	}
+
+	return deployments
+}
+
+// ComposeProject returns the docker-compose project name of the project.
+func (p Project) ComposeProject() string {
+	composeProject := strings.ReplaceAll(p.Name, string(os.PathSeparator), "_")
+	if p.Deployment == "" {
+		return composeProject
+	}
+
+	return composeProject + "_" + p.Deployment
+}
+
+// deploymentRoot returns the directory that holds the releases and shared data of the project.
+// Each deployment has its own directory.
+func (p Project) deploymentRoot() string {
+	if p.Deployment == "" {
+		return path.Join(p.RepoDir, path.Base(p.RepoDir))
+	}
+
+	return path.Join(p.RepoDir, "deployments", p.Deployment)
+}
+
+// DeploymentDir returns the working tree the project is deployed from, which links to the current release.
+func (p Project) DeploymentDir() string {
+	return path.Join(p.deploymentRoot(), "current")
+}
+
+// ReleasesDir returns the directory that holds a working tree for each deployed revision.
+func (p Project) ReleasesDir() string {
+	return path.Join(p.deploymentRoot(), "releases")
+}
+
+// SharedDir returns the directory that persists data such as volumes across releases.
+func (p Project) SharedDir() string {
+	return path.Join(p.deploymentRoot(), "shared")
+}
+
+// ComposeDir returns the directory of the working tree docker-compose runs in.
+func (p Project) ComposeDir() string {
+	return path.Join(p.DeploymentDir(), p.Config().Deploy.Directory)
+}
+
+// MetadataDir returns the directory that holds pcompose metadata for the project, such as
+// its deploy history. Each deployment has its own metadata directory.
+func (p Project) MetadataDir() string {
+	if p.Deployment == "" {
+		return path.Join(p.RepoDir, MetadataDirName)
+	}
+
+	return path.Join(p.RepoDir, MetadataDirName, "deployments", p.Deployment)
+}
+
+// InitRepository creates the bare repository if it doesn't exist and installs the pcompose hooks.
+func InitRepository(repoDir string) error {
+	if _, err := os.Stat(repoDir); os.IsNotExist(err) {
+		err := os.MkdirAll(repoDir, os.FileMode(0755))
+		if err != nil {
+			log.Println("Error creating directory:", err)
+		}
+
+		initCmd := exec.Command("git", "init", "--bare")
+		initCmd.Env = append(initCmd.Env, fmt.Sprintf("GIT_DIR=%s", repoDir))
+
+		err = initCmd.Run()
+		if err != nil {
+			log.Println("Error creating repository:", err)
+		}
+	}
+
+	hooksDir := path.Join(repoDir, utils.HooksDirName)
+
+	for _, hook := range []string{"pre-receive", "update", "post-receive"} {
+		hookName := path.Join(hooksDir, hook)
+
+		executable, err := os.Executable()
+		if err != nil {
+			return fmt.Errorf("unable to get executable: %w", err)
+		}
+
+		err = os.Symlink(executable, hookName)
+		if err != nil && !os.IsExist(err) {
+			log.Println("Error symlinking file:", err)
+		}
+
+		err = os.Chmod(hookName, os.ModePerm)
+		if err != nil {
+			return fmt.Errorf("unable to chmod hook: %w", err)
+		}
+	}
+
+	err := os.Symlink(path.Join(viper.GetString("data-directory"), utils.HooksConfigFile), path.Join(hooksDir, utils.HooksConfigFile))
+	if err != nil && !os.IsExist(err) {
+		log.Println("Error symlinking file:", err)
+	}
+
+	return nil
+}
+
+// DefaultBranch returns the ref HEAD of a repository points to, e.g. refs/heads/main.
+func DefaultBranch(repoDir string) string {
+	headCmd := exec.Command("git", "symbolic-ref", "HEAD")
+	headCmd.Env = append(headCmd.Env, fmt.Sprintf("GIT_DIR=%s", repoDir))
+
+	output, err := headCmd.Output()
+	if err != nil {
+		log.Println("Error getting default branch:", err)
+		return ""
+	}
+
+	return strings.TrimSpace(string(output))
+}
+
+// SetDefaultBranch points HEAD of a repository to a branch.
+func SetDefaultBranch(repoDir string, ref string) error {
+	headCmd := exec.Command("git", "symbolic-ref", "HEAD", ref)
+	headCmd.Env = append(headCmd.Env, fmt.Sprintf("GIT_DIR=%s", repoDir))
+
+	return headCmd.Run()
+}
+
+// Refs returns a map of ref names to the revision they point to in a repository.
+func Refs(repoDir string) map[string]string {
+	refs := map[string]string{}
+
+	refsCmd := exec.Command("git", "for-each-ref", "--format=%(objectname) %(refname)")
+	refsCmd.Env = append(refsCmd.Env, fmt.Sprintf("GIT_DIR=%s", repoDir))
+
+	output, err := refsCmd.Output()
+	if err != nil {
+		log.Println("Error listing repository refs:", err)
+		return refs
+	}
+
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+
+		refs[fields[1]] = fields[0]
+	}
+
+	return refs
+}
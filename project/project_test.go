@@ -0,0 +1,65 @@
+package project
+
+import "testing"
+
+func TestCleanName(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    string
+		wantErr bool
+	}{
+		{name: "alice/app", want: "alice/app"},
+		{name: "/alice/app.git", want: "alice/app"},
+		{name: "alice/app/", want: "alice/app"},
+		{name: "platform/app:api", want: "platform/app:api"},
+		{name: "", wantErr: true},
+		{name: "/", wantErr: true},
+		{name: "alice/../bob/app", wantErr: true},
+		{name: "../app", wantErr: true},
+		{name: "alice/..", wantErr: true},
+		{name: "alice/./app", wantErr: true},
+		{name: "alice//app", wantErr: true},
+		{name: "alice/app:..", wantErr: true},
+		{name: "alice/app:api/../x", wantErr: true},
+		{name: "alice/app:", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := CleanName(tt.name)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CleanName(%q) error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			}
+
+			if got != tt.want {
+				t.Errorf("CleanName(%q) = %q, want %q", tt.name, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCleanRepoName(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    string
+		wantErr bool
+	}{
+		{name: "/platform/app.git", want: "platform/app"},
+		{name: "platform/app:api", wantErr: true},
+		{name: "/platform/app:api.git", wantErr: true},
+		{name: "platform/../app", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := CleanRepoName(tt.name)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CleanRepoName(%q) error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			}
+
+			if got != tt.want {
+				t.Errorf("CleanRepoName(%q) = %q, want %q", tt.name, got, tt.want)
+			}
+		})
+	}
+}
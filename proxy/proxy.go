@@ -0,0 +1,306 @@
+// Package proxy implements the reverse proxy with automatic TLS built into pcompose
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/antoniomika/pcompose/frontend"
+	"github.com/spf13/viper"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// refreshInterval is how often routes are discovered in case a container event was missed.
+const refreshInterval = 10 * time.Second
+
+const (
+	// readHeaderTimeout is how long clients have to send the headers of a request.
+	readHeaderTimeout = 10 * time.Second
+
+	// readTimeout is how long clients have to send a whole request, including uploads.
+	readTimeout = 10 * time.Minute
+
+	// idleTimeout is how long keep-alive connections are kept open between requests.
+	idleTimeout = 2 * time.Minute
+)
+
+// proxy routes requests to containers using the routes discovered from their labels.
+type proxy struct {
+	mu       sync.RWMutex
+	routes   table
+	refresh  chan struct{}
+	upstream *http.Transport
+
+	// conflicts holds the conflicting routes of the last discovery, so they are only logged once.
+	conflicts map[string]bool
+}
+
+// Start runs the reverse proxy if the pcompose frontend is configured. It serves HTTP on
+// proxy-http-address and HTTPS on proxy-https-address, with certificates requested using ACME.
+func Start() {
+	if viper.GetString("frontend") != frontend.KindEmbedded {
+		return
+	}
+
+	p := &proxy{
+		routes:  table{},
+		refresh: make(chan struct{}, 1),
+		upstream: &http.Transport{
+			DialContext: (&net.Dialer{
+				Timeout:   10 * time.Second,
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			MaxIdleConnsPerHost: 16,
+			IdleConnTimeout:     90 * time.Second,
+		},
+	}
+
+	p.discover()
+
+	frontend.OnChange(p.requestRefresh)
+
+	go p.watch()
+	go p.watchEvents()
+
+	manager, err := certManager(p)
+	if err != nil {
+		log.Println("Error starting proxy:", err)
+		return
+	}
+
+	go func() {
+		server := newServer(viper.GetString("proxy-https-address"), p)
+		server.TLSConfig = manager.TLSConfig()
+
+		log.Println("Starting proxy HTTPS service on address:", server.Addr)
+
+		err := server.ListenAndServeTLS("", "")
+		if err != nil {
+			log.Println("Error serving proxy HTTPS:", err)
+		}
+	}()
+
+	// HTTP-01 challenges are answered before requests are routed.
+	server := newServer(viper.GetString("proxy-http-address"), manager.HTTPHandler(p))
+
+	log.Println("Starting proxy HTTP service on address:", server.Addr)
+
+	err = server.ListenAndServe()
+	if err != nil {
+		log.Println("Error serving proxy HTTP:", err)
+	}
+}
+
+// newServer returns a server of the proxy, which times out clients that are slow to send requests
+// so they can't hold connections open.
+func newServer(addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: readHeaderTimeout,
+		ReadTimeout:       readTimeout,
+		IdleTimeout:       idleTimeout,
+	}
+}
+
+// certManager returns the ACME certificate manager, which only requests certificates
+// for hosts that have routes using TLS. Only projects deployed by pcompose have routes,
+// so other containers can't get certificates issued.
+func certManager(p *proxy) (*autocert.Manager, error) {
+	httpClient := http.DefaultClient
+
+	if caFile := viper.GetString("proxy-acme-ca-certificates"); caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading ACME CA certificates: %w", err)
+		}
+
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+
+		httpClient = &http.Client{
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: &tls.Config{RootCAs: pool},
+			},
+		}
+	}
+
+	return &autocert.Manager{
+		Prompt: autocert.AcceptTOS,
+		Cache:  autocert.DirCache(viper.GetString("proxy-certificates-directory")),
+		Email:  viper.GetString("proxy-acme-email"),
+		HostPolicy: func(_ context.Context, host string) error {
+			if !p.table().tls(host) {
+				return fmt.Errorf("no route for %s uses TLS", host)
+			}
+
+			return nil
+		},
+		Client: &acme.Client{
+			DirectoryURL: viper.GetString("proxy-acme-directory-url"),
+			HTTPClient:   httpClient,
+		},
+	}, nil
+}
+
+// table returns the current route table.
+func (p *proxy) table() table {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.routes
+}
+
+// requestRefresh discovers the routes again soon, e.g. because a project was deployed.
+func (p *proxy) requestRefresh() {
+	select {
+	case p.refresh <- struct{}{}:
+	default:
+	}
+}
+
+// watchEvents requests a refresh whenever a container starts, stops or changes networks, which
+// covers deploys run by git hooks in other processes.
+func (p *proxy) watchEvents() {
+	for {
+		cmd := exec.Command("docker", "events", "--format", "{{.Type}} {{.Action}}",
+			"--filter", "event=start", "--filter", "event=die",
+			"--filter", "event=connect", "--filter", "event=disconnect",
+		)
+
+		stdout, err := cmd.StdoutPipe()
+		if err == nil {
+			err = cmd.Start()
+		}
+
+		if err != nil {
+			log.Println("Error watching container events:", err)
+			time.Sleep(refreshInterval)
+
+			continue
+		}
+
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			p.requestRefresh()
+		}
+
+		_ = cmd.Wait()
+
+		time.Sleep(refreshInterval)
+	}
+}
+
+// watch discovers the routes every refreshInterval and whenever a refresh is requested.
+func (p *proxy) watch() {
+	ticker := time.NewTicker(refreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-p.refresh:
+		}
+
+		p.discover()
+	}
+}
+
+// discover replaces the route table with the routes of the running containers. The previous
+// routes are kept if they can't be discovered.
+func (p *proxy) discover() {
+	routes, conflicts, err := discover()
+	if err != nil {
+		log.Println("Error discovering routes:", err)
+		return
+	}
+
+	p.mu.Lock()
+	p.routes = routes
+	p.mu.Unlock()
+
+	seen := map[string]bool{}
+
+	for _, conflict := range conflicts {
+		if !p.conflicts[conflict] {
+			log.Println("Ignoring route:", conflict)
+		}
+
+		seen[conflict] = true
+	}
+
+	p.conflicts = seen
+}
+
+// ServeHTTP proxies a request to a container of its route. Plain HTTP requests for routes
+// using TLS are redirected to HTTPS.
+func (p *proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	host := strings.ToLower(r.Host)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	rt := p.table().lookup(host, r.URL.Path)
+	if rt == nil {
+		http.Error(w, "No route for this host", http.StatusNotFound)
+		return
+	}
+
+	if r.TLS == nil && rt.tls {
+		http.Redirect(w, r, httpsURL(host, r.URL.RequestURI()), http.StatusPermanentRedirect)
+		return
+	}
+
+	proto := "http"
+	if r.TLS != nil {
+		proto = "https"
+	}
+
+	upstream := rt.upstream()
+
+	// Upgrade requests, e.g. WebSockets, are proxied as well.
+	reverseProxy := &httputil.ReverseProxy{
+		Director: func(req *http.Request) {
+			req.URL.Scheme = "http"
+			req.URL.Host = upstream
+			req.Header.Set("X-Forwarded-Proto", proto)
+			req.Header.Set("X-Forwarded-Host", r.Host)
+		},
+		Transport: p.upstream,
+		ErrorHandler: func(w http.ResponseWriter, req *http.Request, err error) {
+			log.Printf("Error proxying request for %s to %s: %s", host, upstream, err)
+			w.WriteHeader(http.StatusBadGateway)
+		},
+	}
+
+	reverseProxy.ServeHTTP(w, r)
+}
+
+// httpsURL returns the URL of a request on the HTTPS address of the proxy.
+func httpsURL(host string, requestURI string) string {
+	if _, port, err := net.SplitHostPort(viper.GetString("proxy-https-address")); err == nil && port != "443" && port != "" {
+		host = net.JoinHostPort(host, port)
+	}
+
+	return fmt.Sprintf("https://%s%s", host, requestURI)
+}
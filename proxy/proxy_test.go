@@ -0,0 +1,100 @@
+package proxy
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// echoUpgrade is a backend that switches protocols and echoes every line it receives.
+func echoUpgrade(w http.ResponseWriter, r *http.Request) {
+	conn, rw, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		return
+	}
+
+	defer conn.Close()
+
+	fmt.Fprint(rw, "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+	rw.Flush()
+
+	for {
+		line, err := rw.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		fmt.Fprint(rw, line)
+		rw.Flush()
+	}
+}
+
+func TestServeHTTPUpgradeOutlivesReadTimeout(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(echoUpgrade))
+	defer backend.Close()
+
+	p := &proxy{
+		routes: table{
+			"app.example.com": {{host: "app.example.com", upstreams: []string{backend.Listener.Addr().String()}}},
+		},
+		upstream: &http.Transport{},
+	}
+
+	server := httptest.NewUnstartedServer(p)
+	server.Config = newServer("", p)
+	server.Config.ReadTimeout = 200 * time.Millisecond
+	server.Start()
+
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer conn.Close()
+
+	fmt.Fprint(conn, "GET / HTTP/1.1\r\nHost: app.example.com\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+
+	reader := bufio.NewReader(conn)
+
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("upgrade status = %s, want 101", resp.Status)
+	}
+
+	// Hijacking clears the deadlines of the connection, so upgraded connections such as WebSockets
+	// stay open for longer than a request may take.
+	time.Sleep(3 * server.Config.ReadTimeout)
+
+	fmt.Fprint(conn, "ping\n")
+
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	line, err := reader.ReadString('\n')
+	if err != nil && err != io.EOF {
+		t.Fatalf("reading from the upgraded connection: %v", err)
+	}
+
+	if strings.TrimSpace(line) != "ping" {
+		t.Errorf("upgraded connection echoed %q, want ping", line)
+	}
+}
+
+func TestNewServerTimeouts(t *testing.T) {
+	server := newServer(":80", http.NotFoundHandler())
+
+	if server.ReadHeaderTimeout <= 0 || server.ReadTimeout <= 0 || server.IdleTimeout <= 0 {
+		t.Errorf("newServer() timeouts = %s, %s, %s, want all of them set", server.ReadHeaderTimeout, server.ReadTimeout, server.IdleTimeout)
+	}
+}
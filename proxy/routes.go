@@ -0,0 +1,269 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/antoniomika/pcompose/frontend"
+	"github.com/antoniomika/pcompose/project"
+)
+
+// route routes requests for a host and path prefix to the containers serving it.
+type route struct {
+	host      string
+	path      string
+	tls       bool
+	upstreams []string
+	next      uint32
+}
+
+// matches returns whether a request path matches the path prefix of the route.
+func (r *route) matches(requestPath string) bool {
+	if r.path == "" || r.path == "/" {
+		return true
+	}
+
+	prefix := strings.TrimSuffix(r.path, "/")
+
+	return requestPath == prefix || strings.HasPrefix(requestPath, prefix+"/")
+}
+
+// upstream returns the address of the next container of the route, taking turns between them.
+func (r *route) upstream() string {
+	n := atomic.AddUint32(&r.next, 1)
+	return r.upstreams[int(n-1)%len(r.upstreams)]
+}
+
+// table maps hosts to their routes, longest path prefixes first.
+type table map[string][]*route
+
+// lookup returns the route of a request, or nil if there is none.
+func (t table) lookup(host string, requestPath string) *route {
+	for _, r := range t[host] {
+		if r.matches(requestPath) {
+			return r
+		}
+	}
+
+	return nil
+}
+
+// tls returns whether a host has routes using TLS, which it gets a certificate for.
+func (t table) tls(host string) bool {
+	for _, r := range t[host] {
+		if r.tls {
+			return true
+		}
+	}
+
+	return false
+}
+
+// composeProjectLabel is the label docker-compose sets to the compose project of a container.
+const composeProjectLabel = "com.docker.compose.project"
+
+// container is the part of docker inspect the routes of a container are read from.
+type container struct {
+	Labels map[string]string
+	// Networks maps the networks the container is connected to to its address in them.
+	Networks map[string]struct {
+		IPAddress string
+	}
+}
+
+// discover builds the route table from the labels of the running containers of the projects
+// deployed by pcompose, and returns the conflicting routes that were ignored.
+func discover() (table, []string, error) {
+	projects, err := project.List()
+	if err != nil {
+		return nil, nil, fmt.Errorf("error listing projects: %w", err)
+	}
+
+	output, err := exec.Command("docker", "ps", "--quiet", "--no-trunc").Output()
+	if err != nil {
+		return nil, nil, fmt.Errorf("error listing containers: %w", err)
+	}
+
+	ids := strings.Fields(string(output))
+	if len(ids) == 0 {
+		return table{}, nil, nil
+	}
+
+	output, err = exec.Command("docker", append([]string{"inspect", "--format", `{"Labels":{{json .Config.Labels}},"Networks":{{json .NetworkSettings.Networks}}}`}, ids...)...).Output()
+	if err != nil {
+		return nil, nil, fmt.Errorf("error inspecting containers: %w", err)
+	}
+
+	var containers []container
+
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		var c container
+
+		err := json.Unmarshal([]byte(line), &c)
+		if err != nil {
+			continue
+		}
+
+		containers = append(containers, c)
+	}
+
+	t, conflicts := buildTable(containers, projects)
+
+	return t, conflicts, nil
+}
+
+// buildTable builds the route table from the routes of containers. Only containers of the compose
+// projects of projects are routed, so other containers on the host can't claim hosts. A host belongs
+// to the first project that routes it, in the order of projects, and the routes other projects have
+// for it are ignored and returned as conflicts.
+func buildTable(containers []container, projects []project.Project) (table, []string) {
+	order := map[string]int{}
+	for i, p := range projects {
+		order[p.ComposeProject()] = i
+	}
+
+	var deployed []container
+
+	for _, c := range containers {
+		if _, ok := order[c.Labels[composeProjectLabel]]; ok {
+			deployed = append(deployed, c)
+		}
+	}
+
+	sort.SliceStable(deployed, func(i, j int) bool {
+		return order[deployed[i].Labels[composeProjectLabel]] < order[deployed[j].Labels[composeProjectLabel]]
+	})
+
+	owners := map[string]string{}
+	routes := map[string]*route{}
+	conflicts := map[string]bool{}
+
+	for _, c := range deployed {
+		composeProject := c.Labels[composeProjectLabel]
+
+		for _, r := range containerRoutes(c) {
+			if owner, ok := owners[r.host]; ok && owner != composeProject {
+				conflicts[fmt.Sprintf("%s claimed by %s is already routed to %s", r.host, composeProject, owner)] = true
+				continue
+			}
+
+			owners[r.host] = composeProject
+
+			key := r.host + r.path
+
+			existing, ok := routes[key]
+			if !ok {
+				routes[key] = r
+				continue
+			}
+
+			existing.upstreams = append(existing.upstreams, r.upstreams...)
+			existing.tls = existing.tls || r.tls
+		}
+	}
+
+	t := table{}
+
+	for _, r := range routes {
+		t[r.host] = append(t[r.host], r)
+	}
+
+	for _, hostRoutes := range t {
+		sort.Slice(hostRoutes, func(i, j int) bool {
+			return len(hostRoutes[i].path) > len(hostRoutes[j].path)
+		})
+	}
+
+	conflictList := make([]string, 0, len(conflicts))
+	for conflict := range conflicts {
+		conflictList = append(conflictList, conflict)
+	}
+
+	sort.Strings(conflictList)
+
+	return t, conflictList
+}
+
+// containerRoutes returns the routes a container has labels for, e.g. pcompose.route.web.host.
+// Routes are reached on the network named in their labels, or any network of the container.
+func containerRoutes(c container) []*route {
+	type routeLabels map[string]string
+
+	byName := map[string]routeLabels{}
+
+	for key, value := range c.Labels {
+		rest := strings.TrimPrefix(key, frontend.RouteLabel+".")
+		if rest == key {
+			continue
+		}
+
+		name, field, ok := strings.Cut(rest, ".")
+		if !ok {
+			continue
+		}
+
+		if byName[name] == nil {
+			byName[name] = routeLabels{}
+		}
+
+		byName[name][field] = value
+	}
+
+	var routes []*route
+
+	for _, labels := range byName {
+		host := strings.ToLower(labels["host"])
+		if host == "" {
+			continue
+		}
+
+		port := 80
+		if p, err := strconv.Atoi(labels["port"]); err == nil {
+			port = p
+		}
+
+		address := containerAddress(c, labels["network"])
+		if address == "" {
+			continue
+		}
+
+		tls, _ := strconv.ParseBool(labels["tls"])
+
+		routes = append(routes, &route{
+			host:      host,
+			path:      labels["path"],
+			tls:       tls,
+			upstreams: []string{fmt.Sprintf("%s:%d", address, port)},
+		})
+	}
+
+	return routes
+}
+
+// containerAddress returns the address of a container in a network, or in the first of its
+// networks if network is empty or the container isn't connected to it.
+func containerAddress(c container, network string) string {
+	if n, ok := c.Networks[network]; ok && n.IPAddress != "" {
+		return n.IPAddress
+	}
+
+	var names []string
+	for name := range c.Networks {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		if address := c.Networks[name].IPAddress; address != "" {
+			return address
+		}
+	}
+
+	return ""
+}
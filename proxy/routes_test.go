@@ -0,0 +1,178 @@
+package proxy
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/antoniomika/pcompose/project"
+)
+
+// newContainer returns a container of a compose project with labels, connected to the network default.
+func newContainer(composeProject string, address string, labels map[string]string) container {
+	c := container{
+		Labels: map[string]string{composeProjectLabel: composeProject},
+		Networks: map[string]struct {
+			IPAddress string
+		}{"default": {IPAddress: address}},
+	}
+
+	for key, value := range labels {
+		c.Labels[key] = value
+	}
+
+	return c
+}
+
+func TestRouteMatches(t *testing.T) {
+	tests := []struct {
+		path        string
+		requestPath string
+		want        bool
+	}{
+		{path: "", requestPath: "/anything", want: true},
+		{path: "/", requestPath: "/anything", want: true},
+		{path: "/api", requestPath: "/api", want: true},
+		{path: "/api", requestPath: "/api/users", want: true},
+		{path: "/api/", requestPath: "/api", want: true},
+		{path: "/api/", requestPath: "/api/users", want: true},
+		{path: "/api", requestPath: "/apiary", want: false},
+		{path: "/api", requestPath: "/", want: false},
+		{path: "/api/v2", requestPath: "/api", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path+" "+tt.requestPath, func(t *testing.T) {
+			r := &route{path: tt.path}
+			if got := r.matches(tt.requestPath); got != tt.want {
+				t.Errorf("matches(%q) = %v, want %v", tt.requestPath, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTableLookup(t *testing.T) {
+	api := &route{host: "app.example.com", path: "/api"}
+	root := &route{host: "app.example.com", path: "/"}
+	docs := &route{host: "docs.example.com", path: "/docs"}
+
+	routes := table{
+		"app.example.com":  {api, root},
+		"docs.example.com": {docs},
+	}
+
+	tests := []struct {
+		host        string
+		requestPath string
+		want        *route
+	}{
+		{host: "app.example.com", requestPath: "/api/users", want: api},
+		{host: "app.example.com", requestPath: "/apiary", want: root},
+		{host: "app.example.com", requestPath: "/", want: root},
+		{host: "docs.example.com", requestPath: "/docs/install", want: docs},
+		{host: "docs.example.com", requestPath: "/", want: nil},
+		{host: "other.example.com", requestPath: "/", want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.host+tt.requestPath, func(t *testing.T) {
+			if got := routes.lookup(tt.host, tt.requestPath); got != tt.want {
+				t.Errorf("lookup() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestContainerRoutes(t *testing.T) {
+	c := container{
+		Labels: map[string]string{
+			"pcompose.route.0.host":    "App.Example.com",
+			"pcompose.route.0.port":    "8080",
+			"pcompose.route.0.tls":     "true",
+			"pcompose.route.1.host":    "app.example.com",
+			"pcompose.route.1.path":    "/api",
+			"pcompose.route.1.network": "backend",
+			"pcompose.route.2.port":    "9000",
+			"pcompose.route.invalid":   "app.example.com",
+			"com.example.unrelated":    "true",
+		},
+		Networks: map[string]struct {
+			IPAddress string
+		}{
+			"frontend": {IPAddress: "10.0.1.2"},
+			"backend":  {IPAddress: "10.0.2.2"},
+		},
+	}
+
+	var got []string
+
+	for _, r := range containerRoutes(c) {
+		got = append(got, strings.Join([]string{r.host, r.path, strings.Join(r.upstreams, ","), map[bool]string{true: "tls", false: "plain"}[r.tls]}, " "))
+	}
+
+	sort.Strings(got)
+
+	// Routes without a host are skipped, routes default to port 80 and the first network by name.
+	want := []string{
+		"app.example.com  10.0.2.2:8080 tls",
+		"app.example.com /api 10.0.2.2:80 plain",
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("containerRoutes() = %q, want %q", got, want)
+	}
+
+	c.Networks = nil
+
+	if routes := containerRoutes(c); len(routes) != 0 {
+		t.Errorf("containerRoutes() of a container without networks = %v, want none", routes)
+	}
+}
+
+func TestBuildTable(t *testing.T) {
+	projects := []project.Project{
+		{Name: "alice/app"},
+		{Name: "bob/app"},
+	}
+
+	route := func(host string, path string) map[string]string {
+		return map[string]string{"pcompose.route.0.host": host, "pcompose.route.0.path": path}
+	}
+
+	containers := []container{
+		// Containers of compose projects pcompose didn't deploy can't claim hosts.
+		newContainer("", "10.0.0.9", route("app.example.com", "/admin")),
+		newContainer("mallory_app", "10.0.0.8", route("app.example.com", "/login")),
+		// Hosts belong to the first project routing them, however the containers are ordered.
+		newContainer("bob_app", "10.0.0.3", route("app.example.com", "/api")),
+		newContainer("bob_app", "10.0.0.4", route("bob.example.com", "")),
+		newContainer("alice_app", "10.0.0.1", route("app.example.com", "")),
+		newContainer("alice_app", "10.0.0.2", route("app.example.com", "")),
+	}
+
+	routes, conflicts := buildTable(containers, projects)
+
+	var got []string
+
+	for host, hostRoutes := range routes {
+		for _, r := range hostRoutes {
+			got = append(got, host+r.path+" "+strings.Join(r.upstreams, ","))
+		}
+	}
+
+	sort.Strings(got)
+
+	want := []string{
+		"app.example.com 10.0.0.1:80,10.0.0.2:80",
+		"bob.example.com 10.0.0.4:80",
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("buildTable() routes = %q, want %q", got, want)
+	}
+
+	if !reflect.DeepEqual(conflicts, []string{"app.example.com claimed by bob_app is already routed to alice_app"}) {
+		t.Errorf("buildTable() conflicts = %q", conflicts)
+	}
+}
@@ -0,0 +1,380 @@
+// Package recording implements asciicast v2 recordings of interactive sessions used by pcompose
+package recording
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Extension is the file extension used for recordings.
+const Extension = ".cast"
+
+// Header is the first line of an asciicast v2 recording.
+type Header struct {
+	Version   int               `json:"version"`
+	Width     uint32            `json:"width"`
+	Height    uint32            `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// Event is a single event of an asciicast v2 recording.
+type Event struct {
+	Time float64
+	Type string
+	Data string
+}
+
+// Recorder writes the stream of a session to an asciicast v2 file.
+type Recorder struct {
+	mu     sync.Mutex
+	file   *os.File
+	start  time.Time
+	closed bool
+}
+
+// Enabled returns whether or not session recording is enabled.
+func Enabled() bool {
+	return viper.GetBool("session-recordings")
+}
+
+// Directory returns the directory recordings for a project are stored in.
+func Directory(project string) string {
+	return filepath.Join(viper.GetString("session-recordings-directory"), strings.ReplaceAll(project, string(os.PathSeparator), "_"))
+}
+
+// New creates a recording for a project and writes the asciicast header.
+func New(project string, title string, w uint32, h uint32) (*Recorder, error) {
+	dir := Directory(project)
+
+	err := os.MkdirAll(dir, os.FileMode(0700))
+	if err != nil {
+		return nil, err
+	}
+
+	Prune(project)
+
+	start := time.Now()
+
+	file, err := os.OpenFile(filepath.Join(dir, fmt.Sprintf("%s%s", start.UTC().Format("20060102T150405.000000000Z"), Extension)), os.O_CREATE|os.O_EXCL|os.O_WRONLY, os.FileMode(0600))
+	if err != nil {
+		return nil, err
+	}
+
+	header, err := json.Marshal(Header{
+		Version:   2,
+		Width:     w,
+		Height:    h,
+		Timestamp: start.Unix(),
+		Title:     title,
+		Env: map[string]string{
+			"TERM":  "xterm-256color",
+			"SHELL": "/bin/sh",
+		},
+	})
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	_, err = file.Write(append(header, '\n'))
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return &Recorder{
+		file:  file,
+		start: start,
+	}, nil
+}
+
+// writeEvent appends an event to the recording.
+func (r *Recorder) writeEvent(eventType string, data string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return os.ErrClosed
+	}
+
+	event, err := json.Marshal([]interface{}{time.Since(r.start).Seconds(), eventType, data})
+	if err != nil {
+		return err
+	}
+
+	_, err = r.file.Write(append(event, '\n'))
+	return err
+}
+
+// Output returns a writer that records the data written to it as output events.
+func (r *Recorder) Output() io.Writer {
+	return eventWriter{recorder: r, eventType: "o"}
+}
+
+// Input returns a writer that records the data written to it as input events.
+func (r *Recorder) Input() io.Writer {
+	return eventWriter{recorder: r, eventType: "i"}
+}
+
+// Resize records a window change event.
+func (r *Recorder) Resize(w uint32, h uint32) {
+	err := r.writeEvent("r", fmt.Sprintf("%dx%d", w, h))
+	if err != nil && viper.GetBool("debug") {
+		log.Println("Error recording window change:", err)
+	}
+}
+
+// Close closes the recording file.
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return nil
+	}
+
+	r.closed = true
+
+	return r.file.Close()
+}
+
+// eventWriter is an io.Writer that records writes as events of a type.
+type eventWriter struct {
+	recorder  *Recorder
+	eventType string
+}
+
+// Write records the data as an event. Recording errors never fail the session.
+func (e eventWriter) Write(p []byte) (int, error) {
+	err := e.recorder.writeEvent(e.eventType, string(p))
+	if err != nil && viper.GetBool("debug") {
+		log.Println("Error recording session:", err)
+	}
+
+	return len(p), nil
+}
+
+// Info describes a stored recording.
+type Info struct {
+	Project  string
+	Path     string
+	Start    time.Time
+	Duration time.Duration
+	Size     int64
+	Title    string
+}
+
+// List returns the recordings stored for a project, or for every project if project is empty.
+func List(project string) ([]Info, error) {
+	var dirs []string
+
+	if project != "" {
+		dirs = append(dirs, Directory(project))
+	} else {
+		entries, err := os.ReadDir(viper.GetString("session-recordings-directory"))
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil, nil
+			}
+
+			return nil, err
+		}
+
+		for _, entry := range entries {
+			if entry.IsDir() {
+				dirs = append(dirs, filepath.Join(viper.GetString("session-recordings-directory"), entry.Name()))
+			}
+		}
+	}
+
+	var infos []Info
+
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+
+			return nil, err
+		}
+
+		for _, entry := range entries {
+			if entry.IsDir() || filepath.Ext(entry.Name()) != Extension {
+				continue
+			}
+
+			info, err := Stat(filepath.Join(dir, entry.Name()))
+			if err != nil {
+				log.Println("Error reading recording:", err)
+				continue
+			}
+
+			infos = append(infos, info)
+		}
+	}
+
+	sort.SliceStable(infos, func(i, j int) bool {
+		return infos[i].Start.Before(infos[j].Start)
+	})
+
+	return infos, nil
+}
+
+// Stat reads the header and the last event of a recording.
+func Stat(recordingPath string) (Info, error) {
+	info := Info{
+		Project: filepath.Base(filepath.Dir(recordingPath)),
+		Path:    recordingPath,
+	}
+
+	fileInfo, err := os.Stat(recordingPath)
+	if err != nil {
+		return info, err
+	}
+
+	info.Size = fileInfo.Size()
+
+	header, events, err := Read(recordingPath)
+	if err != nil {
+		return info, err
+	}
+
+	info.Start = time.Unix(header.Timestamp, 0)
+	info.Title = header.Title
+
+	if len(events) > 0 {
+		info.Duration = time.Duration(events[len(events)-1].Time * float64(time.Second))
+	}
+
+	return info, nil
+}
+
+// Read parses a recording into its header and events.
+func Read(recordingPath string) (Header, []Event, error) {
+	var header Header
+	var events []Event
+
+	file, err := os.Open(recordingPath)
+	if err != nil {
+		return header, nil, err
+	}
+
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	if !scanner.Scan() {
+		return header, nil, fmt.Errorf("recording %s is empty", recordingPath)
+	}
+
+	err = json.Unmarshal(scanner.Bytes(), &header)
+	if err != nil {
+		return header, nil, err
+	}
+
+	for scanner.Scan() {
+		var raw []interface{}
+
+		err := json.Unmarshal(scanner.Bytes(), &raw)
+		if err != nil || len(raw) != 3 {
+			// A session that was interrupted can leave a partially written last line.
+			continue
+		}
+
+		eventTime, okTime := raw[0].(float64)
+		eventType, okType := raw[1].(string)
+		eventData, okData := raw[2].(string)
+
+		if !okTime || !okType || !okData {
+			continue
+		}
+
+		events = append(events, Event{
+			Time: eventTime,
+			Type: eventType,
+			Data: eventData,
+		})
+	}
+
+	return header, events, scanner.Err()
+}
+
+// Play writes the output events of a recording to w, preserving their timing.
+// Pauses are capped at maxIdle if it is non-zero and timing is divided by speed.
+func Play(recordingPath string, w io.Writer, speed float64, maxIdle time.Duration) error {
+	_, events, err := Read(recordingPath)
+	if err != nil {
+		return err
+	}
+
+	if speed <= 0 {
+		speed = 1
+	}
+
+	last := 0.0
+
+	for _, event := range events {
+		if event.Type != "o" {
+			continue
+		}
+
+		wait := time.Duration((event.Time - last) / speed * float64(time.Second))
+		last = event.Time
+
+		if maxIdle > 0 && wait > maxIdle {
+			wait = maxIdle
+		}
+
+		time.Sleep(wait)
+
+		_, err := io.WriteString(w, event.Data)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Prune removes the recordings of a project that are past the configured retention.
+func Prune(project string) {
+	infos, err := List(project)
+	if err != nil {
+		log.Println("Error listing recordings:", err)
+		return
+	}
+
+	maxAge := viper.GetDuration("session-recordings-max-age")
+	maxCount := viper.GetInt("session-recordings-max-count")
+
+	for i, info := range infos {
+		expired := maxAge > 0 && time.Since(info.Start) > maxAge
+
+		// New is about to create another recording, so keep room for it.
+		overCount := maxCount > 0 && len(infos)-i >= maxCount
+
+		if !expired && !overCount {
+			continue
+		}
+
+		err := os.Remove(info.Path)
+		if err != nil {
+			log.Println("Error removing recording:", err)
+		}
+	}
+}
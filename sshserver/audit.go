@@ -0,0 +1,128 @@
+package sshserver
+
+import (
+	"errors"
+	"os/exec"
+	"sync"
+
+	"github.com/antoniomika/pcompose/audit"
+	"github.com/antoniomika/pcompose/auth"
+	"github.com/antoniomika/pcompose/metrics"
+	pUtils "github.com/antoniomika/pcompose/utils"
+	"golang.org/x/crypto/ssh"
+)
+
+// fingerprintExtension is the permissions extension that holds the fingerprint of the key used to authenticate.
+const fingerprintExtension = "pubKeyFingerprint"
+
+// offeredKeys holds the fingerprint of the last key offered by a connection that is still authenticating.
+var offeredKeys sync.Map
+
+// wrapAuditCallbacks wraps the ssh server authentication callbacks in order to audit authentication attempts.
+func wrapAuditCallbacks(sshConfig *ssh.ServerConfig) {
+	publicKeyCallback := sshConfig.PublicKeyCallback
+	if publicKeyCallback != nil {
+		sshConfig.PublicKeyCallback = func(c ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			fingerprint := ssh.FingerprintSHA256(key)
+			offeredKeys.Store(c.RemoteAddr().String(), fingerprint)
+
+			perms, err := publicKeyCallback(c, key)
+			if err != nil {
+				return perms, err
+			}
+
+			if perms == nil {
+				perms = &ssh.Permissions{}
+			}
+
+			if perms.Extensions == nil {
+				perms.Extensions = map[string]string{}
+			}
+
+			perms.Extensions[fingerprintExtension] = fingerprint
+
+			return perms, nil
+		}
+	}
+
+	authLogCallback := sshConfig.AuthLogCallback
+	sshConfig.AuthLogCallback = func(c ssh.ConnMetadata, method string, err error) {
+		if authLogCallback != nil {
+			authLogCallback(c, method, err)
+		}
+
+		// Successful attempts are logged once the connection is established and failed
+		// "none" attempts are only used by clients to list the supported methods.
+		if err == nil || method == "none" {
+			return
+		}
+
+		metrics.AuthFailure("ssh", authFailureReason(method, err))
+
+		entry := audit.Entry{
+			Action:     audit.ActionAuthFailure,
+			User:       c.User(),
+			RemoteAddr: c.RemoteAddr().String(),
+			Method:     method,
+			Error:      err.Error(),
+		}
+
+		if method == "publickey" {
+			if fingerprint, ok := offeredKeys.Load(c.RemoteAddr().String()); ok {
+				entry.Fingerprint = fingerprint.(string)
+			}
+		}
+
+		audit.Log(entry)
+	}
+}
+
+// authFailureReason returns why an authentication attempt failed. Methods are chosen
+// by the client, so unknown ones are grouped together to bound the metric labels.
+func authFailureReason(method string, err error) string {
+	switch {
+	case errors.Is(err, auth.ErrKeyRevoked):
+		return "revoked"
+	case errors.Is(err, auth.ErrInvalidCertificate):
+		return "invalid_certificate"
+	case method == "publickey":
+		return "unknown_key"
+	case method == "password":
+		return "invalid_password"
+	case method == "keyboard-interactive":
+		return "keyboard_interactive"
+	default:
+		return "other"
+	}
+}
+
+// auditEntry creates an audit entry populated with the identity of the connection.
+func auditEntry(sshConn *pUtils.SSHConnHolder, action string) audit.Entry {
+	entry := audit.Entry{
+		Action:     action,
+		User:       sshConn.MainConn.User(),
+		RemoteAddr: sshConn.MainConn.RemoteAddr().String(),
+	}
+
+	if sshConn.MainConn.Permissions != nil {
+		entry.Fingerprint = sshConn.MainConn.Permissions.Extensions[fingerprintExtension]
+		entry.Identity = auth.Identity(sshConn.MainConn.Permissions)
+		entry.KeyID = sshConn.MainConn.Permissions.Extensions[auth.KeyIDExtension]
+	}
+
+	return entry
+}
+
+// exitStatusCode returns the exit status of a command from the error returned when running it.
+func exitStatusCode(err error) int {
+	if err == nil {
+		return 0
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+
+	return -1
+}
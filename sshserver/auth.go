@@ -0,0 +1,57 @@
+package sshserver
+
+import (
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+
+	"github.com/antoniomika/pcompose/audit"
+	"github.com/antoniomika/pcompose/auth"
+	"github.com/antoniomika/pcompose/project"
+	pUtils "github.com/antoniomika/pcompose/utils"
+	"golang.org/x/crypto/ssh"
+)
+
+// authorized returns whether the connection may access a project.
+func authorized(sshConn *pUtils.SSHConnHolder, project string) bool {
+	return auth.Authorized(sshConn.MainConn.Permissions, project)
+}
+
+// denyAccess tells the client it may not access a project and audits the attempt.
+func denyAccess(channel ssh.Channel, auditLog audit.Entry, project string) {
+	err := fmt.Errorf("access to %s denied", project)
+
+	_, writeErr := fmt.Fprintln(channel.Stderr(), err)
+	if writeErr != nil {
+		log.Println("Error writing to channel:", writeErr)
+	}
+
+	auditLog.Target = project
+	auditLog.Error = err.Error()
+
+	audit.Log(auditLog)
+}
+
+// containerProject returns the project a container was deployed from, or the container name
+// itself if it doesn't belong to a compose project of pcompose.
+func containerProject(containerName string) string {
+	inspectCmd := exec.Command("docker", "inspect", "-f", `{{ index .Config.Labels "com.docker.compose.project" }}`, containerName)
+
+	output, err := inspectCmd.Output()
+	if err != nil {
+		return containerName
+	}
+
+	composeProject := strings.TrimSpace(string(output))
+	if composeProject == "" {
+		return containerName
+	}
+
+	p, err := project.FromComposeProject(composeProject)
+	if err != nil {
+		return containerName
+	}
+
+	return p.FullName()
+}
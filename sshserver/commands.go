@@ -0,0 +1,435 @@
+package sshserver
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/antoniomika/pcompose/auth"
+	"github.com/antoniomika/pcompose/deploy"
+	"github.com/antoniomika/pcompose/jobs"
+	"github.com/antoniomika/pcompose/mirror"
+	"github.com/antoniomika/pcompose/project"
+	pUtils "github.com/antoniomika/pcompose/utils"
+	"github.com/antoniomika/pcompose/volumes"
+	"github.com/spf13/viper"
+	"golang.org/x/crypto/ssh"
+)
+
+// commandPrefix is the prefix of exec payloads that are handled by pcompose itself
+// instead of being passed to docker-compose, e.g. ssh user/httpbin@host pcompose login.
+const commandPrefix = "pcompose"
+
+// commandUsage describes the available pcompose commands.
+const commandUsage = `Usage: pcompose <command>
+
+Commands:
+  login                 Print a link to log into the web dashboard
+  deploys               List the deploy history of the project, newest first
+  deploy-log [<id>]     Print the log of a deploy, or of the latest deploy
+  push-mirrors          Show the status of the push mirrors of the repository
+  backup                Back up the volumes and shared directory of the project
+  backups               List the volume backups of the project, newest first
+  restore <id>          Restore the volumes and shared directory of the project from a backup
+  jobs                  List the scheduled jobs of the project with their next and last runs
+  run-job <job>         Run a job now and print its output
+  job-runs <job>        List the runs of a job, newest first
+  job-log <job> [<id>]  Print the log of a run of a job, or of its latest run`
+
+// pcomposeCommand returns the arguments of a pcompose command if the payload is one.
+func pcomposeCommand(payload string) ([]string, bool) {
+	args := strings.Fields(payload)
+	if len(args) == 0 || args[0] != commandPrefix {
+		return nil, false
+	}
+
+	return args[1:], true
+}
+
+// handleCommand runs a pcompose command and writes its output to the channel.
+func handleCommand(sshConn *pUtils.SSHConnHolder, args []string, channel ssh.Channel) error {
+	if len(args) == 0 {
+		return errors.New(commandUsage)
+	}
+
+	switch args[0] {
+	case "login":
+		return handleLogin(sshConn, channel)
+	case "deploys":
+		return handleDeploys(sshConn, channel)
+	case "deploy-log":
+		return handleDeployLog(sshConn, args[1:], channel)
+	case "push-mirrors":
+		return handlePushMirrors(sshConn, channel)
+	case "backup":
+		return handleBackup(sshConn, channel)
+	case "backups":
+		return handleBackups(sshConn, channel)
+	case "restore":
+		return handleRestore(sshConn, args[1:], channel)
+	case "jobs":
+		return handleJobs(sshConn, channel)
+	case "run-job":
+		return handleRunJob(sshConn, args[1:], channel)
+	case "job-runs":
+		return handleJobRuns(sshConn, args[1:], channel)
+	case "job-log":
+		return handleJobLog(sshConn, args[1:], channel)
+	default:
+		return fmt.Errorf("unknown command %q\n\n%s", args[0], commandUsage)
+	}
+}
+
+// handleLogin prints a single use link that logs into the web dashboard as the identity of the connection.
+func handleLogin(sshConn *pUtils.SSHConnHolder, channel ssh.Channel) error {
+	if !viper.GetBool("http-dashboard") {
+		return errors.New("the web dashboard is not enabled")
+	}
+
+	code := auth.NewLoginCode(sshConn.MainConn.Permissions)
+	baseURL := strings.TrimSuffix(viper.GetString("http-public-url"), "/")
+
+	_, err := fmt.Fprintf(channel, "Open the following link within 5 minutes to log into the dashboard:\n\n%s/dashboard/login?code=%s\n", baseURL, code)
+	return err
+}
+
+// commandProject returns the project of the connection if the identity may access it.
+func commandProject(sshConn *pUtils.SSHConnHolder) (project.Project, error) {
+	name := sshConn.MainConn.User()
+	if !authorized(sshConn, name) {
+		return project.Project{}, fmt.Errorf("access to %s denied", name)
+	}
+
+	p, err := project.Get(name)
+	if err != nil {
+		return p, fmt.Errorf("%s: %w", name, err)
+	}
+
+	return p, nil
+}
+
+// handleDeploys prints the deploy history of the project of the connection.
+func handleDeploys(sshConn *pUtils.SSHConnHolder, channel ssh.Channel) error {
+	p, err := commandProject(sshConn)
+	if err != nil {
+		return err
+	}
+
+	records, err := deploy.Records(p)
+	if err != nil {
+		return fmt.Errorf("unable to list deploys: %w", err)
+	}
+
+	writer := tabwriter.NewWriter(channel, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "ID\tSTATUS\tTRIGGER\tREF\tREVISION\tPUSHER\tDURATION")
+
+	for _, record := range records {
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", record.ID, record.Status, record.Trigger, record.Ref, pUtils.ShortRev(record.Revision), record.Pusher, record.Duration().Round(time.Second))
+	}
+
+	return writer.Flush()
+}
+
+// handleDeployLog prints the log of a deploy of the project of the connection, or of its latest deploy.
+func handleDeployLog(sshConn *pUtils.SSHConnHolder, args []string, channel ssh.Channel) error {
+	p, err := commandProject(sshConn)
+	if err != nil {
+		return err
+	}
+
+	var id string
+
+	if len(args) > 0 {
+		id = args[0]
+	} else {
+		records, err := deploy.Records(p)
+		if err != nil {
+			return fmt.Errorf("unable to list deploys: %w", err)
+		}
+
+		if len(records) == 0 {
+			return fmt.Errorf("%s has not been deployed yet", p.FullName())
+		}
+
+		id = records[0].ID
+	}
+
+	data, err := deploy.ReadLog(p, id)
+	if err != nil {
+		if errors.Is(err, deploy.ErrRecordNotFound) {
+			return fmt.Errorf("no log for deploy %q", id)
+		}
+
+		return fmt.Errorf("unable to read deploy log: %w", err)
+	}
+
+	_, err = channel.Write(data)
+	return err
+}
+
+// handlePushMirrors prints the status of the push mirrors of the repository of the connection.
+func handlePushMirrors(sshConn *pUtils.SSHConnHolder, channel ssh.Channel) error {
+	p, err := commandProject(sshConn)
+	if err != nil {
+		return err
+	}
+
+	statuses, err := mirror.PushStatuses(p)
+	if err != nil {
+		return fmt.Errorf("unable to read push mirror status: %w", err)
+	}
+
+	if len(statuses) == 0 {
+		return fmt.Errorf("%s has no push mirrors", p.Name)
+	}
+
+	timeFormat := viper.GetString("time-format")
+
+	writer := tabwriter.NewWriter(channel, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "URL\tSTATUS\tLAST ATTEMPT\tLAST SUCCESS\tFAILURES")
+
+	for _, status := range statuses {
+		state, lastAttempt, lastSuccess := "pending", "-", "-"
+
+		if !status.LastAttempt.IsZero() {
+			lastAttempt = status.LastAttempt.Format(timeFormat)
+
+			state = "ok"
+			if status.Error != "" {
+				state = "failed"
+			}
+		}
+
+		if status.LastSuccess != nil {
+			lastSuccess = status.LastSuccess.Format(timeFormat)
+		}
+
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%d\n", status.URL, state, lastAttempt, lastSuccess, status.Failures)
+	}
+
+	err = writer.Flush()
+	if err != nil {
+		return err
+	}
+
+	for _, status := range statuses {
+		if status.Error != "" {
+			fmt.Fprintf(channel, "\n%s: %s\n", status.URL, status.Error)
+		}
+	}
+
+	return nil
+}
+
+// handleBackup backs up the volumes of the project of the connection and prints the ID of the backup.
+func handleBackup(sshConn *pUtils.SSHConnHolder, channel ssh.Channel) error {
+	p, err := commandProject(sshConn)
+	if err != nil {
+		return err
+	}
+
+	backup, err := volumes.Create(p, volumes.TriggerManual, channel)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(channel, "Created backup %s of %s (%d bytes) in %s\n", backup.ID, p.FullName(), backup.Size, backup.Duration.Round(time.Millisecond))
+	return err
+}
+
+// handleBackups prints the volume backups of the project of the connection.
+func handleBackups(sshConn *pUtils.SSHConnHolder, channel ssh.Channel) error {
+	p, err := commandProject(sshConn)
+	if err != nil {
+		return err
+	}
+
+	backups, err := volumes.List(p)
+	if err != nil {
+		return fmt.Errorf("unable to list backups: %w", err)
+	}
+
+	writer := tabwriter.NewWriter(channel, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "ID\tTRIGGER\tCREATED\tVOLUMES\tBINDS\tSHARED\tSIZE")
+
+	for _, backup := range backups {
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%d\t%d\t%t\t%d\n", backup.ID, backup.Trigger, backup.Created.Format(viper.GetString("time-format")), len(backup.Volumes), len(backup.Binds), backup.Shared, backup.Size)
+	}
+
+	return writer.Flush()
+}
+
+// handleRestore restores the volumes of the project of the connection from a backup.
+func handleRestore(sshConn *pUtils.SSHConnHolder, args []string, channel ssh.Channel) error {
+	if len(args) != 1 {
+		return errors.New("usage: pcompose restore <id>")
+	}
+
+	p, err := commandProject(sshConn)
+	if err != nil {
+		return err
+	}
+
+	err = volumes.Restore(p, args[0], channel)
+	if err != nil {
+		if errors.Is(err, volumes.ErrNotFound) {
+			return fmt.Errorf("no backup %q", args[0])
+		}
+
+		return err
+	}
+
+	_, err = fmt.Fprintf(channel, "Restored backup %s of %s\n", args[0], p.FullName())
+	return err
+}
+
+// handleJobs prints the scheduled jobs of the project of the connection.
+func handleJobs(sshConn *pUtils.SSHConnHolder, channel ssh.Channel) error {
+	p, err := commandProject(sshConn)
+	if err != nil {
+		return err
+	}
+
+	statuses := jobs.Statuses(p)
+	if len(statuses) == 0 {
+		return fmt.Errorf("%s has no jobs", p.FullName())
+	}
+
+	timeFormat := viper.GetString("time-format")
+
+	writer := tabwriter.NewWriter(channel, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "NAME\tSCHEDULE\tSERVICE\tOVERLAP\tNEXT RUN\tLAST RUN\tSTATUS")
+
+	for _, status := range statuses {
+		nextRun, lastRun, state := "-", "-", "-"
+
+		if status.NextRun != nil {
+			nextRun = status.NextRun.Format(timeFormat)
+		}
+
+		if status.LastRun != nil {
+			lastRun = status.LastRun.Start.Format(timeFormat)
+			state = status.LastRun.Status
+		}
+
+		if status.Error != "" {
+			state = "invalid"
+		}
+
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", status.Name, status.Schedule, status.Service, status.Overlap, nextRun, lastRun, state)
+	}
+
+	err = writer.Flush()
+	if err != nil {
+		return err
+	}
+
+	for _, status := range statuses {
+		if status.Error != "" {
+			fmt.Fprintf(channel, "\n%s: %s\n", status.Name, status.Error)
+		}
+	}
+
+	return nil
+}
+
+// handleRunJob runs a job of the project of the connection and streams its output.
+func handleRunJob(sshConn *pUtils.SSHConnHolder, args []string, channel ssh.Channel) error {
+	if len(args) != 1 {
+		return errors.New("usage: pcompose run-job <job>")
+	}
+
+	p, err := commandProject(sshConn)
+	if err != nil {
+		return err
+	}
+
+	job, err := jobs.Find(p, args[0])
+	if err != nil {
+		return fmt.Errorf("no job %q", args[0])
+	}
+
+	_, err = jobs.Execute(p, job, jobs.Options{
+		Trigger: jobs.TriggerManual,
+		Output:  channel,
+	})
+	return err
+}
+
+// handleJobRuns prints the run history of a job of the project of the connection.
+func handleJobRuns(sshConn *pUtils.SSHConnHolder, args []string, channel ssh.Channel) error {
+	if len(args) != 1 {
+		return errors.New("usage: pcompose job-runs <job>")
+	}
+
+	p, err := commandProject(sshConn)
+	if err != nil {
+		return err
+	}
+
+	if _, err := jobs.Find(p, args[0]); err != nil {
+		return fmt.Errorf("no job %q", args[0])
+	}
+
+	runs, err := jobs.Runs(p, args[0])
+	if err != nil {
+		return fmt.Errorf("unable to list job runs: %w", err)
+	}
+
+	writer := tabwriter.NewWriter(channel, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "ID\tSTATUS\tTRIGGER\tEXIT CODE\tDURATION")
+
+	for _, run := range runs {
+		exitCode := "-"
+		if run.ExitCode != nil {
+			exitCode = fmt.Sprint(*run.ExitCode)
+		}
+
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\n", run.ID, run.Status, run.Trigger, exitCode, run.Duration().Round(time.Second))
+	}
+
+	return writer.Flush()
+}
+
+// handleJobLog prints the log of a run of a job of the project of the connection, or of its latest run.
+func handleJobLog(sshConn *pUtils.SSHConnHolder, args []string, channel ssh.Channel) error {
+	if len(args) < 1 || len(args) > 2 {
+		return errors.New("usage: pcompose job-log <job> [<id>]")
+	}
+
+	p, err := commandProject(sshConn)
+	if err != nil {
+		return err
+	}
+
+	var id string
+
+	if len(args) > 1 {
+		id = args[1]
+	} else {
+		runs, err := jobs.Runs(p, args[0])
+		if err != nil {
+			return fmt.Errorf("unable to list job runs: %w", err)
+		}
+
+		if len(runs) == 0 {
+			return fmt.Errorf("job %s has not run yet", args[0])
+		}
+
+		id = runs[0].ID
+	}
+
+	data, err := jobs.ReadLog(p, args[0], id)
+	if err != nil {
+		if errors.Is(err, jobs.ErrRunNotFound) {
+			return fmt.Errorf("no log for run %q of job %s", id, args[0])
+		}
+
+		return fmt.Errorf("unable to read job log: %w", err)
+	}
+
+	_, err = channel.Write(data)
+	return err
+}
@@ -1,64 +1,45 @@
 package sshserver
 
 import (
-	"fmt"
 	"log"
-	"os"
 	"os/exec"
-	"path"
 	"strings"
 
+	"github.com/antoniomika/pcompose/project"
 	pUtils "github.com/antoniomika/pcompose/utils"
-	"github.com/spf13/viper"
 )
 
-func handleGit(payload string) *exec.Cmd {
-	var runCmd *exec.Cmd
+// gitRepoName returns the name of the repository a git command operates on,
+// or an empty name if the repository is missing or invalid, e.g. names a deployment.
+func gitRepoName(payload string) string {
 	commandData := strings.Fields(payload)
-
-	repoDir := strings.TrimSuffix(path.Join(viper.GetString("data-directory"), commandData[1]), ".git")
-
-	if _, err := os.Stat(repoDir); os.IsNotExist(err) {
-		err := os.MkdirAll(repoDir, os.FileMode(0755))
-		if err != nil {
-			log.Println("Error creating directory:", err)
-		}
-
-		initCmd := exec.Command("git", "init", "--bare")
-		initCmd.Env = append(initCmd.Env, fmt.Sprintf("GIT_DIR=%s", repoDir))
-
-		err = initCmd.Run()
-		if err != nil {
-			log.Println("Error creating repository:", err)
-		}
+	if len(commandData) < 2 {
+		return ""
 	}
 
-	hooksDir := path.Join(repoDir, pUtils.HooksDirName)
-
-	for _, hook := range []string{"pre-receive", "update", "post-receive"} {
-		hookName := path.Join(hooksDir, hook)
+	repo, err := project.CleanRepoName(commandData[1])
+	if err != nil {
+		return ""
+	}
 
-		executable, err := os.Executable()
-		if err != nil {
-			log.Println("Error getting executable:", err)
-			return runCmd
-		}
+	return repo
+}
 
-		err = os.Symlink(executable, hookName)
-		if err != nil {
-			log.Println("Error symlinking file:", err)
-		}
+// handleGit returns the git command serving a repository, creating the repository if needed.
+func handleGit(payload string, repo string) *exec.Cmd {
+	var runCmd *exec.Cmd
 
-		err = os.Chmod(hookName, os.ModePerm)
-		if err != nil {
-			log.Println("Error chmoding file:", err)
-			return runCmd
-		}
+	if _, err := project.CleanRepoName(repo); err != nil {
+		log.Println("Error handling git command:", err)
+		return runCmd
 	}
 
-	err := os.Symlink(path.Join(viper.GetString("data-directory"), pUtils.HooksConfigFile), path.Join(hooksDir, pUtils.HooksConfigFile))
+	repoDir := project.RepoDir(repo)
+
+	err := project.InitRepository(repoDir)
 	if err != nil {
-		log.Println("Error symlinking file:", err)
+		log.Println("Error initializing repository:", err)
+		return runCmd
 	}
 
 	if strings.HasPrefix(payload, pUtils.UploadPackServiceName) {
@@ -0,0 +1,100 @@
+package sshserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/antoniomika/pcompose/auth"
+	"github.com/antoniomika/pcompose/lfs"
+	"github.com/antoniomika/pcompose/project"
+	pUtils "github.com/antoniomika/pcompose/utils"
+	"github.com/spf13/viper"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// lfsAuthenticateCommand hands out credentials for the Git LFS HTTP API.
+	lfsAuthenticateCommand = "git-lfs-authenticate"
+
+	// lfsTransferCommand transfers Git LFS objects over SSH.
+	lfsTransferCommand = "git-lfs-transfer"
+)
+
+// lfsRequest is a git-lfs-authenticate or git-lfs-transfer command, e.g. git-lfs-transfer user/httpbin.git upload.
+type lfsRequest struct {
+	command   string
+	repo      string
+	operation string
+}
+
+// lfsAuthentication is the response of git-lfs-authenticate.
+type lfsAuthentication struct {
+	Href      string            `json:"href"`
+	Header    map[string]string `json:"header"`
+	ExpiresIn int               `json:"expires_in"`
+}
+
+// lfsCommand returns the Git LFS request if the payload is one.
+func lfsCommand(payload string) (lfsRequest, bool) {
+	fields := strings.Fields(payload)
+	if len(fields) != 3 || (fields[0] != lfsAuthenticateCommand && fields[0] != lfsTransferCommand) {
+		return lfsRequest{}, false
+	}
+
+	// Invalid repositories are left empty and rejected by handleLFS.
+	repo, _ := project.CleanRepoName(fields[1])
+
+	return lfsRequest{
+		command:   fields[0],
+		repo:      repo,
+		operation: fields[2],
+	}, true
+}
+
+// handleLFS runs a Git LFS command for a repository the identity of the connection can access.
+func handleLFS(sshConn *pUtils.SSHConnHolder, request lfsRequest, channel ssh.Channel) error {
+	if request.repo == "" {
+		return errors.New("invalid repository")
+	}
+
+	if request.operation != lfs.OperationUpload && request.operation != lfs.OperationDownload {
+		return fmt.Errorf("unknown operation %q", request.operation)
+	}
+
+	if !authorized(sshConn, request.repo) {
+		return fmt.Errorf("access to %s denied", request.repo)
+	}
+
+	if request.command == lfsAuthenticateCommand {
+		return handleLFSAuthenticate(sshConn, request, channel)
+	}
+
+	repoDir, err := lfs.RepoDir(request.repo, request.operation)
+	if errors.Is(err, lfs.ErrRepositoryNotFound) {
+		return fmt.Errorf("repository %s not found", request.repo)
+	} else if err != nil {
+		return fmt.Errorf("unable to initialize repository: %w", err)
+	}
+
+	return lfs.Serve(repoDir, request.operation, channel, channel)
+}
+
+// handleLFSAuthenticate prints a short lived token for the Git LFS HTTP API, which
+// git-lfs clients that don't support git-lfs-transfer use instead.
+func handleLFSAuthenticate(sshConn *pUtils.SSHConnHolder, request lfsRequest, channel ssh.Channel) error {
+	if viper.GetString("http-address") == "" || !viper.GetBool("http-git") {
+		return errors.New("git over HTTP is not enabled, use git-lfs 3 or newer which transfers objects over SSH")
+	}
+
+	token := auth.NewLFSToken(sshConn.MainConn.Permissions, request.repo, request.operation)
+
+	return json.NewEncoder(channel).Encode(lfsAuthentication{
+		Href: fmt.Sprintf("%s/git/%s.git/info/lfs", strings.TrimSuffix(viper.GetString("http-public-url"), "/"), request.repo),
+		Header: map[string]string{
+			"Authorization": "Bearer " + token,
+		},
+		ExpiresIn: int(auth.LFSTokenDuration.Seconds()),
+	})
+}
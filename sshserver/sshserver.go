@@ -10,12 +10,17 @@ import (
 	"os"
 	"os/exec"
 	"os/signal"
-	"path"
-	"path/filepath"
 	"runtime"
 	"strings"
 	"time"
 
+	"github.com/antoniomika/pcompose/audit"
+	"github.com/antoniomika/pcompose/auth"
+	"github.com/antoniomika/pcompose/deploy"
+	"github.com/antoniomika/pcompose/metrics"
+	"github.com/antoniomika/pcompose/mirror"
+	"github.com/antoniomika/pcompose/project"
+	"github.com/antoniomika/pcompose/recording"
 	pUtils "github.com/antoniomika/pcompose/utils"
 	"github.com/antoniomika/sish/utils"
 	"github.com/creack/pty"
@@ -43,6 +48,8 @@ func Start() {
 	log.Println("Starting SSH service on address:", viper.GetString("ssh-address"))
 
 	sshConfig := utils.GetSSHConfig()
+	auth.WrapCallbacks(sshConfig)
+	wrapAuditCallbacks(sshConfig)
 
 	listener, err := net.Listen("tcp", viper.GetString("ssh-address"))
 	if err != nil {
@@ -77,6 +84,7 @@ func Start() {
 
 		go func() {
 			sshConn, chans, reqs, err := ssh.NewServerConn(conn, sshConfig)
+			offeredKeys.Delete(conn.RemoteAddr().String())
 			if err != nil {
 				conn.Close()
 				log.Println("Error upgrading ssh connection:", err)
@@ -87,6 +95,9 @@ func Start() {
 				MainConn: sshConn,
 			}
 
+			audit.Log(auditEntry(internalSSHConn, audit.ActionAuthSuccess))
+			metrics.ConnectionOpened()
+
 			go handleRequests(internalSSHConn, reqs, nil)
 			go handleChannels(internalSSHConn, chans)
 
@@ -94,6 +105,8 @@ func Start() {
 			if err != nil {
 				log.Println("Error waiting for ssh connection:", err)
 			}
+
+			metrics.ConnectionClosed()
 		}()
 	}
 }
@@ -108,8 +121,10 @@ func handleRequests(sshConn *pUtils.SSHConnHolder, reqs <-chan *ssh.Request, cha
 }
 
 func handleRequest(sshConn *pUtils.SSHConnHolder, newRequest *ssh.Request, channel ssh.Channel) {
+	var status uint32
+
 	exitStatus := func() {
-		_, err := channel.SendRequest("exit-status", false, []byte{0, 0, 0, 0})
+		_, err := channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
 		if err != nil {
 			log.Println("Error sending request to channel:", err)
 		}
@@ -144,34 +159,52 @@ func handleRequest(sshConn *pUtils.SSHConnHolder, newRequest *ssh.Request, chann
 		}
 
 		var cmd *exec.Cmd
+		var target string
 
 		containerName := sshConn.MainConn.User()
+		auditLog := auditEntry(sshConn, audit.ActionShell)
+		start := time.Now()
 
 		if strings.HasPrefix(containerName, "c-") {
 			containerName = strings.TrimPrefix(containerName, "c-")
+			target = containerProject(containerName)
 			cmd = exec.Command("docker", "exec", "-it", containerName, "/bin/sh")
 		} else if strings.HasPrefix(containerName, "l-") {
 			containerName = strings.TrimPrefix(containerName, "l-")
+			target = containerProject(containerName)
 			cmd = exec.Command("docker", "logs", "-f", containerName)
+			auditLog.Action = audit.ActionLogs
 		} else if strings.HasPrefix(containerName, "a-") {
 			containerName = strings.TrimPrefix(containerName, "a-")
+			target = containerProject(containerName)
 			cmd = exec.Command("docker", "attach", containerName)
+			auditLog.Action = audit.ActionAttach
 		} else {
-			_, dirName := filepath.Split(containerName)
-			workDir := path.Join(viper.GetString("data-directory"), containerName, dirName)
+			p := project.New(containerName)
+			workDir := p.ComposeDir()
 
 			if _, err := os.Stat(workDir); err == nil {
-				cmd = exec.Command("docker", []string{
+				// The shell gets the same compose context as exec requests, so docker-compose works on the current release.
+				target = p.FullName()
+				args := []string{
 					"exec",
 					"-it",
 					"-w",
 					workDir,
 					"-e",
-					fmt.Sprintf("COMPOSE_PROJECT_NAME=%s", strings.ReplaceAll(containerName, string(os.PathSeparator), "_")),
-					viper.GetString("pcompose-container-name"),
-					"/bin/zsh",
-				}...)
+					fmt.Sprintf("COMPOSE_PROJECT_NAME=%s", p.ComposeProject()),
+					"-e",
+					fmt.Sprintf("%s=%s", deploy.SharedDirEnv, p.SharedDir()),
+				}
+
+				if composeFiles := deploy.ComposeFiles(p); len(composeFiles) > 0 {
+					args = append(args, "-e", fmt.Sprintf("COMPOSE_FILE=%s", strings.Join(composeFiles, ":")))
+				}
+
+				cmd = exec.Command("docker", append(args, viper.GetString("pcompose-container-name"), "/bin/zsh")...)
 			} else {
+				target = containerProject(containerName)
+
 				realCmd := "/bin/sh"
 				if containerName == viper.GetString("pcompose-container-name") {
 					realCmd = "/bin/zsh"
@@ -180,6 +213,23 @@ func handleRequest(sshConn *pUtils.SSHConnHolder, newRequest *ssh.Request, chann
 			}
 		}
 
+		auditLog.Target = containerName
+
+		if !authorized(sshConn, target) {
+			status = 1
+			denyAccess(channel, auditLog, target)
+			return
+		}
+
+		sessionType := metrics.SessionShell
+		if auditLog.Action == audit.ActionLogs {
+			sessionType = metrics.SessionLogs
+		} else if auditLog.Action == audit.ActionAttach {
+			sessionType = metrics.SessionAttach
+		}
+
+		defer metrics.SessionStarted(sessionType)()
+
 		term, dataHandler, err := pty.Open()
 		if err != nil {
 			log.Println("Error assigning pty:", err)
@@ -193,22 +243,44 @@ func handleRequest(sshConn *pUtils.SSHConnHolder, newRequest *ssh.Request, chann
 		pUtils.SetWinSize(term.Fd(), sshConn.W, sshConn.H)
 
 		sshConn.Term = term
+
+		var recorder *recording.Recorder
+		if recording.Enabled() && auditLog.Action != audit.ActionLogs {
+			// Sessions are recorded per project, so they are listed and pruned with the project of the container.
+			recorder, err = recording.New(target, fmt.Sprintf("%s@%s", sshConn.MainConn.User(), sshConn.MainConn.RemoteAddr()), sshConn.W, sshConn.H)
+			if err != nil {
+				log.Println("Error creating session recording:", err)
+			}
+
+			sshConn.Recorder = recorder
+		}
 		sshConn.Mu.Unlock()
 
+		var termWriter io.Writer = term
+		var channelWriter io.Writer = channel
+
+		if recorder != nil {
+			channelWriter = io.MultiWriter(channel, recorder.Output())
+
+			if viper.GetBool("session-recordings-input") {
+				termWriter = io.MultiWriter(term, recorder.Input())
+			}
+		}
+
 		err = cmd.Start()
 		if err != nil {
 			log.Println("error starting command")
 		}
 
 		go func() {
-			_, err := io.Copy(term, channel)
+			_, err := io.Copy(termWriter, channel)
 			if err != nil && viper.GetBool("debug") {
 				log.Println("Error copying from channel:", err)
 			}
 		}()
 
 		go func() {
-			_, err = io.Copy(channel, term)
+			_, err = io.Copy(channelWriter, term)
 			if err != nil && viper.GetBool("debug") {
 				log.Println("Error copying from term:", err)
 			}
@@ -219,6 +291,19 @@ func handleRequest(sshConn *pUtils.SSHConnHolder, newRequest *ssh.Request, chann
 			log.Println("Error waiting for command:", err)
 		}
 
+		audit.Log(auditLog.Session(start, exitStatusCode(err), err))
+
+		if recorder != nil {
+			sshConn.Mu.Lock()
+			sshConn.Recorder = nil
+			sshConn.Mu.Unlock()
+
+			err = recorder.Close()
+			if err != nil {
+				log.Println("Error closing session recording:", err)
+			}
+		}
+
 		err = term.Close()
 		if err != nil && viper.GetBool("debug") {
 			log.Println("Error closing term:", err)
@@ -231,6 +316,10 @@ func handleRequest(sshConn *pUtils.SSHConnHolder, newRequest *ssh.Request, chann
 		sshConn.H = h
 
 		pUtils.SetWinSize(sshConn.Term.Fd(), w, h)
+
+		if sshConn.Recorder != nil {
+			sshConn.Recorder.Resize(w, h)
+		}
 		sshConn.Mu.Unlock()
 	case "exec":
 		defer exitStatus()
@@ -238,39 +327,145 @@ func handleRequest(sshConn *pUtils.SSHConnHolder, newRequest *ssh.Request, chann
 		payload := string(bytes.ReplaceAll(newRequest.Payload[4:], []byte{'\''}, []byte{}))
 
 		var runCmd *exec.Cmd
+		var pushLog string
+		var afterRun func(err error)
 		openStdin := false
+		sessionType := metrics.SessionExec
+
+		auditLog := auditEntry(sshConn, audit.ActionExec)
+		auditLog.Payload = payload
+		start := time.Now()
+
+		if args, ok := pcomposeCommand(payload); ok {
+			err := newRequest.Reply(true, nil)
+			if err != nil {
+				log.Println("Error sending request:", err)
+				return
+			}
+
+			sessionEnded := metrics.SessionStarted(metrics.SessionExec)
+			err = handleCommand(sshConn, args, channel)
+			sessionEnded()
+
+			if err != nil {
+				status = 1
+
+				_, writeErr := fmt.Fprintln(channel.Stderr(), err)
+				if writeErr != nil {
+					log.Println("Error writing to channel:", writeErr)
+				}
+			}
+
+			audit.Log(auditLog.Session(start, int(status), err))
+			return
+		}
+
+		if request, ok := lfsCommand(payload); ok {
+			err := newRequest.Reply(true, nil)
+			if err != nil {
+				log.Println("Error sending request:", err)
+				return
+			}
+
+			sessionEnded := metrics.SessionStarted(metrics.SessionGit)
+			err = handleLFS(sshConn, request, channel)
+			sessionEnded()
+
+			if err != nil {
+				status = 1
+
+				_, writeErr := fmt.Fprintln(channel.Stderr(), err)
+				if writeErr != nil {
+					log.Println("Error writing to channel:", writeErr)
+				}
+			}
+
+			audit.Log(auditLog.Session(start, int(status), err))
+			return
+		}
 
 		if strings.HasPrefix(payload, pUtils.UploadPackServiceName) || strings.HasPrefix(payload, pUtils.ReceivePackServiceName) {
-			runCmd = handleGit(payload)
+			repo := gitRepoName(payload)
+			if !authorized(sshConn, repo) {
+				err := newRequest.Reply(true, nil)
+				if err != nil {
+					log.Println("Error sending request:", err)
+				}
+
+				status = 1
+				denyAccess(channel, auditLog, repo)
+				return
+			}
+
+			runCmd = handleGit(payload, repo)
 			openStdin = true
+			sessionType = metrics.SessionGit
+
+			if runCmd != nil {
+				runCmd.Env = append(os.Environ(), fmt.Sprintf("%s=%s", pUtils.PusherEnv, auth.Identity(sshConn.MainConn.Permissions)))
+			}
+
+			if runCmd != nil && strings.HasPrefix(payload, pUtils.ReceivePackServiceName) {
+				var err error
+
+				pushLog, err = audit.PushLog()
+				if err != nil {
+					log.Println("Error creating push log:", err)
+				} else {
+					runCmd.Env = append(runCmd.Env, fmt.Sprintf("%s=%s", pUtils.PushLogEnv, pushLog))
+				}
+			}
 		} else {
-			containerName := sshConn.MainConn.User()
-			_, dirName := filepath.Split(containerName)
-			workDir := path.Join(viper.GetString("data-directory"), containerName, dirName)
-			composeProject := strings.ReplaceAll(containerName, string(os.PathSeparator), "_")
-			networkName := fmt.Sprintf("%s_default", composeProject)
-
-			if strings.Contains(payload, "down") || strings.Contains(payload, "up") {
-				command := "disconnect"
-				if strings.Contains(payload, "up") {
-					command = "connect"
-
-					networkCreate := exec.Command("docker", "network", "create", networkName)
-					networkCreate.Dir = workDir
-					_ = networkCreate.Run()
+			p := project.New(sshConn.MainConn.User())
+			if !authorized(sshConn, p.FullName()) {
+				err := newRequest.Reply(true, nil)
+				if err != nil {
+					log.Println("Error sending request:", err)
 				}
 
-				networkHandle := exec.Command("docker", "network", command, networkName, viper.GetString("frontend-container-name"))
-				networkHandle.Dir = workDir
-				_ = networkHandle.Run()
+				status = 1
+				denyAccess(channel, auditLog, p.FullName())
+				return
+			}
+
+			workDir := p.ComposeDir()
+			composeProject := p.ComposeProject()
+
+			switch composeCommand(strings.Fields(payload)) {
+			case "up":
+				deploy.ConnectFrontend(p, workDir)
+			case "down":
+				// The frontends have to leave the networks for docker-compose to be able to remove them.
+				deploy.DisconnectFrontend(p, workDir)
+				afterRun = func(err error) {
+					if err != nil {
+						return
+					}
+
+					err = deploy.RemoveNetworks(p)
+					if err != nil {
+						log.Println("Error removing networks:", err)
+					}
+
+					err = deploy.RemoveRoutes(p.ComposeProject())
+					if err != nil {
+						log.Println("Error removing routes:", err)
+					}
+				}
 			}
 
 			runCmd = exec.Command("docker-compose", strings.Fields(payload)...)
 			runCmd.Dir = workDir
-			runCmd.Env = append(runCmd.Env, fmt.Sprintf("COMPOSE_PROJECT_NAME=%s", composeProject))
+			runCmd.Env = append(runCmd.Env, fmt.Sprintf("COMPOSE_PROJECT_NAME=%s", composeProject), fmt.Sprintf("%s=%s", deploy.SharedDirEnv, p.SharedDir()))
+
+			if composeFiles := deploy.ComposeFiles(p); len(composeFiles) > 0 {
+				runCmd.Env = append(runCmd.Env, fmt.Sprintf("COMPOSE_FILE=%s", strings.Join(composeFiles, ":")))
+			}
 		}
 
 		if runCmd == nil {
+			audit.Log(auditLog.Session(start, -1, fmt.Errorf("unable to handle request")))
+
 			err := newRequest.Reply(false, nil)
 			if err != nil {
 				log.Println("Error sending request:", err)
@@ -293,7 +488,28 @@ func handleRequest(sshConn *pUtils.SSHConnHolder, newRequest *ssh.Request, chann
 		runCmd.Stderr = channel.Stderr()
 		runCmd.Stdout = channel
 
+		sessionEnded := metrics.SessionStarted(sessionType)
 		err = runCmd.Run()
+		sessionEnded()
+
+		if afterRun != nil {
+			afterRun(err)
+		}
+
+		if pushLog != "" {
+			if audit.Pushes(auditEntry(sshConn, audit.ActionPush), gitRepoName(payload), pushLog) > 0 {
+				metrics.Push(gitRepoName(payload))
+				mirror.Pushed(gitRepoName(payload))
+			}
+
+			err := os.Remove(pushLog)
+			if err != nil {
+				log.Println("Error removing push log:", err)
+			}
+		}
+
+		audit.Log(auditLog.Session(start, exitStatusCode(err), err))
+
 		if err != nil {
 			log.Println("Error executing command:", err)
 			return
@@ -306,6 +522,39 @@ func handleRequest(sshConn *pUtils.SSHConnHolder, newRequest *ssh.Request, chann
 	}
 }
 
+// composeValueFlags are the global docker-compose flags that take a value.
+var composeValueFlags = map[string]bool{
+	"-f":                  true,
+	"--file":              true,
+	"-p":                  true,
+	"--project-name":      true,
+	"--project-directory": true,
+	"--env-file":          true,
+	"--profile":           true,
+	"-H":                  true,
+	"--host":              true,
+	"-c":                  true,
+	"--context":           true,
+	"--log-level":         true,
+	"--ansi":              true,
+}
+
+// composeCommand returns the docker-compose command of the arguments of an exec payload,
+// e.g. down for --profile web down -v.
+func composeCommand(args []string) string {
+	for i := 0; i < len(args); i++ {
+		switch {
+		case composeValueFlags[args[i]]:
+			i++
+		case strings.HasPrefix(args[i], "-"):
+		default:
+			return args[i]
+		}
+	}
+
+	return ""
+}
+
 func handleChannels(sshConn *pUtils.SSHConnHolder, chans <-chan ssh.NewChannel) {
 	for newChannel := range chans {
 		if viper.GetBool("debug") {
@@ -9,6 +9,7 @@ import (
 	"syscall"
 	"unsafe"
 
+	"github.com/antoniomika/pcompose/recording"
 	"golang.org/x/crypto/ssh"
 )
 
@@ -24,6 +25,17 @@ const (
 
 	// ReceivePackServiceName is the command name for receiving a git pack.
 	ReceivePackServiceName = "git-receive-pack"
+
+	// PusherEnv is the environment variable that passes the pushing identity to git hooks.
+	PusherEnv = "PCOMPOSE_PUSHER"
+
+	// WorkingDirEnv is the environment variable that passes the working directory of the server
+	// to git hooks, which run from within a repository.
+	WorkingDirEnv = "PCOMPOSE_WORKING_DIRECTORY"
+
+	// PushLogEnv is the environment variable that passes the file the post-receive hook records
+	// the ref updates of a push in.
+	PushLogEnv = "PCOMPOSE_PUSH_LOG"
 )
 
 // SSHConnHolder is the ssh connection we hold onto.
@@ -33,6 +45,7 @@ type SSHConnHolder struct {
 	H        uint32
 	Mu       sync.Mutex
 	Term     *os.File
+	Recorder *recording.Recorder
 }
 
 type winsize struct {
@@ -55,3 +68,12 @@ func SetWinSize(fd uintptr, w, h uint32) {
 		log.Println("Error sending syscall to pty:", err)
 	}
 }
+
+// ShortRev abbreviates a revision for messages, deploy output and notifications.
+func ShortRev(rev string) string {
+	if len(rev) > 12 {
+		return rev[:12]
+	}
+
+	return rev
+}
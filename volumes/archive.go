@@ -0,0 +1,194 @@
+package volumes
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// archiveDir writes a gzipped tar archive of the contents of a directory to a file, keeping
+// permissions, ownership and symlinks.
+func archiveDir(dir string, archive string) error {
+	f, err := os.Create(archive)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	gzipWriter := gzip.NewWriter(f)
+	tarWriter := tar.NewWriter(gzipWriter)
+
+	err = filepath.WalkDir(dir, func(walkPath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if walkPath == dir {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		var link string
+		if info.Mode()&os.ModeSymlink != 0 {
+			link, err = os.Readlink(walkPath)
+			if err != nil {
+				return err
+			}
+		} else if !info.Mode().IsRegular() && !info.IsDir() {
+			// Sockets and devices can't be restored meaningfully.
+			return nil
+		}
+
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+
+		relPath, err := filepath.Rel(dir, walkPath)
+		if err != nil {
+			return err
+		}
+
+		header.Name = filepath.ToSlash(relPath)
+		if info.IsDir() {
+			header.Name += "/"
+		}
+
+		err = tarWriter.WriteHeader(header)
+		if err != nil {
+			return err
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		file, err := os.Open(walkPath)
+		if err != nil {
+			return err
+		}
+
+		defer file.Close()
+
+		_, err = io.Copy(tarWriter, file)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	err = tarWriter.Close()
+	if err != nil {
+		return err
+	}
+
+	err = gzipWriter.Close()
+	if err != nil {
+		return err
+	}
+
+	return f.Close()
+}
+
+// extractDir replaces the contents of a directory with a gzipped tar archive created by archiveDir.
+func extractDir(archive io.Reader, dir string) error {
+	gzipReader, err := gzip.NewReader(archive)
+	if err != nil {
+		return err
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	for _, entry := range entries {
+		err := os.RemoveAll(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return err
+		}
+	}
+
+	err = os.MkdirAll(dir, os.FileMode(0755))
+	if err != nil {
+		return err
+	}
+
+	tarReader := tar.NewReader(gzipReader)
+
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		name := filepath.Clean(filepath.FromSlash(header.Name))
+		if filepath.IsAbs(name) || name == ".." || strings.HasPrefix(name, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("invalid archive entry %s", header.Name)
+		}
+
+		target := filepath.Join(dir, name)
+		mode := os.FileMode(header.Mode).Perm()
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(target, mode)
+		case tar.TypeSymlink:
+			err = os.Symlink(header.Linkname, target)
+		case tar.TypeReg:
+			err = extractFile(tarReader, target, mode)
+		default:
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+
+		// Ownership can only be restored when running as root, which is when it matters.
+		_ = os.Lchown(target, header.Uid, header.Gid)
+
+		if header.Typeflag != tar.TypeSymlink {
+			_ = os.Chtimes(target, header.ModTime, header.ModTime)
+		}
+	}
+}
+
+// extractFile writes an archive entry to a file.
+func extractFile(r io.Reader, target string, mode os.FileMode) error {
+	err := os.MkdirAll(filepath.Dir(target), os.FileMode(0755))
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(f, r)
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// isEmptyDir returns whether a directory is empty or doesn't exist.
+func isEmptyDir(dir string) bool {
+	entries, err := os.ReadDir(dir)
+	return err != nil || len(entries) == 0
+}
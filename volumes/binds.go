@@ -0,0 +1,168 @@
+package volumes
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/antoniomika/pcompose/deploy"
+	"github.com/antoniomika/pcompose/project"
+	"github.com/spf13/viper"
+)
+
+// Bind is a relative bind mount of a backup, a directory of the release that isn't in the shared directory.
+// Like other relative directories it is replaced by the next deploy, so restoring it only restores the current release.
+type Bind struct {
+	// Path is the directory relative to the deploy directory of the release, e.g. data/uploads.
+	Path string `json:"path"`
+
+	// Size is the size of the compressed directory archive.
+	Size int64 `json:"size"`
+}
+
+// projectBinds returns the bind mounts of the current release of a project that are backed up, the
+// directories within the release outside of the shared directory, and describes the bind mounts that
+// aren't backed up.
+func projectBinds(p project.Project) ([]Bind, []string, error) {
+	// Projects that were never deployed have no release to bind mount.
+	if _, err := os.Stat(p.ComposeDir()); os.IsNotExist(err) {
+		return nil, nil, nil
+	}
+
+	var stdout, stderr bytes.Buffer
+
+	// docker-compose config resolves relative bind mounts and variables like PCOMPOSE_SHARED_DIR.
+	configCmd := deploy.Compose(p, "config")
+	configCmd.Stdout = &stdout
+	configCmd.Stderr = &stderr
+
+	err := configCmd.Run()
+	if err != nil {
+		return nil, nil, fmt.Errorf("error reading compose files: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	sources, err := bindSources(stdout.Bytes())
+	if err != nil {
+		return nil, nil, fmt.Errorf("error parsing compose files: %w", err)
+	}
+
+	binds, skipped := classifyBinds(p.ComposeDir(), p.SharedDir(), sources)
+
+	return binds, skipped, nil
+}
+
+// bindSources returns the sorted host paths of the bind mounts in a compose file written by docker-compose config,
+// which uses the short syntax with absolute paths for bind mounts or the long syntax with their type.
+func bindSources(composeConfig []byte) ([]string, error) {
+	config := viper.New()
+	config.SetConfigType("yaml")
+
+	err := config.ReadConfig(bytes.NewReader(composeConfig))
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+
+	// Service names can contain dots, so the services are read as a whole rather than by key.
+	for _, service := range config.GetStringMap("services") {
+		serviceConfig, ok := service.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		volumes, _ := serviceConfig["volumes"].([]interface{})
+
+		for _, volume := range volumes {
+			var source string
+
+			switch volume := volume.(type) {
+			case string:
+				source, _, _ = strings.Cut(volume, ":")
+				if !filepath.IsAbs(source) {
+					// Named volumes are backed up on their own.
+					continue
+				}
+			case map[string]interface{}:
+				if volume["type"] != "bind" {
+					continue
+				}
+
+				source, _ = volume["source"].(string)
+			}
+
+			if source != "" {
+				seen[source] = true
+			}
+		}
+	}
+
+	sources := make([]string, 0, len(seen))
+	for source := range seen {
+		sources = append(sources, source)
+	}
+
+	sort.Strings(sources)
+
+	return sources, nil
+}
+
+// classifyBinds returns the bind mount sources that are directories within a release and outside of the shared
+// directory, which holds its own backup, and describes why the other sources aren't backed up. Sources that
+// don't exist hold no data.
+func classifyBinds(releaseDir string, sharedDir string, sources []string) ([]Bind, []string) {
+	releaseDir = resolvePath(releaseDir)
+	sharedDir = resolvePath(sharedDir)
+
+	var binds []Bind
+	var skipped []string
+
+	for _, source := range sources {
+		resolved, err := filepath.EvalSymlinks(source)
+		if err != nil {
+			continue
+		}
+
+		if isWithin(sharedDir, resolved) {
+			continue
+		}
+
+		if !isWithin(releaseDir, resolved) {
+			skipped = append(skipped, fmt.Sprintf("%s is outside of the deploy directory", source))
+			continue
+		}
+
+		relPath, err := filepath.Rel(releaseDir, resolved)
+		if err != nil || relPath == "." {
+			skipped = append(skipped, fmt.Sprintf("%s is the release, which is checked out from the repository", source))
+			continue
+		}
+
+		if info, err := os.Stat(resolved); err != nil || !info.IsDir() {
+			skipped = append(skipped, fmt.Sprintf("%s isn't a directory, list it in shared to keep it", source))
+			continue
+		}
+
+		binds = append(binds, Bind{Path: filepath.ToSlash(relPath)})
+	}
+
+	return binds, skipped
+}
+
+// resolvePath returns a path with its symlinks resolved, or the path itself if it doesn't exist.
+func resolvePath(dir string) string {
+	resolved, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return filepath.Clean(dir)
+	}
+
+	return resolved
+}
+
+// isWithin returns whether a path is a directory or within it.
+func isWithin(dir string, target string) bool {
+	return target == dir || strings.HasPrefix(target, dir+string(filepath.Separator))
+}
@@ -0,0 +1,128 @@
+package volumes
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBindSources(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		want   []string
+	}{
+		{
+			name: "short syntax",
+			config: `
+services:
+  web.app:
+    volumes:
+      - /srv/releases/abc/uploads:/uploads:rw
+      - db:/var/lib/postgresql/data
+      - /var/run/docker.sock:/var/run/docker.sock
+  worker:
+    volumes:
+      - /srv/releases/abc/uploads:/uploads:ro
+volumes:
+  db: {}
+`,
+			want: []string{"/srv/releases/abc/uploads", "/var/run/docker.sock"},
+		},
+		{
+			name: "long syntax",
+			config: `
+services:
+  web:
+    volumes:
+      - type: bind
+        source: /srv/shared/data
+        target: /data
+      - type: volume
+        source: db
+        target: /var/lib/postgresql/data
+      - type: tmpfs
+        target: /tmp
+`,
+			want: []string{"/srv/shared/data"},
+		},
+		{
+			name:   "no volumes",
+			config: "services:\n  web:\n    image: nginx\n",
+			want:   []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := bindSources([]byte(tt.config))
+			if err != nil {
+				t.Fatalf("bindSources() error = %v", err)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("bindSources() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClassifyBinds(t *testing.T) {
+	root := t.TempDir()
+
+	releaseDir := filepath.Join(root, "releases", "abc")
+	sharedDir := filepath.Join(root, "shared")
+	outside := t.TempDir()
+
+	for _, dir := range []string{filepath.Join(releaseDir, "uploads"), filepath.Join(releaseDir, "cache", "thumbnails"), filepath.Join(sharedDir, "data")} {
+		err := os.MkdirAll(dir, 0o755)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := os.WriteFile(filepath.Join(releaseDir, "app.db"), nil, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Shared paths are links to the shared directory in every release, and current links to the release.
+	err = os.Symlink(filepath.Join(sharedDir, "data"), filepath.Join(releaseDir, "data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.Symlink(releaseDir, filepath.Join(root, "current"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	current := filepath.Join(root, "current")
+
+	binds, skipped := classifyBinds(current, sharedDir, []string{
+		filepath.Join(current, "app.db"),
+		filepath.Join(current, "cache", "thumbnails"),
+		filepath.Join(current, "data"),
+		filepath.Join(current, "missing"),
+		filepath.Join(current, "uploads"),
+		current,
+		outside,
+	})
+
+	want := []Bind{{Path: "cache/thumbnails"}, {Path: "uploads"}}
+	if !reflect.DeepEqual(binds, want) {
+		t.Errorf("classifyBinds() binds = %v, want %v", binds, want)
+	}
+
+	if len(skipped) != 3 {
+		t.Fatalf("classifyBinds() skipped = %v, want app.db, the release and %s", skipped, outside)
+	}
+
+	for i, source := range []string{filepath.Join(current, "app.db"), current, outside} {
+		if !strings.HasPrefix(skipped[i], source+" ") {
+			t.Errorf("classifyBinds() skipped[%d] = %q, want it to name %s", i, skipped[i], source)
+		}
+	}
+}
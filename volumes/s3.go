@@ -0,0 +1,235 @@
+package volumes
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// s3UnsignedPayload is sent instead of the hash of the body, so backups don't have to be read twice.
+const s3UnsignedPayload = "UNSIGNED-PAYLOAD"
+
+// s3Store stores backups in a bucket of an S3 compatible service, such as MinIO. Requests use
+// path style addressing and are signed using AWS Signature Version 4.
+type s3Store struct {
+	endpoint  string
+	bucket    string
+	region    string
+	accessKey string
+	secretKey string
+}
+
+// s3ListResult is the response of ListObjectsV2.
+type s3ListResult struct {
+	Contents []struct {
+		Key string `xml:"Key"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// Put uploads an object.
+func (s *s3Store) Put(key string, r io.Reader, size int64) error {
+	resp, err := s.do(http.MethodPut, key, nil, r, size)
+	if err != nil {
+		return err
+	}
+
+	resp.Body.Close()
+
+	return nil
+}
+
+// Get downloads an object.
+func (s *s3Store) Get(key string) (io.ReadCloser, error) {
+	resp, err := s.do(http.MethodGet, key, nil, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Body, nil
+}
+
+// List lists the objects under a prefix, following continuation tokens.
+func (s *s3Store) List(prefix string) ([]string, error) {
+	var keys []string
+
+	query := url.Values{
+		"list-type": {"2"},
+		"prefix":    {strings.TrimSuffix(prefix, "/") + "/"},
+	}
+
+	for {
+		resp, err := s.do(http.MethodGet, "", query, nil, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		var result s3ListResult
+
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+
+		if err != nil {
+			return nil, fmt.Errorf("error parsing object list: %w", err)
+		}
+
+		for _, object := range result.Contents {
+			keys = append(keys, object.Key)
+		}
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return keys, nil
+		}
+
+		query.Set("continuation-token", result.NextContinuationToken)
+	}
+}
+
+// Delete deletes an object.
+func (s *s3Store) Delete(key string) error {
+	resp, err := s.do(http.MethodDelete, key, nil, nil, 0)
+	if err == ErrNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	resp.Body.Close()
+
+	return nil
+}
+
+// do sends a signed request for an object, or for the bucket if key is empty, and returns
+// the response if it succeeded.
+func (s *s3Store) do(method string, key string, query url.Values, body io.Reader, size int64) (*http.Response, error) {
+	canonicalURI := "/" + s3Escape(s.bucket, false)
+	if key != "" {
+		canonicalURI += "/" + s3Escape(key, true)
+	}
+
+	canonicalQuery := s3Query(query)
+
+	requestURL := s.endpoint + canonicalURI
+	if canonicalQuery != "" {
+		requestURL += "?" + canonicalQuery
+	}
+
+	// An empty body would otherwise be sent chunked, which S3 doesn't accept.
+	if body != nil && size == 0 {
+		body = http.NoBody
+	}
+
+	req, err := http.NewRequest(method, requestURL, body)
+	if err != nil {
+		return nil, err
+	}
+
+	if body != nil {
+		req.ContentLength = size
+	}
+
+	s.sign(req, canonicalURI, canonicalQuery, time.Now().UTC())
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusNotFound && key != "" {
+		resp.Body.Close()
+		return nil, ErrNotFound
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+
+		return nil, fmt.Errorf("S3 request %s %s failed with %s: %s", method, canonicalURI, resp.Status, strings.TrimSpace(string(message)))
+	}
+
+	return resp, nil
+}
+
+// sign adds the AWS Signature Version 4 headers to a request.
+func (s *s3Store) sign(req *http.Request, canonicalURI string, canonicalQuery string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", date, s.region)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", s3UnsignedPayload)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalHeaders := fmt.Sprintf("host:%s\nx-amz-content-sha256:%s\nx-amz-date:%s\n", req.URL.Host, s3UnsignedPayload, amzDate)
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		canonicalURI,
+		canonicalQuery,
+		canonicalHeaders,
+		signedHeaders,
+		s3UnsignedPayload,
+	}, "\n")
+
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, hex.EncodeToString(requestHash[:])}, "\n")
+
+	signingKey := hmacSHA256([]byte("AWS4"+s.secretKey), date)
+	signingKey = hmacSHA256(signingKey, s.region)
+	signingKey = hmacSHA256(signingKey, "s3")
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", s.accessKey, scope, signedHeaders, signature))
+}
+
+// hmacSHA256 returns the HMAC-SHA256 of data.
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+
+	return mac.Sum(nil)
+}
+
+// s3Query returns the canonical query string: sorted and escaped the way Signature Version 4 expects.
+func s3Query(query url.Values) string {
+	var params []string
+
+	for name, values := range query {
+		for _, value := range values {
+			params = append(params, s3Escape(name, false)+"="+s3Escape(value, false))
+		}
+	}
+
+	sort.Strings(params)
+
+	return strings.Join(params, "&")
+}
+
+// s3Escape percent-encodes everything but unreserved characters, and slashes if keepSlash is set.
+func s3Escape(s string, keepSlash bool) string {
+	var escaped strings.Builder
+
+	for _, b := range []byte(s) {
+		switch {
+		case 'A' <= b && b <= 'Z', 'a' <= b && b <= 'z', '0' <= b && b <= '9', b == '-', b == '_', b == '.', b == '~':
+			escaped.WriteByte(b)
+		case b == '/' && keepSlash:
+			escaped.WriteByte(b)
+		default:
+			fmt.Fprintf(&escaped, "%%%02X", b)
+		}
+	}
+
+	return escaped.String()
+}
@@ -0,0 +1,242 @@
+package volumes
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeS3 is a bucket of an S3 compatible service that verifies the Signature Version 4 of every request
+// the way S3 does, from the request it received. Lists return a single key per page.
+type fakeS3 struct {
+	t         *testing.T
+	bucket    string
+	region    string
+	accessKey string
+	secretKey string
+
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+// ServeHTTP handles the object and bucket requests of the s3 store.
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := f.verify(r); err != nil {
+		f.t.Logf("rejecting %s %s: %s", r.Method, r.RequestURI, err)
+		http.Error(w, "SignatureDoesNotMatch", http.StatusForbidden)
+		return
+	}
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket != f.bucket {
+		http.Error(w, "NoSuchBucket", http.StatusNotFound)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch {
+	case r.Method == http.MethodPut:
+		data, err := io.ReadAll(r.Body)
+		if err != nil || int64(len(data)) != r.ContentLength {
+			http.Error(w, "IncompleteBody", http.StatusBadRequest)
+			return
+		}
+
+		f.objects[key] = data
+	case r.Method == http.MethodGet && key == "":
+		f.list(w, r.URL.Query())
+	case r.Method == http.MethodGet:
+		data, ok := f.objects[key]
+		if !ok {
+			http.Error(w, "NoSuchKey", http.StatusNotFound)
+			return
+		}
+
+		_, _ = w.Write(data)
+	case r.Method == http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// list writes a ListObjectsV2 response with the first key under the prefix after the continuation token.
+func (f *fakeS3) list(w http.ResponseWriter, query url.Values) {
+	var keys []string
+
+	for key := range f.objects {
+		if strings.HasPrefix(key, query.Get("prefix")) && key > query.Get("continuation-token") {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Strings(keys)
+
+	var result s3ListResult
+
+	if len(keys) > 0 {
+		result.Contents = append(result.Contents, struct {
+			Key string `xml:"Key"`
+		}{Key: keys[0]})
+	}
+
+	if len(keys) > 1 {
+		result.IsTruncated = true
+		result.NextContinuationToken = keys[0]
+	}
+
+	_ = xml.NewEncoder(w).Encode(result)
+}
+
+// verify checks the Signature Version 4 of a request.
+func (f *fakeS3) verify(r *http.Request) error {
+	amzDate := r.Header.Get("X-Amz-Date")
+	if len(amzDate) != len("20060102T150405Z") {
+		return fmt.Errorf("invalid date %q", amzDate)
+	}
+
+	scope := amzDate[:8] + "/" + f.region + "/s3/aws4_request"
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+
+	// The path is signed as sent, the query sorted and escaped with %20 for spaces.
+	canonicalURI, _, _ := strings.Cut(r.RequestURI, "?")
+
+	var params []string
+
+	for name, values := range r.URL.Query() {
+		for _, value := range values {
+			params = append(params, awsEscape(name)+"="+awsEscape(value))
+		}
+	}
+
+	sort.Strings(params)
+
+	canonicalRequest := fmt.Sprintf("%s\n%s\n%s\nhost:%s\nx-amz-content-sha256:%s\nx-amz-date:%s\n\n%s\n%s",
+		r.Method, canonicalURI, strings.Join(params, "&"), r.Host, r.Header.Get("X-Amz-Content-Sha256"), amzDate, signedHeaders, r.Header.Get("X-Amz-Content-Sha256"))
+
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := []byte("AWS4" + f.secretKey)
+	for _, part := range []string{amzDate[:8], f.region, "s3", "aws4_request", stringToSign} {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(part))
+		key = mac.Sum(nil)
+	}
+
+	want := fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", f.accessKey, scope, signedHeaders, hex.EncodeToString(key))
+	if r.Header.Get("Authorization") != want {
+		return fmt.Errorf("authorization %q, want %q", r.Header.Get("Authorization"), want)
+	}
+
+	return nil
+}
+
+// awsEscape percent-encodes a query component the way Signature Version 4 expects.
+func awsEscape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+// newFakeS3 starts a fake S3 service and returns a store using it.
+func newFakeS3(t *testing.T) (*s3Store, *fakeS3) {
+	t.Helper()
+
+	fake := &fakeS3{
+		t:         t,
+		bucket:    "pcompose-backups",
+		region:    "eu-central-1",
+		accessKey: "AKIAEXAMPLE",
+		secretKey: "c2VjcmV0/key+example",
+		objects:   map[string][]byte{},
+	}
+
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	return &s3Store{
+		endpoint:  server.URL,
+		bucket:    fake.bucket,
+		region:    fake.region,
+		accessKey: fake.accessKey,
+		secretKey: fake.secretKey,
+	}, fake
+}
+
+func TestS3Store(t *testing.T) {
+	store, _ := newFakeS3(t)
+
+	files := map[string]string{
+		"alice/app/1/manifest.json":                   `{"id": "1"}`,
+		"alice/app/1/volumes/alice_app_db.tar.gz":     "volume",
+		"alice/app/1/binds/data/my uploads+1~.tar.gz": "bind",
+		"alice/app/1/shared.tar.gz":                   "",
+		"alice/other/1/manifest.json":                 `{"id": "1"}`,
+	}
+
+	for key, data := range files {
+		err := store.Put(key, strings.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatalf("Put(%s) error = %v", key, err)
+		}
+	}
+
+	for key, data := range files {
+		r, err := store.Get(key)
+		if err != nil {
+			t.Fatalf("Get(%s) error = %v", key, err)
+		}
+
+		got, err := io.ReadAll(r)
+		r.Close()
+
+		if err != nil || string(got) != data {
+			t.Errorf("Get(%s) = %q, %v, want %q", key, got, err, data)
+		}
+	}
+
+	keys, err := store.List("alice/app")
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+
+	want := "alice/app/1/binds/data/my uploads+1~.tar.gz,alice/app/1/manifest.json,alice/app/1/shared.tar.gz,alice/app/1/volumes/alice_app_db.tar.gz"
+	if strings.Join(keys, ",") != want {
+		t.Errorf("List() = %v, want %s", keys, want)
+	}
+
+	err = store.Delete("alice/app/1/manifest.json")
+	if err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+
+	_, err = store.Get("alice/app/1/manifest.json")
+	if err != ErrNotFound {
+		t.Errorf("Get() of a deleted object error = %v, want ErrNotFound", err)
+	}
+
+	err = store.Delete("alice/app/1/manifest.json")
+	if err != nil {
+		t.Errorf("Delete() of a missing object error = %v", err)
+	}
+}
+
+func TestS3StoreWrongSecret(t *testing.T) {
+	store, _ := newFakeS3(t)
+	store.secretKey = "wrong"
+
+	err := store.Put("alice/app/1/manifest.json", strings.NewReader("{}"), 2)
+	if err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("Put() error = %v, want a 403", err)
+	}
+}
@@ -0,0 +1,139 @@
+package volumes
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// ErrNotFound is returned when a backup or one of its files doesn't exist.
+var ErrNotFound = errors.New("backup not found")
+
+// Store holds backup files by key, e.g. user/httpbin/<id>/manifest.json.
+type Store interface {
+	// Put stores size bytes read from r.
+	Put(key string, r io.Reader, size int64) error
+
+	// Get opens a stored file. It returns ErrNotFound if the file doesn't exist.
+	Get(key string) (io.ReadCloser, error)
+
+	// List returns the keys of the files under a prefix.
+	List(prefix string) ([]string, error)
+
+	// Delete removes a file.
+	Delete(key string) error
+}
+
+// NewStore returns the S3 store if volume-backups-s3-endpoint is set, otherwise the local store.
+func NewStore() Store {
+	if endpoint := viper.GetString("volume-backups-s3-endpoint"); endpoint != "" {
+		return &s3Store{
+			endpoint:  strings.TrimSuffix(endpoint, "/"),
+			bucket:    viper.GetString("volume-backups-s3-bucket"),
+			region:    viper.GetString("volume-backups-s3-region"),
+			accessKey: viper.GetString("volume-backups-s3-access-key"),
+			secretKey: viper.GetString("volume-backups-s3-secret-key"),
+		}
+	}
+
+	return localStore{root: viper.GetString("volume-backups-directory")}
+}
+
+// localStore stores backups in a directory.
+type localStore struct {
+	root string
+}
+
+// Put writes a file atomically, so a backup that is being written isn't listed as complete.
+func (s localStore) Put(key string, r io.Reader, size int64) error {
+	file := filepath.Join(s.root, filepath.FromSlash(key))
+
+	err := os.MkdirAll(filepath.Dir(file), os.FileMode(0700))
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(file+".tmp", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(0600))
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(f, r)
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(file+".tmp", file)
+}
+
+// Get opens a file.
+func (s localStore) Get(key string) (io.ReadCloser, error) {
+	f, err := os.Open(filepath.Join(s.root, filepath.FromSlash(key)))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+
+	return f, err
+}
+
+// List walks the directory of a prefix.
+func (s localStore) List(prefix string) ([]string, error) {
+	var keys []string
+
+	dir := filepath.Join(s.root, filepath.FromSlash(prefix))
+
+	err := filepath.WalkDir(dir, func(walkPath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() || strings.HasSuffix(walkPath, ".tmp") {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(dir, walkPath)
+		if err != nil {
+			return err
+		}
+
+		keys = append(keys, path.Join(prefix, filepath.ToSlash(relPath)))
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	return keys, err
+}
+
+// Delete removes a file and the directories it leaves empty.
+func (s localStore) Delete(key string) error {
+	file := filepath.Join(s.root, filepath.FromSlash(key))
+
+	err := os.Remove(file)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	root := filepath.Clean(s.root)
+
+	for dir := filepath.Dir(file); dir != root && strings.HasPrefix(dir, root); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,575 @@
+// Package volumes implements backing up and restoring the volumes of deployed projects used by pcompose
+package volumes
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/antoniomika/pcompose/deploy"
+	"github.com/antoniomika/pcompose/project"
+	"github.com/spf13/viper"
+)
+
+const (
+	// TriggerManual is used for backups started using the pcompose backup command.
+	TriggerManual = "manual"
+
+	// TriggerSchedule is used for backups started because the backup interval of the project passed.
+	TriggerSchedule = "schedule"
+
+	// manifestName is the file of a backup that describes it. It is written last, so only complete backups are listed.
+	manifestName = "manifest.json"
+
+	// sharedName is the file of a backup that holds the shared directory of the project.
+	sharedName = "shared.tar.gz"
+
+	// bindsDir is the directory of a backup that holds the relative bind mounts of the project.
+	bindsDir = "binds"
+
+	// scheduleCheckInterval is how often projects are checked for being due to be backed up.
+	scheduleCheckInterval = time.Minute
+)
+
+// ErrNothingToBackUp is returned when backing up a project that has neither volumes, bind mounts nor shared data.
+var ErrNothingToBackUp = errors.New("the project has no volumes, bind mounts or shared data to back up")
+
+var (
+	// lastBackupMu protects lastBackup.
+	lastBackupMu sync.Mutex
+
+	// lastBackup holds when each project was last backed up, to avoid listing backups every time the schedule is checked.
+	lastBackup = map[string]time.Time{}
+)
+
+// Backup describes a backup of the volumes and shared directory of a project.
+type Backup struct {
+	ID       string        `json:"id"`
+	Project  string        `json:"project"`
+	Trigger  string        `json:"trigger"`
+	Created  time.Time     `json:"created"`
+	Duration time.Duration `json:"duration"`
+	Volumes  []Volume      `json:"volumes"`
+	Binds    []Bind        `json:"binds,omitempty"`
+	Shared   bool          `json:"shared"`
+	Size     int64         `json:"size"`
+}
+
+// Volume is a named volume of a backup.
+type Volume struct {
+	// Name is the name of the docker volume, e.g. user_httpbin_db.
+	Name string `json:"name"`
+
+	// ComposeName is the name of the volume in the compose file, e.g. db.
+	ComposeName string `json:"compose_name,omitempty"`
+
+	// Size is the size of the compressed volume archive.
+	Size int64 `json:"size"`
+}
+
+// Create backs up the named volumes, relative bind mounts and the shared directory of a project, stopping the services
+// configured in the backup settings while doing so. Backups exceeding the retention settings of the
+// project are removed afterwards.
+func Create(p project.Project, trigger string, output io.Writer) (*Backup, error) {
+	unlock, err := deploy.Lock(p)
+	if err != nil {
+		return nil, fmt.Errorf("error locking project: %w", err)
+	}
+
+	defer unlock()
+
+	start := time.Now()
+
+	backup := &Backup{
+		ID:      deploy.NewID(),
+		Project: p.FullName(),
+		Trigger: trigger,
+		Created: start,
+	}
+
+	volumes, err := projectVolumes(p)
+	if err != nil {
+		return nil, err
+	}
+
+	binds, skipped, err := projectBinds(p)
+	if err != nil {
+		return nil, err
+	}
+
+	// Bind mounts outside of the project aren't pcompose's to back up, but shouldn't be missed silently.
+	for _, reason := range skipped {
+		fmt.Fprintf(output, "Not backing up bind mount %s\n", reason)
+	}
+
+	hasShared := !isEmptyDir(p.SharedDir())
+
+	if len(volumes) == 0 && len(binds) == 0 && !hasShared {
+		return nil, ErrNothingToBackUp
+	}
+
+	tmpDir, err := os.MkdirTemp("", "pcompose-volumes")
+	if err != nil {
+		return nil, err
+	}
+
+	defer os.RemoveAll(tmpDir)
+
+	if services := p.Config().Backups.Stop; len(services) > 0 {
+		fmt.Fprintf(output, "Stopping %s\n", strings.Join(services, ", "))
+
+		err := compose(p, output, append([]string{"stop"}, services...)...)
+		if err != nil {
+			return nil, fmt.Errorf("error stopping services: %w", err)
+		}
+
+		defer func() {
+			fmt.Fprintf(output, "Starting %s\n", strings.Join(services, ", "))
+
+			err := compose(p, output, append([]string{"start"}, services...)...)
+			if err != nil {
+				log.Printf("Error starting services of %s after backup: %s", p.FullName(), err)
+			}
+		}()
+	}
+
+	store := NewStore()
+	archive := path.Join(tmpDir, "archive.tar.gz")
+
+	for _, volume := range volumes {
+		fmt.Fprintf(output, "Backing up volume %s\n", volume.Name)
+
+		err := dumpVolume(volume.Name, archive)
+		if err != nil {
+			return nil, fmt.Errorf("error backing up volume %s: %w", volume.Name, err)
+		}
+
+		volume.Size, err = putFile(store, backupKey(p, backup.ID, "volumes", volume.Name+".tar.gz"), archive)
+		if err != nil {
+			return nil, fmt.Errorf("error storing volume %s: %w", volume.Name, err)
+		}
+
+		backup.Volumes = append(backup.Volumes, volume)
+		backup.Size += volume.Size
+	}
+
+	for _, bind := range binds {
+		fmt.Fprintf(output, "Backing up bind mount %s\n", bind.Path)
+
+		err := archiveDir(path.Join(p.ComposeDir(), bind.Path), archive)
+		if err != nil {
+			return nil, fmt.Errorf("error backing up bind mount %s: %w", bind.Path, err)
+		}
+
+		bind.Size, err = putFile(store, backupKey(p, backup.ID, bindsDir, bind.Path+".tar.gz"), archive)
+		if err != nil {
+			return nil, fmt.Errorf("error storing bind mount %s: %w", bind.Path, err)
+		}
+
+		backup.Binds = append(backup.Binds, bind)
+		backup.Size += bind.Size
+	}
+
+	if hasShared {
+		fmt.Fprintln(output, "Backing up the shared directory")
+
+		err := archiveDir(p.SharedDir(), archive)
+		if err != nil {
+			return nil, fmt.Errorf("error backing up the shared directory: %w", err)
+		}
+
+		size, err := putFile(store, backupKey(p, backup.ID, sharedName), archive)
+		if err != nil {
+			return nil, fmt.Errorf("error storing the shared directory: %w", err)
+		}
+
+		backup.Shared = true
+		backup.Size += size
+	}
+
+	backup.Duration = time.Since(start)
+
+	data, err := json.MarshalIndent(backup, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	err = store.Put(backupKey(p, backup.ID, manifestName), bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("error storing backup manifest: %w", err)
+	}
+
+	lastBackupMu.Lock()
+	lastBackup[p.FullName()] = backup.Created
+	lastBackupMu.Unlock()
+
+	prune(p, store)
+
+	return backup, nil
+}
+
+// Restore replaces the named volumes, relative bind mounts and the shared directory of a project with a backup. The
+// containers of the project are stopped while restoring and started again afterwards.
+func Restore(p project.Project, id string, output io.Writer) error {
+	backup, err := Get(p, id)
+	if err != nil {
+		return err
+	}
+
+	unlock, err := deploy.Lock(p)
+	if err != nil {
+		return fmt.Errorf("error locking project: %w", err)
+	}
+
+	defer unlock()
+
+	fmt.Fprintf(output, "Stopping %s\n", p.FullName())
+
+	err = compose(p, output, "stop")
+	if err != nil {
+		return fmt.Errorf("error stopping project: %w", err)
+	}
+
+	defer func() {
+		fmt.Fprintf(output, "Starting %s\n", p.FullName())
+
+		err := compose(p, output, "start")
+		if err != nil {
+			log.Printf("Error starting %s after restoring backup: %s", p.FullName(), err)
+		}
+	}()
+
+	store := NewStore()
+
+	for _, volume := range backup.Volumes {
+		fmt.Fprintf(output, "Restoring volume %s\n", volume.Name)
+
+		archive, err := store.Get(backupKey(p, backup.ID, "volumes", volume.Name+".tar.gz"))
+		if err != nil {
+			return fmt.Errorf("error reading volume %s: %w", volume.Name, err)
+		}
+
+		err = loadVolume(p, volume, archive)
+		archive.Close()
+
+		if err != nil {
+			return fmt.Errorf("error restoring volume %s: %w", volume.Name, err)
+		}
+	}
+
+	for _, bind := range backup.Binds {
+		fmt.Fprintf(output, "Restoring bind mount %s\n", bind.Path)
+
+		archive, err := store.Get(backupKey(p, backup.ID, bindsDir, bind.Path+".tar.gz"))
+		if err != nil {
+			return fmt.Errorf("error reading bind mount %s: %w", bind.Path, err)
+		}
+
+		err = extractDir(archive, path.Join(p.ComposeDir(), bind.Path))
+		archive.Close()
+
+		if err != nil {
+			return fmt.Errorf("error restoring bind mount %s: %w", bind.Path, err)
+		}
+	}
+
+	if backup.Shared {
+		fmt.Fprintln(output, "Restoring the shared directory")
+
+		archive, err := store.Get(backupKey(p, backup.ID, sharedName))
+		if err != nil {
+			return fmt.Errorf("error reading the shared directory: %w", err)
+		}
+
+		err = extractDir(archive, p.SharedDir())
+		archive.Close()
+
+		if err != nil {
+			return fmt.Errorf("error restoring the shared directory: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// List returns the complete backups of a project, newest first.
+func List(p project.Project) ([]*Backup, error) {
+	store := NewStore()
+	prefix := p.FullName()
+
+	keys, err := store.List(prefix)
+	if err != nil {
+		return nil, fmt.Errorf("error listing backups: %w", err)
+	}
+
+	backups := []*Backup{}
+
+	for _, key := range keys {
+		id, name, ok := strings.Cut(strings.TrimPrefix(key, prefix+"/"), "/")
+		if !ok || name != manifestName {
+			continue
+		}
+
+		backup, err := Get(p, id)
+		if err != nil {
+			log.Printf("Error reading backup %s of %s: %s", id, p.FullName(), err)
+			continue
+		}
+
+		backups = append(backups, backup)
+	}
+
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].ID > backups[j].ID
+	})
+
+	return backups, nil
+}
+
+// Get returns a complete backup of a project.
+func Get(p project.Project, id string) (*Backup, error) {
+	if id == "" || strings.ContainsAny(id, "/.") {
+		return nil, ErrNotFound
+	}
+
+	manifest, err := NewStore().Get(backupKey(p, id, manifestName))
+	if err != nil {
+		return nil, err
+	}
+
+	defer manifest.Close()
+
+	var backup Backup
+
+	err = json.NewDecoder(manifest).Decode(&backup)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing backup manifest: %w", err)
+	}
+
+	return &backup, nil
+}
+
+// Start backs up the projects whose backup interval passed since their last backup.
+func Start() {
+	for {
+		projects, err := project.List()
+		if err != nil {
+			log.Println("Error listing projects:", err)
+		}
+
+		for _, p := range projects {
+			interval := p.Config().Backups.Interval
+			if interval <= 0 || !due(p, interval) {
+				continue
+			}
+
+			backup, err := Create(p, TriggerSchedule, io.Discard)
+			if err != nil {
+				log.Printf("Error backing up %s: %s", p.FullName(), err)
+
+				// Don't retry until the next interval.
+				lastBackupMu.Lock()
+				lastBackup[p.FullName()] = time.Now()
+				lastBackupMu.Unlock()
+
+				continue
+			}
+
+			log.Printf("Backed up %s as %s", p.FullName(), backup.ID)
+		}
+
+		time.Sleep(scheduleCheckInterval)
+	}
+}
+
+// due returns whether the backup interval of a project passed since its last backup.
+func due(p project.Project, interval time.Duration) bool {
+	lastBackupMu.Lock()
+	last, ok := lastBackup[p.FullName()]
+	lastBackupMu.Unlock()
+
+	if !ok {
+		backups, err := List(p)
+		if err != nil {
+			log.Printf("Error listing backups of %s: %s", p.FullName(), err)
+			return false
+		}
+
+		if len(backups) > 0 {
+			last = backups[0].Created
+		}
+
+		lastBackupMu.Lock()
+		lastBackup[p.FullName()] = last
+		lastBackupMu.Unlock()
+	}
+
+	return time.Since(last) >= interval
+}
+
+// prune removes the backups of a project that exceed the number of backups to keep or are older
+// than the maximum age. The newest backup is always kept.
+func prune(p project.Project, store Store) {
+	config := p.Config().Backups
+	if config.Keep <= 0 && config.MaxAge <= 0 {
+		return
+	}
+
+	backups, err := List(p)
+	if err != nil {
+		log.Printf("Error listing backups of %s: %s", p.FullName(), err)
+		return
+	}
+
+	for i, backup := range backups {
+		if i == 0 {
+			continue
+		}
+
+		if (config.Keep > 0 && i >= config.Keep) || (config.MaxAge > 0 && time.Since(backup.Created) > config.MaxAge) {
+			err := remove(p, store, backup.ID)
+			if err != nil {
+				log.Printf("Error removing backup %s of %s: %s", backup.ID, p.FullName(), err)
+			}
+		}
+	}
+}
+
+// remove deletes the files of a backup, the manifest first so the backup isn't listed while it is removed.
+func remove(p project.Project, store Store, id string) error {
+	err := store.Delete(backupKey(p, id, manifestName))
+	if err != nil {
+		return err
+	}
+
+	keys, err := store.List(path.Join(p.FullName(), id))
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		err := store.Delete(key)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// backupKey returns the key of a file of a backup, e.g. user/httpbin/<id>/volumes/user_httpbin_db.tar.gz.
+func backupKey(p project.Project, id string, elem ...string) string {
+	return path.Join(append([]string{p.FullName(), id}, elem...)...)
+}
+
+// projectVolumes returns the named volumes docker-compose created for a project.
+func projectVolumes(p project.Project) ([]Volume, error) {
+	lsCmd := exec.Command("docker", "volume", "ls", "--filter", fmt.Sprintf("label=com.docker.compose.project=%s", p.ComposeProject()), "--format", `{{.Name}}	{{.Label "com.docker.compose.volume"}}`)
+
+	output, err := lsCmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("error listing volumes: %w", err)
+	}
+
+	var volumes []Volume
+
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		name, composeName, _ := strings.Cut(line, "\t")
+		if name == "" {
+			continue
+		}
+
+		volumes = append(volumes, Volume{
+			Name:        name,
+			ComposeName: composeName,
+		})
+	}
+
+	return volumes, nil
+}
+
+// dumpVolume writes a gzipped tar archive of the contents of a volume to a file using a helper container.
+func dumpVolume(name string, archive string) error {
+	f, err := os.Create(archive)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	var stderr bytes.Buffer
+
+	dumpCmd := exec.Command("docker", "run", "--rm", "-v", name+":/volume:ro", viper.GetString("volume-backups-image"), "tar", "-czf", "-", "-C", "/volume", ".")
+	dumpCmd.Stdout = f
+	dumpCmd.Stderr = &stderr
+
+	err = dumpCmd.Run()
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	return f.Close()
+}
+
+// loadVolume replaces the contents of a volume with an archive using a helper container. The volume
+// is created with the labels docker-compose expects if it doesn't exist.
+func loadVolume(p project.Project, volume Volume, archive io.Reader) error {
+	createArgs := []string{"volume", "create", "--label", fmt.Sprintf("com.docker.compose.project=%s", p.ComposeProject())}
+	if volume.ComposeName != "" {
+		createArgs = append(createArgs, "--label", fmt.Sprintf("com.docker.compose.volume=%s", volume.ComposeName))
+	}
+
+	err := exec.Command("docker", append(createArgs, volume.Name)...).Run()
+	if err != nil {
+		return fmt.Errorf("error creating volume: %w", err)
+	}
+
+	var stderr bytes.Buffer
+
+	loadCmd := exec.Command("docker", "run", "--rm", "-i", "-v", volume.Name+":/volume", viper.GetString("volume-backups-image"), "sh", "-c", "find /volume -mindepth 1 -delete && tar -xzf - -C /volume")
+	loadCmd.Stdin = archive
+	loadCmd.Stderr = &stderr
+
+	err = loadCmd.Run()
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	return nil
+}
+
+// putFile stores a file and returns its size.
+func putFile(store Store, key string, file string) (int64, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return 0, err
+	}
+
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	return info.Size(), store.Put(key, f, info.Size())
+}
+
+// compose runs a docker-compose command of a project, writing its output to output.
+func compose(p project.Project, output io.Writer, args ...string) error {
+	cmd := deploy.Compose(p, args...)
+	cmd.Stdout = output
+	cmd.Stderr = output
+
+	return cmd.Run()
+}